@@ -8,6 +8,19 @@ import (
 	"time"
 
 	"github.com/CatalinPlesu/user-service/messaging"
+	"github.com/CatalinPlesu/user-service/repository/breached"
+	"github.com/CatalinPlesu/user-service/repository/block"
+	"github.com/CatalinPlesu/user-service/repository/contact"
+	"github.com/CatalinPlesu/user-service/repository/erasure"
+	"github.com/CatalinPlesu/user-service/repository/eventstream"
+	"github.com/CatalinPlesu/user-service/repository/export"
+	"github.com/CatalinPlesu/user-service/repository/jwts"
+	"github.com/CatalinPlesu/user-service/repository/mfa"
+	"github.com/CatalinPlesu/user-service/repository/outbox"
+	"github.com/CatalinPlesu/user-service/repository/passkey"
+	"github.com/CatalinPlesu/user-service/repository/storage"
+	"github.com/CatalinPlesu/user-service/repository/user"
+	"github.com/CatalinPlesu/user-service/repository/webhook"
 	"github.com/redis/go-redis/v9"
 	"github.com/uptrace/bun"
 	"github.com/uptrace/bun/dialect/pgdialect"
@@ -19,6 +32,10 @@ type App struct {
 	rdb      *redis.Client
 	db       *bun.DB
 	rabbitMQ *messaging.RabbitMQ
+	breached *breached.Monitor
+	stream   *eventstream.Hub
+	exports  storage.BlobStore
+	avatars  storage.BlobStore
 	config   Config
 }
 
@@ -32,12 +49,25 @@ func New(config Config) *App {
 
 	db := bun.NewDB(sqlDB, pgdialect.New())
 
-	rabitMQ, _ := messaging.NewRabbitMQ(config.RabitMQURL)
+	rabitMQ := messaging.NewRabbitMQ(config.RabitMQURL, config.RabbitMQBufferSize)
+	rabitMQ.ConfirmTimeout = config.RabbitMQConfirmTimeout
+
+	screener, err := breached.Load(config.BreachedPasswordsPath)
+	if err != nil {
+		fmt.Println("breached password screening unavailable:", err)
+	}
+
+	// Only public profile changes are streamed to clients.
+	stream := eventstream.NewRedisRepo(rdb, messaging.EventUserProfileUpdated, messaging.EventUserDeleted)
 
 	app := &App{
 		rdb:      rdb,
 		db:       db,
 		rabbitMQ: rabitMQ,
+		breached: breached.NewMonitor(screener, err),
+		stream:   eventstream.NewHub(stream),
+		exports:  storage.NewLocalStore(config.ExportDir),
+		avatars:  storage.NewLocalStore(config.AvatarDir),
 		config:   config,
 	}
 
@@ -47,6 +77,10 @@ func New(config Config) *App {
 }
 
 func (a *App) Start(ctx context.Context) error {
+	if err := a.config.Validate(); err != nil {
+		return fmt.Errorf("invalid config (set DEV_MODE=true to use development keys): %w", err)
+	}
+
 	server := &http.Server{
 		Addr:    fmt.Sprintf(":%d", a.config.ServerPort),
 		Handler: a.router,
@@ -62,6 +96,11 @@ func (a *App) Start(ctx context.Context) error {
 		return fmt.Errorf("failed to connect to PostgreSQL: %w", err)
 	}
 
+	err = a.migrate(ctx)
+	if err != nil {
+		return err
+	}
+
 	defer func() {
 		if err := a.rdb.Close(); err != nil {
 			fmt.Println("failed to close redis", err)
@@ -69,8 +108,35 @@ func (a *App) Start(ctx context.Context) error {
 		if err := a.db.Close(); err != nil {
 			fmt.Println("failed to close database", err)
 		}
+		if err := a.rabbitMQ.Close(); err != nil {
+			fmt.Println("failed to close RabbitMQ", err)
+		}
 	}()
 
+	if err := a.rabbitMQ.Consume(a.commandConsumer()); err != nil {
+		return fmt.Errorf("failed to consume commands: %w", err)
+	}
+	go a.rabbitMQ.Run(ctx)
+
+	mode, err := messaging.ParseContentMode(a.config.EventsMode)
+	if err != nil {
+		return fmt.Errorf("invalid events content mode: %w", err)
+	}
+
+	relay := messaging.NewRelay(outbox.NewPostgresRepo(a.db), a.rabbitMQ, mode)
+	relay.Stream = a.stream.Repo
+	if a.config.EventsIncludeJWT {
+		relay.Sessions = &jwts.RedisRepo{Client: a.rdb}
+	}
+	go relay.Run(ctx)
+	go a.stream.Run(ctx)
+	go a.purgeDeletedUsers(ctx, time.Hour)
+	go a.chainAuditLog(ctx, time.Second)
+	go a.runExports(ctx, 5*time.Second)
+
+	webhooks := messaging.NewWebhookDispatcher(webhook.NewPostgresRepo(a.db))
+	go webhooks.Run(ctx)
+
 	fmt.Println("Starting server")
 
 	ch := make(chan error, 1)
@@ -93,3 +159,28 @@ func (a *App) Start(ctx context.Context) error {
 		return server.Shutdown(timeout)
 	}
 }
+
+func (a *App) migrate(ctx context.Context) error {
+	migrations := []interface {
+		Migrate(ctx context.Context) error
+	}{
+		user.NewPostgresRepo(a.db),
+		mfa.NewPostgresRepo(a.db),
+		passkey.NewPostgresRepo(a.db),
+		outbox.NewPostgresRepo(a.db),
+		webhook.NewPostgresRepo(a.db),
+		export.NewPostgresRepo(a.db),
+		a.userKeys(),
+		erasure.NewPostgresRepo(a.db),
+		a.auditLog(),
+		contact.NewPostgresRepo(a.db),
+		block.NewPostgresRepo(a.db),
+	}
+
+	for _, m := range migrations {
+		if err := m.Migrate(ctx); err != nil {
+			return fmt.Errorf("failed to migrate database: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package application
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const auditChainBatchSize = 500
+
+// chainAuditLog periodically links appended audit entries into the hash
+// chain.
+func (a *App) chainAuditLog(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	auditLog := a.auditLog()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for {
+			n, err := auditLog.Chain(ctx, auditChainBatchSize)
+			if err != nil {
+				fmt.Println("failed to chain audit log:", err)
+			}
+			if err != nil || n < auditChainBatchSize {
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package application
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+
+	"github.com/CatalinPlesu/user-service/handler"
+	"github.com/CatalinPlesu/user-service/messaging"
+	"github.com/CatalinPlesu/user-service/model"
+	"github.com/CatalinPlesu/user-service/repository/audit"
+	"github.com/CatalinPlesu/user-service/repository/block"
+	"github.com/CatalinPlesu/user-service/repository/contact"
+	"github.com/CatalinPlesu/user-service/repository/export"
+	"github.com/CatalinPlesu/user-service/repository/jwts"
+	"github.com/CatalinPlesu/user-service/repository/keys"
+	"github.com/CatalinPlesu/user-service/repository/mfa"
+	"github.com/CatalinPlesu/user-service/repository/passkey"
+	"github.com/CatalinPlesu/user-service/repository/user"
+)
+
+// exportLease is how long an export may stay in processing before another
+// worker assumes the first one died and takes it over.
+const exportLease = 15 * time.Minute
+
+// exportSections lists what goes into a user's data export. Features that
+// store data about users add a section here.
+func (a *App) exportSections() []export.Section {
+	return []export.Section{
+		{Name: "profile", Collect: func(ctx context.Context, userID uuid.UUID) (any, error) {
+			return user.NewPostgresRepo(a.db).FindByID(ctx, userID)
+		}},
+		{Name: "sessions", Collect: func(ctx context.Context, userID uuid.UUID) (any, error) {
+			sessions, err := (&jwts.RedisRepo{Client: a.rdb}).Sessions(ctx, userID)
+			if err != nil {
+				return nil, err
+			}
+
+			type session struct {
+				ID        string    `json:"id"`
+				IssuedAt  time.Time `json:"issued_at"`
+				ExpiresAt time.Time `json:"expires_at"`
+			}
+			exported := make([]session, len(sessions))
+			for i, s := range sessions {
+				exported[i] = session{ID: s.ID, IssuedAt: s.IssuedAt, ExpiresAt: s.ExpiresAt}
+			}
+			return exported, nil
+		}},
+		{Name: "mfa", Collect: func(ctx context.Context, userID uuid.UUID) (any, error) {
+			enrollment, err := mfa.NewPostgresRepo(a.db).FindByUserID(ctx, userID)
+			if errors.Is(err, mfa.ErrNotExist) {
+				return nil, nil
+			}
+			return enrollment, err
+		}},
+		{Name: "passkeys", Collect: func(ctx context.Context, userID uuid.UUID) (any, error) {
+			return passkey.NewPostgresRepo(a.db).FindByUserID(ctx, userID)
+		}},
+		{Name: "audit", Collect: func(ctx context.Context, userID uuid.UUID) (any, error) {
+			entries := []model.AuditEntry{}
+			filter := audit.Filter{UserID: &userID, Limit: 1000}
+			for {
+				page, err := a.auditLog().Query(ctx, filter)
+				if err != nil {
+					return nil, err
+				}
+				entries = append(entries, page...)
+				if len(page) < filter.Limit {
+					return entries, nil
+				}
+				filter.BeforeID = page[len(page)-1].ID
+			}
+		}},
+		{Name: "contacts", Collect: func(ctx context.Context, userID uuid.UUID) (any, error) {
+			contacts := []model.Contact{}
+			page := contact.Page{Limit: 1000}
+			for {
+				found, err := contact.NewPostgresRepo(a.db).List(ctx, userID, page)
+				if err != nil {
+					return nil, err
+				}
+				contacts = append(contacts, found...)
+				if len(found) < page.Limit {
+					return contacts, nil
+				}
+				page.After = found[len(found)-1].ContactID
+			}
+		}},
+		{Name: "blocks", Collect: func(ctx context.Context, userID uuid.UUID) (any, error) {
+			blocks := []model.Block{}
+			page := block.Page{Limit: 1000}
+			for {
+				found, err := block.NewPostgresRepo(a.db).List(ctx, userID, page)
+				if err != nil {
+					return nil, err
+				}
+				blocks = append(blocks, found...)
+				if len(found) < page.Limit {
+					return blocks, nil
+				}
+				page.After = found[len(found)-1].BlockedID
+			}
+		}},
+	}
+}
+
+// runExports assembles requested data exports one at a time and deletes
+// archives whose download link has expired.
+func (a *App) runExports(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	lastCleanup := time.Time{}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for {
+			err := a.exportOnce(ctx)
+			if errors.Is(err, export.ErrNotExist) {
+				break
+			} else if err != nil {
+				fmt.Println("failed to export user data:", err)
+				break
+			}
+		}
+
+		if now := time.Now(); now.Sub(lastCleanup) > time.Hour {
+			lastCleanup = now
+			if err := a.expireExports(ctx, now.UTC()); err != nil {
+				fmt.Println("failed to expire data exports:", err)
+			}
+		}
+	}
+}
+
+// exportOnce builds the oldest pending export. It returns export.ErrNotExist
+// when there is none.
+func (a *App) exportOnce(ctx context.Context) error {
+	repo := export.NewPostgresRepo(a.db)
+
+	claimed, err := repo.Claim(ctx, time.Now().UTC(), exportLease)
+	if err != nil {
+		return err
+	}
+
+	err = a.buildExport(ctx, claimed)
+	if err != nil {
+		if markErr := repo.MarkFailed(ctx, claimed.ID, err, time.Now().UTC()); markErr != nil {
+			return markErr
+		}
+		return err
+	}
+	return nil
+}
+
+func (a *App) buildExport(ctx context.Context, claimed *model.DataExport) error {
+	now := time.Now().UTC()
+
+	var archive bytes.Buffer
+	if err := export.WriteArchive(ctx, &archive, claimed.UserID, a.exportSections(), now); err != nil {
+		return err
+	}
+
+	// Archives are encrypted with the user's key, so erasing the user makes
+	// any copy left behind unreadable.
+	dataKey, err := a.userKeys().DataKey(ctx, claimed.UserID)
+	if err != nil {
+		return err
+	}
+	sealed, err := keys.Seal(dataKey, archive.Bytes())
+	if err != nil {
+		return err
+	}
+
+	size := int64(archive.Len())
+	key := fmt.Sprintf("%s/%s.zip", claimed.UserID, claimed.ID)
+	if err := a.exports.Put(ctx, key, bytes.NewReader(sealed)); err != nil {
+		return err
+	}
+
+	expiresAt := now.Add(a.config.ExportTTL)
+	return a.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		if err := export.NewPostgresRepo(tx).MarkReady(ctx, claimed.ID, key, size, now, expiresAt); err != nil {
+			return err
+		}
+		return a.outbox().WithTx(tx).Enqueue(ctx, messaging.UserExportReady{
+			UserID:       claimed.UserID,
+			ExportID:     claimed.ID,
+			DownloadPath: handler.ExportDownloadPath(claimed.UserID, claimed.ID),
+			Size:         size,
+			ExpiresAt:    expiresAt,
+		})
+	})
+}
+
+func (a *App) expireExports(ctx context.Context, now time.Time) error {
+	expired, err := export.NewPostgresRepo(a.db).ExpireBefore(ctx, now)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range expired {
+		if err := a.exports.Delete(ctx, e.FileKey); err != nil {
+			return err
+		}
+	}
+	return nil
+}
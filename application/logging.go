@@ -0,0 +1,23 @@
+package application
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/CatalinPlesu/user-service/repository/jwts"
+)
+
+// redactingLogger is the request logger's sink. It strips bearer tokens, which
+// can appear in request URIs, before anything is written.
+type redactingLogger struct {
+	logger *log.Logger
+}
+
+func newRedactingLogger() redactingLogger {
+	return redactingLogger{logger: log.New(os.Stdout, "", log.LstdFlags)}
+}
+
+func (l redactingLogger) Print(v ...interface{}) {
+	l.logger.Print(jwts.Redact(fmt.Sprint(v...)))
+}
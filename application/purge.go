@@ -0,0 +1,75 @@
+package application
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+
+	"github.com/CatalinPlesu/user-service/messaging"
+	"github.com/CatalinPlesu/user-service/repository/avatar"
+	"github.com/CatalinPlesu/user-service/repository/user"
+)
+
+const purgeBatchSize = 100
+
+// purgeDeletedUsers periodically removes users whose deletion grace period
+// is over, announcing each with a user.purged event.
+func (a *App) purgeDeletedUsers(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for {
+			n, err := a.purgeOnce(ctx)
+			if err != nil {
+				fmt.Println("failed to purge deleted users:", err)
+			}
+			if err != nil || n < purgeBatchSize {
+				break
+			}
+		}
+	}
+}
+
+func (a *App) purgeOnce(ctx context.Context) (int, error) {
+	var purged []uuid.UUID
+	err := a.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		now := time.Now().UTC()
+
+		var err error
+		purged, err = user.NewPostgresRepo(tx).PurgeDeletedBefore(ctx, now.Add(-a.config.DeletionGracePeriod), purgeBatchSize)
+		if err != nil {
+			return err
+		}
+
+		events := make([]messaging.Event, len(purged))
+		for i, id := range purged {
+			events[i] = messaging.UserPurged{
+				UserID:   id,
+				PurgedAt: now,
+			}
+		}
+		return a.outbox().WithTx(tx).Enqueue(ctx, events...)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// Avatars live outside the database; a failure leaves files nothing
+	// links to any more.
+	for _, id := range purged {
+		if err := avatar.RemoveAll(ctx, a.avatars, id); err != nil {
+			fmt.Println("failed to remove purged user's avatars:", err)
+		}
+	}
+	return len(purged), nil
+}
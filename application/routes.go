@@ -1,26 +1,55 @@
 package application
 
 import (
+	"fmt"
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
 
 	"github.com/CatalinPlesu/user-service/handler"
+	"github.com/CatalinPlesu/user-service/messaging"
 	"github.com/CatalinPlesu/user-service/repository/user"
+	"github.com/CatalinPlesu/user-service/repository/audit"
+	"github.com/CatalinPlesu/user-service/repository/block"
+	"github.com/CatalinPlesu/user-service/repository/contact"
+	"github.com/CatalinPlesu/user-service/repository/erasure"
+	"github.com/CatalinPlesu/user-service/repository/export"
+	"github.com/CatalinPlesu/user-service/repository/inbox"
 	"github.com/CatalinPlesu/user-service/repository/jwts"
+	"github.com/CatalinPlesu/user-service/repository/keys"
+	"github.com/CatalinPlesu/user-service/repository/lockout"
+	"github.com/CatalinPlesu/user-service/repository/mfa"
+	"github.com/CatalinPlesu/user-service/repository/outbox"
+	"github.com/CatalinPlesu/user-service/repository/passkey"
+	"github.com/CatalinPlesu/user-service/repository/webhook"
 )
 
 func (a *App) loadRoutes() {
 	router := chi.NewRouter()
 
-	router.Use(middleware.Logger)
+	router.Use(middleware.RequestID)
+	router.Use(middleware.RequestLogger(&middleware.DefaultLogFormatter{
+		Logger: newRedactingLogger(),
+	}))
 
 	router.Get("/", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 	})
 
+	healthHandler := &handler.Health{
+		Redis:    a.rdb,
+		DB:       a.db,
+		RabbitMQ: a.rabbitMQ,
+		Breached: a.breached,
+	}
+	router.Get("/health", healthHandler.Check)
+
 	router.Route("/users", a.loadUserRoutes)
+	router.Route("/admin", a.loadAdminRoutes)
+	router.Route("/events", a.loadEventRoutes)
 
 	a.router = router
 }
@@ -31,16 +60,322 @@ func (a *App) loadUserRoutes(router chi.Router) {
 			Client: a.rdb,
 		},
 		PgRepo:   user.NewPostgresRepo(a.db),
-		RabbitMQ: a.rabbitMQ,
+		MfaRepo:  mfa.NewPostgresRepo(a.db),
+		TOTP:     mfa.NewTOTP(a.config.MFAIssuer),
+		Lockout:  a.loginLockout(),
+		Breached: a.breached,
+		Outbox:   a.outbox(),
+		Audit:    a.auditLog(),
+		Contacts: contact.NewPostgresRepo(a.db),
+		Blocks:   a.blocks(),
+
+		DeletionGracePeriod: a.config.DeletionGracePeriod,
+		ReleaseUsernames:    a.config.ReleaseDeletedUsernames,
+
+		BreachedFailClosed: a.config.BreachedPasswordsFailClosed,
+
+		Avatars:            a.avatars,
+		AvatarBaseURL:      a.config.AvatarBaseURL,
+		AvatarMaxBytes:     a.config.AvatarMaxBytes,
+		AvatarMaxDimension: a.config.AvatarMaxDimension,
+	}
+
+	mfaHandler := &handler.MFA{
+		MfaRepo:  mfa.NewPostgresRepo(a.db),
+		UserRepo: user.NewPostgresRepo(a.db),
+		TOTP:     mfa.NewTOTP(a.config.MFAIssuer),
+		Lockout:  a.loginLockout(),
+	}
+
+	authenticator := &handler.Authenticator{
+		RdRepo: &jwts.RedisRepo{
+			Client: a.rdb,
+		},
 	}
 
-	router.Get("/", userHandler.List)
+	exportHandler := &handler.Export{
+		Repo:  export.NewPostgresRepo(a.db),
+		Store: a.exports,
+		Keys:  a.userKeys(),
+	}
+
+	contactsHandler := &handler.Contacts{
+		Repo:   contact.NewPostgresRepo(a.db),
+		Users:  user.NewPostgresRepo(a.db),
+		Blocks: a.blocks(),
+		Outbox: a.outbox(),
+	}
+
+	blocksHandler := &handler.Blocks{
+		Repo:     block.NewPostgresRepo(a.db),
+		Mirror:   a.blocks(),
+		Users:    user.NewPostgresRepo(a.db),
+		Contacts: contact.NewPostgresRepo(a.db),
+		Outbox:   a.outbox(),
+	}
+
+	streamHandler := &handler.Stream{
+		Repo:     a.stream.Repo,
+		Hub:      a.stream,
+		Users:    user.NewPostgresRepo(a.db),
+		Contacts: contact.NewPostgresRepo(a.db),
+		Blocks:   a.blocks(),
+	}
+
+	router.With(authenticator.Identify).Get("/", userHandler.List)
 	router.Post("/register", userHandler.Register)
 	router.Post("/login", userHandler.Login)
+	router.Post("/login/mfa", userHandler.LoginMFA)
 	router.Post("/auth", userHandler.Auth)
-	router.Get("/username/{username}", userHandler.GetByUsername)
-	router.Get("/displayname/{displayname}", userHandler.GetByDisplayName)
-	router.Get("/{id}", userHandler.GetByID)
-	router.Put("/{id}", userHandler.UpdateByID)
-	router.Delete("/{id}", userHandler.DeleteByID)
+	router.With(authenticator.Identify).Get("/username/{username}", userHandler.GetByUsername)
+	router.With(authenticator.Identify).Get("/displayname/{displayname}", userHandler.GetByDisplayName)
+	router.With(authenticator.Identify).Get("/{id}", userHandler.GetByID)
+	router.With(authenticator.Authenticate, handler.RequireSelf).Put("/{id}", userHandler.UpdateByID)
+	router.With(authenticator.Authenticate, handler.RequireSelf).Patch("/{id}", userHandler.PatchByID)
+	router.With(authenticator.Authenticate, handler.RequireSelf).Put("/{id}/password", userHandler.ChangePassword)
+	router.With(authenticator.Identify).Get("/{id}/profile", userHandler.GetProfile)
+	router.With(authenticator.Authenticate, handler.RequireSelf).Get("/{id}/privacy", userHandler.GetPrivacy)
+	router.With(authenticator.Authenticate, handler.RequireSelf).Put("/{id}/privacy", userHandler.UpdatePrivacy)
+	router.With(authenticator.Authenticate, handler.RequireSelf).Put("/{id}/avatar", userHandler.UploadAvatar)
+	router.With(authenticator.Identify).Get("/{id}/avatar", userHandler.GetAvatar)
+	router.With(authenticator.Identify).Get("/{id}/avatar/{hash}/{size}", userHandler.ServeAvatar)
+	router.With(authenticator.Authenticate, handler.RequireSelf).Delete("/{id}", userHandler.DeleteByID)
+	router.With(authenticator.Authenticate, handler.RequireSelf).Delete("/{id}/sessions", userHandler.RevokeSessions)
+	router.With(authenticator.Authenticate).Get("/events", streamHandler.All)
+	router.With(authenticator.Authenticate, handler.RequireSelf).Post("/{id}/export", exportHandler.Request)
+	router.With(authenticator.Authenticate, handler.RequireSelf).Get("/{id}/events", streamHandler.ByUser)
+
+	a.loadPasskeyRoutes(router, authenticator)
+
+	router.Route("/{id}/exports", func(router chi.Router) {
+		router.Use(authenticator.Authenticate, handler.RequireSelf)
+
+		router.Get("/", exportHandler.List)
+		router.Get("/{exportID}", exportHandler.Get)
+		router.Get("/{exportID}/download", exportHandler.Download)
+	})
+
+	router.Route("/{id}/friend-requests", func(router chi.Router) {
+		router.Use(authenticator.Authenticate, handler.RequireSelf)
+
+		router.Get("/", contactsHandler.ListRequests)
+		router.Post("/", contactsHandler.SendRequest)
+		router.Post("/{requestID}/accept", contactsHandler.AcceptRequest)
+		router.Post("/{requestID}/decline", contactsHandler.DeclineRequest)
+		router.Delete("/{requestID}", contactsHandler.CancelRequest)
+	})
+
+	router.Route("/{id}/contacts", func(router chi.Router) {
+		router.Use(authenticator.Authenticate, handler.RequireSelf)
+
+		router.Get("/", contactsHandler.List)
+		router.Get("/mutual/{otherID}", contactsHandler.Mutual)
+		router.Delete("/{contactID}", contactsHandler.Remove)
+	})
+
+	router.Route("/{id}/blocks", func(router chi.Router) {
+		router.Use(authenticator.Authenticate, handler.RequireSelf)
+
+		router.Get("/", blocksHandler.List)
+		router.Post("/{target}", blocksHandler.Block)
+		router.Delete("/{target}", blocksHandler.Unblock)
+	})
+
+	router.Route("/{id}/mfa", func(router chi.Router) {
+		router.Use(authenticator.Authenticate, handler.RequireSelf)
+
+		router.Get("/", mfaHandler.Status)
+		router.Post("/totp", mfaHandler.EnrollTOTP)
+		router.Get("/totp/qr.png", mfaHandler.QRCode)
+		router.Post("/totp/confirm", mfaHandler.ConfirmTOTP)
+		router.Post("/recovery-codes", mfaHandler.RegenerateRecoveryCodes)
+	})
+}
+
+func (a *App) loadPasskeyRoutes(router chi.Router, authenticator *handler.Authenticator) {
+	wa, err := webauthn.New(&webauthn.Config{
+		RPID:                  a.config.WebAuthnRPID,
+		RPDisplayName:         a.config.WebAuthnRPName,
+		RPOrigins:             a.config.WebAuthnOrigins,
+		AttestationPreference: protocol.PreferNoAttestation,
+	})
+	if err != nil {
+		fmt.Println("failed to configure webauthn, passkeys disabled:", err)
+		return
+	}
+
+	passkeyHandler := &handler.Passkey{
+		WebAuthn:    wa,
+		PasskeyRepo: passkey.NewPostgresRepo(a.db),
+		SessionRepo: &passkey.SessionRepo{
+			Client: a.rdb,
+		},
+		UserRepo: user.NewPostgresRepo(a.db),
+		RdRepo: &jwts.RedisRepo{
+			Client: a.rdb,
+		},
+		Outbox:   a.outbox(),
+		Audit:    a.auditLog(),
+		DecoyKey: []byte(a.config.WebAuthnDecoyKey),
+	}
+
+	router.Post("/login/passkey/begin", passkeyHandler.BeginLogin)
+	router.Post("/login/passkey/finish", passkeyHandler.FinishLogin)
+
+	router.Route("/{id}/passkeys", func(router chi.Router) {
+		router.Use(authenticator.Authenticate, handler.RequireSelf)
+
+		router.Get("/", passkeyHandler.List)
+		router.Post("/register/begin", passkeyHandler.BeginRegistration)
+		router.Post("/register/finish", passkeyHandler.FinishRegistration)
+		router.Delete("/{credentialID}", passkeyHandler.Delete)
+	})
+}
+
+func (a *App) loadEventRoutes(router chi.Router) {
+	eventsHandler := &handler.Events{
+		SchemaBase: a.config.EventsSchemaBase,
+	}
+
+	router.Get("/schemas", eventsHandler.ListSchemas)
+	router.Get("/schemas/{type}/{version}", eventsHandler.GetSchema)
+}
+
+func (a *App) loadAdminRoutes(router chi.Router) {
+	router.Use(handler.RequireAdmin(a.config.AdminAPIKey))
+
+	mfaHandler := &handler.MFA{
+		MfaRepo:  mfa.NewPostgresRepo(a.db),
+		UserRepo: user.NewPostgresRepo(a.db),
+		Audit:    a.auditLog(),
+	}
+
+	lockoutHandler := &handler.Lockout{
+		Repo:  a.loginLockout(),
+		Users: user.NewPostgresRepo(a.db),
+	}
+
+	userHandler := &handler.User{
+		RdRepo: &jwts.RedisRepo{
+			Client: a.rdb,
+		},
+		PgRepo: user.NewPostgresRepo(a.db),
+		Outbox: a.outbox(),
+		Audit:  a.auditLog(),
+
+		DeletionGracePeriod: a.config.DeletionGracePeriod,
+	}
+
+	router.Delete("/users/{id}/mfa", mfaHandler.Reset)
+	router.Post("/users/{id}/restore", userHandler.Restore)
+	router.Delete("/lockouts/accounts/{username}", lockoutHandler.UnlockAccount)
+	router.Delete("/lockouts/ips/{ip}", lockoutHandler.UnlockIP)
+
+	erasureHandler := a.erasure()
+
+	router.Post("/users/{id}/erase", erasureHandler.Erase)
+	router.Get("/erasures", erasureHandler.Find)
+	router.Get("/erasures/{id}", erasureHandler.Get)
+
+	auditHandler := &handler.Audit{
+		Repo: a.auditLog(),
+	}
+
+	router.Get("/audit", auditHandler.List)
+	router.Get("/audit/verify", auditHandler.Verify)
+
+	blocksHandler := &handler.Blocks{
+		Mirror: a.blocks(),
+	}
+
+	router.Post("/blocks/check", blocksHandler.Check)
+
+	webhooksHandler := &handler.Webhooks{
+		Repo: webhook.NewPostgresRepo(a.db),
+	}
+
+	router.Route("/webhooks", func(router chi.Router) {
+		router.Get("/", webhooksHandler.List)
+		router.Post("/", webhooksHandler.Create)
+		router.Get("/{id}", webhooksHandler.Get)
+		router.Put("/{id}", webhooksHandler.Update)
+		router.Delete("/{id}", webhooksHandler.Delete)
+		router.Get("/{id}/deliveries", webhooksHandler.ListDeliveries)
+		router.Get("/{id}/deliveries/{deliveryID}/attempts", webhooksHandler.ListAttempts)
+		router.Post("/{id}/deliveries/{deliveryID}/redeliver", webhooksHandler.Redeliver)
+	})
+}
+
+func (a *App) outbox() *messaging.Outbox {
+	return &messaging.Outbox{
+		Repo:       outbox.NewPostgresRepo(a.db),
+		Webhooks:   webhook.NewPostgresRepo(a.db),
+		Exchange:   a.config.EventsExchange,
+		Source:     a.config.EventsSource,
+		SchemaBase: a.config.EventsSchemaBase,
+	}
+}
+
+func (a *App) commandConsumer() *messaging.Consumer {
+	commandsHandler := &handler.Commands{
+		PgRepo: user.NewPostgresRepo(a.db),
+		RdRepo: &jwts.RedisRepo{
+			Client: a.rdb,
+		},
+		Outbox: a.outbox(),
+		Audit:  a.auditLog(),
+	}
+
+	consumer := messaging.NewConsumer(a.config.CommandsExchange, a.config.CommandsQueue, inbox.NewRedisRepo(a.rdb))
+	consumer.Prefetch = a.config.CommandsPrefetch
+	consumer.MaxRetries = a.config.CommandsMaxRetries
+
+	consumer.Handle(messaging.CommandDeactivateUser, commandsHandler.DeactivateUser)
+	consumer.Handle(messaging.CommandRevokeSessions, commandsHandler.RevokeSessions)
+	consumer.Handle(messaging.CommandAcknowledgeErasure, a.erasure().Acknowledge)
+
+	return consumer
+}
+
+func (a *App) erasure() *handler.Erasure {
+	return &handler.Erasure{
+		Users:        user.NewPostgresRepo(a.db),
+		Certificates: erasure.NewPostgresRepo(a.db),
+		Outbox:       a.outbox(),
+		Sessions: &jwts.RedisRepo{
+			Client: a.rdb,
+		},
+		Cache: &user.RedisRepo{
+			Client: a.rdb,
+		},
+		Lockout:      a.loginLockout(),
+		Stream:       a.stream.Repo,
+		Audit:        a.auditLog(),
+		Avatars:      a.avatars,
+		Blocks:       a.blocks(),
+		SubjectKey:   a.config.ErasureSubjectKey,
+		RequiredAcks: a.config.ErasureRequiredAcks,
+	}
+}
+
+func (a *App) blocks() *block.RedisRepo {
+	return block.NewRedisRepo(a.rdb, block.NewPostgresRepo(a.db))
+}
+
+func (a *App) auditLog() *audit.PostgresRepo {
+	return audit.NewPostgresRepo(a.db, a.userKeys())
+}
+
+func (a *App) userKeys() *keys.PostgresRepo {
+	return keys.NewPostgresRepo(a.db, a.config.DataEncryptionKey)
+}
+
+func (a *App) loginLockout() *lockout.RedisRepo {
+	policy := lockout.DefaultPolicy()
+	policy.MaxFailures = a.config.LoginMaxFailures
+	policy.MaxIPFailures = a.config.LoginMaxIPFailures
+	policy.Window = a.config.LoginFailureWindow
+	policy.LockoutDuration = a.config.LoginLockoutDuration
+
+	return lockout.NewRedisRepo(a.rdb, policy)
 }
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/CatalinPlesu/user-service/messaging"
+)
+
+// event-schemas writes the JSON Schema of every event type to dir. With
+// -check it instead verifies the event structs are still compatible with the
+// schemas already in dir, exiting non-zero when a version bump is needed.
+// go test ./messaging runs the same check against the embedded schemas.
+func main() {
+	dir := flag.String("dir", "messaging/schemas", "directory holding published schemas")
+	check := flag.Bool("check", false, "only check compatibility, do not write")
+	flag.Parse()
+
+	problems, err := messaging.CheckSchemas(os.DirFS(*dir))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed to check schemas:", err)
+		os.Exit(1)
+	}
+
+	if len(problems) > 0 {
+		fmt.Fprintln(os.Stderr, "incompatible event changes, bump the schema version in messaging.EventSchemas:")
+		for _, problem := range problems {
+			fmt.Fprintln(os.Stderr, "  "+problem)
+		}
+		os.Exit(1)
+	}
+
+	if *check {
+		return
+	}
+
+	for _, s := range messaging.EventSchemas {
+		data, err := json.MarshalIndent(messaging.GenerateSchema(s), "", "  ")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "failed to marshal schema:", err)
+			os.Exit(1)
+		}
+
+		path := filepath.Join(*dir, messaging.SchemaFileName(s.Type, s.Version))
+		if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+			fmt.Fprintln(os.Stderr, "failed to write schema:", err)
+			os.Exit(1)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/CatalinPlesu/user-service/repository/breached"
+)
+
+// password-filter builds the bloom filter loaded through BREACHED_PASSWORDS_PATH
+// from a wordlist with one password per line, or with -sha1 from a list of
+// "HASH[:COUNT]" lines as published by Pwned Passwords.
+func main() {
+	in := flag.String("in", "", "input wordlist, one entry per line")
+	out := flag.String("out", "breached.bloom", "output filter file")
+	fpRate := flag.Float64("fp", 0.001, "target false positive rate")
+	hashed := flag.Bool("sha1", false, "input lines are SHA-1 hex digests instead of plaintext")
+	flag.Parse()
+
+	if *in == "" {
+		fmt.Fprintln(os.Stderr, "usage: password-filter -in wordlist.txt [-out breached.bloom] [-fp 0.001] [-sha1]")
+		os.Exit(2)
+	}
+	if !(*fpRate > 0 && *fpRate < 1) {
+		fmt.Fprintln(os.Stderr, "-fp must be greater than 0 and less than 1")
+		os.Exit(2)
+	}
+
+	if err := build(*in, *out, *fpRate, *hashed); err != nil {
+		fmt.Fprintln(os.Stderr, "failed to build filter:", err)
+		os.Exit(1)
+	}
+}
+
+func build(in, out string, fpRate float64, hashed bool) error {
+	n, err := countLines(in)
+	if err != nil {
+		return err
+	}
+
+	filter := breached.NewBloomFilter(n, fpRate)
+
+	f, err := os.Open(in)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+
+		if !hashed {
+			filter.Add(line)
+			continue
+		}
+
+		digest, _, _ := strings.Cut(line, ":")
+		raw, err := hex.DecodeString(strings.TrimSpace(digest))
+		if err != nil || len(raw) != sha1.Size {
+			return fmt.Errorf("invalid sha1 line %q", line)
+		}
+
+		var sum [sha1.Size]byte
+		copy(sum[:], raw)
+		filter.AddHash(sum)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	o, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+
+	size, err := filter.WriteTo(o)
+	if err != nil {
+		o.Close()
+		return err
+	}
+	if err := o.Close(); err != nil {
+		return err
+	}
+
+	fmt.Printf("wrote %d entries to %s (%d bytes)\n", filter.Len(), out, size)
+	return nil
+}
+
+func countLines(path string) (uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	var n uint64
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if scanner.Text() != "" {
+			n++
+		}
+	}
+	return n, scanner.Err()
+}
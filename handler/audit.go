@@ -0,0 +1,155 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/google/uuid"
+
+	"github.com/CatalinPlesu/user-service/messaging"
+	"github.com/CatalinPlesu/user-service/model"
+	"github.com/CatalinPlesu/user-service/repository/audit"
+)
+
+const (
+	auditDefaultLimit = 100
+	auditMaxLimit     = 1000
+)
+
+// Audit serves the audit log to administrators.
+type Audit struct {
+	Repo *audit.PostgresRepo
+}
+
+// newAuditEntry describes an action taken by whoever made the request.
+// changes may be nil for actions that do not modify fields.
+func newAuditEntry(r *http.Request, action string, target *uuid.UUID, changes map[string]model.AuditChange) *model.AuditEntry {
+	entry := &model.AuditEntry{
+		OccurredAt:   time.Now().UTC(),
+		ActorType:    model.AuditActorAnonymous,
+		TargetUserID: target,
+		Action:       action,
+		RequestID:    middleware.GetReqID(r.Context()),
+		Details: &model.AuditDetails{
+			IP:        clientIP(r),
+			UserAgent: r.UserAgent(),
+			Changes:   changes,
+		},
+	}
+
+	if callerID, ok := CallerID(r.Context()); ok {
+		entry.ActorType = model.AuditActorUser
+		entry.ActorID = &callerID
+	} else if admin, _ := r.Context().Value(adminKey).(bool); admin {
+		entry.ActorType = model.AuditActorAdmin
+	}
+	return entry
+}
+
+// newCommandAuditEntry describes an action taken on a command another
+// service sent.
+func newCommandAuditEntry(d messaging.Delivery, action string, target *uuid.UUID, details *model.AuditDetails) *model.AuditEntry {
+	return &model.AuditEntry{
+		OccurredAt:   time.Now().UTC(),
+		ActorType:    model.AuditActorService,
+		TargetUserID: target,
+		Action:       action,
+		RequestID:    d.ID,
+		Details:      details,
+	}
+}
+
+// recordAudit appends an entry outside of any transaction. The action has
+// already happened by then, so a failure is only logged.
+func recordAudit(r *http.Request, repo *audit.PostgresRepo, entry *model.AuditEntry) {
+	if repo == nil {
+		return
+	}
+	if err := repo.Append(r.Context(), entry); err != nil {
+		fmt.Println("failed to append audit entry:", err)
+	}
+}
+
+// List returns entries newest first. It accepts the user_id, action (comma
+// separated), from and to (RFC 3339), before (an entry ID to page from) and
+// limit query parameters.
+func (h *Audit) List(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := audit.Filter{Limit: auditDefaultLimit}
+
+	if userID := query.Get("user_id"); userID != "" {
+		id, err := uuid.Parse(userID)
+		if err != nil {
+			writeProblem(w, http.StatusBadRequest, "invalid_filter", "Invalid filter", "user_id must be a UUID")
+			return
+		}
+		filter.UserID = &id
+	}
+
+	if actions := query.Get("action"); actions != "" {
+		filter.Actions = strings.Split(actions, ",")
+	}
+
+	for name, dst := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+		if value := query.Get(name); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				writeProblem(w, http.StatusBadRequest, "invalid_filter", "Invalid filter", name+" must be an RFC 3339 time")
+				return
+			}
+			*dst = t
+		}
+	}
+
+	if before := query.Get("before"); before != "" {
+		id, err := strconv.ParseInt(before, 10, 64)
+		if err != nil {
+			writeProblem(w, http.StatusBadRequest, "invalid_filter", "Invalid filter", "before must be an entry ID")
+			return
+		}
+		filter.BeforeID = id
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > auditMaxLimit {
+			writeProblem(w, http.StatusBadRequest, "invalid_filter", "Invalid filter", fmt.Sprintf("limit must be between 1 and %d", auditMaxLimit))
+			return
+		}
+		filter.Limit = n
+	}
+
+	entries, err := h.Repo.Query(r.Context(), filter)
+	if err != nil {
+		fmt.Println("failed to query audit log:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(entries); err != nil {
+		fmt.Println("failed to marshal audit entries:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// Verify checks the hash chain and reports the first tampered entry.
+func (h *Audit) Verify(w http.ResponseWriter, r *http.Request) {
+	result, err := h.Repo.Verify(r.Context())
+	if err != nil {
+		fmt.Println("failed to verify audit log:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		fmt.Println("failed to marshal audit verification:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,126 @@
+package handler
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+
+	"github.com/CatalinPlesu/user-service/repository/jwts"
+)
+
+type contextKey int
+
+const (
+	callerIDKey contextKey = iota
+	adminKey
+)
+
+// Authenticator validates bearer JWTs and checks they are still listed as an
+// active session for the user in Redis.
+type Authenticator struct {
+	RdRepo *jwts.RedisRepo
+}
+
+// bearerToken reads the JWT from the Authorization header. Browsers cannot
+// set headers on an EventSource, so event stream requests may pass it in the
+// access_token query parameter instead.
+func bearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if token, ok := strings.CutPrefix(header, "Bearer "); ok {
+		return strings.TrimSpace(token)
+	}
+	if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		return r.URL.Query().Get("access_token")
+	}
+	return ""
+}
+
+func (a *Authenticator) Authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := bearerToken(r)
+		if token == "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		claims, err := jwts.ValidateJWT(token)
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		active, err := a.RdRepo.Contains(r.Context(), claims.UserID, token)
+		if err != nil {
+			fmt.Println("failed to check user jwt:", jwts.Redact(err.Error()))
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if !active {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), callerIDKey, claims.UserID)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Identify is Authenticate for endpoints that anonymous callers may use
+// too: without a bearer token the request goes through unauthenticated, but
+// a token that is sent must be valid.
+func (a *Authenticator) Identify(next http.Handler) http.Handler {
+	authenticated := a.Authenticate(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if bearerToken(r) == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		authenticated.ServeHTTP(w, r)
+	})
+}
+
+func CallerID(ctx context.Context) (uuid.UUID, bool) {
+	id, ok := ctx.Value(callerIDKey).(uuid.UUID)
+	return id, ok
+}
+
+// RequireSelf only lets the request through when the authenticated caller is
+// the user named by the {id} URL parameter.
+func RequireSelf(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		callerID, ok := CallerID(r.Context())
+		if !ok {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if chi.URLParam(r, "id") != callerID.String() {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireAdmin guards administrative endpoints with a shared API key sent in
+// the X-Admin-Key header. An empty key disables the endpoints entirely.
+func RequireAdmin(apiKey string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			provided := r.Header.Get("X-Admin-Key")
+			if apiKey == "" || subtle.ConstantTimeCompare([]byte(provided), []byte(apiKey)) != 1 {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), adminKey, true)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
@@ -0,0 +1,226 @@
+package handler
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+
+	"github.com/CatalinPlesu/user-service/model"
+	"github.com/CatalinPlesu/user-service/repository/avatar"
+	"github.com/CatalinPlesu/user-service/repository/storage"
+	"github.com/CatalinPlesu/user-service/repository/user"
+)
+
+// avatarPath is the path of an avatar rendition below AvatarBaseURL. The
+// hash changes with every upload, so responses can be cached forever.
+func avatarPath(userID uuid.UUID, hash string, size int) string {
+	return fmt.Sprintf("/users/%s/avatar/%s/%d", userID, hash, size)
+}
+
+func (h *User) avatarURL(userID uuid.UUID, hash string, size int) string {
+	return strings.TrimRight(h.AvatarBaseURL, "/") + avatarPath(userID, hash, size)
+}
+
+// uploadedAvatar returns the hash of u's avatar if it is one uploaded here
+// rather than a link elsewhere.
+func (h *User) uploadedAvatar(u *model.User) string {
+	prefix := strings.TrimRight(h.AvatarBaseURL, "/") + "/users/" + u.UserID.String() + "/avatar/"
+	rest, ok := strings.CutPrefix(u.Avatar, prefix)
+	if !ok {
+		return ""
+	}
+	hash, _, _ := strings.Cut(rest, "/")
+	return hash
+}
+
+// removeReplacedAvatar deletes the uploaded avatar before had once after
+// no longer uses it. Failure only leaves unreferenced files behind.
+func (h *User) removeReplacedAvatar(r *http.Request, before, after *model.User) {
+	if h.Avatars == nil {
+		return
+	}
+
+	hash := h.uploadedAvatar(before)
+	if hash == "" || hash == h.uploadedAvatar(after) {
+		return
+	}
+	if err := avatar.Remove(r.Context(), h.Avatars, before.UserID, hash); err != nil {
+		fmt.Println("failed to remove replaced avatar:", err)
+	}
+}
+
+// UploadAvatar replaces the user's avatar with the uploaded PNG, JPEG or
+// WebP image.
+func (h *User) UploadAvatar(w http.ResponseWriter, r *http.Request) {
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, h.AvatarMaxBytes))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeProblem(w, http.StatusRequestEntityTooLarge, "avatar_too_large", "Avatar too large",
+			fmt.Sprintf("Avatars may be at most %d bytes.", h.AvatarMaxBytes))
+		return
+	} else if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	processed, err := avatar.Process(data, h.AvatarMaxDimension)
+	if errors.Is(err, avatar.ErrUnsupportedFormat) {
+		writeProblem(w, http.StatusUnsupportedMediaType, "unsupported_avatar_format", "Unsupported avatar format",
+			"Avatars must be PNG, JPEG or WebP images.")
+		return
+	} else if errors.Is(err, avatar.ErrInvalidDimensions) {
+		writeProblem(w, http.StatusUnprocessableEntity, "invalid_avatar_dimensions", "Invalid avatar dimensions", err.Error())
+		return
+	} else if errors.Is(err, avatar.ErrInvalidImage) {
+		writeProblem(w, http.StatusUnprocessableEntity, "invalid_avatar", "Invalid avatar", err.Error())
+		return
+	} else if err != nil {
+		fmt.Println("failed to process avatar:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	theUser := h.loadForUpdate(w, r)
+	if theUser == nil {
+		return
+	}
+	current := h.uploadedAvatar(theUser)
+
+	if err := avatar.Save(r.Context(), h.Avatars, theUser.UserID, processed); err != nil {
+		fmt.Println("failed to save avatar:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	doc := userDocument(theUser)
+	doc["avatar"] = h.avatarURL(theUser.UserID, processed.Hash, avatar.DefaultSize)
+	if !h.saveUpdate(w, r, theUser, doc) && processed.Hash != current {
+		if err := avatar.Remove(r.Context(), h.Avatars, theUser.UserID, processed.Hash); err != nil {
+			fmt.Println("failed to remove unused avatar:", err)
+		}
+	}
+}
+
+// GetAvatar redirects to the current avatar of the user at the requested
+// size.
+func (h *User) GetAvatar(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	size := avatar.DefaultSize
+	if param := r.URL.Query().Get("size"); param != "" {
+		size, err = strconv.Atoi(param)
+		if err != nil || !slices.Contains(avatar.Sizes, size) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	u, err := h.PgRepo.FindByID(r.Context(), userID)
+	if errors.Is(err, user.ErrNotExist) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		fmt.Println("failed to find user by id:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	profile, err := profileFor(r.Context(), h.Contacts, h.Blocks, u)
+	if err != nil {
+		fmt.Println("failed to apply privacy settings:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	hash := h.uploadedAvatar(u)
+	if profile == nil || hash == "" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-cache")
+	http.Redirect(w, r, avatarPath(userID, hash, size), http.StatusFound)
+}
+
+// ServeAvatar responds with one rendition of an uploaded avatar, to callers
+// the user's privacy settings show their profile to. Renditions never
+// change, but who may see them does, so caches have to revalidate.
+func (h *User) ServeAvatar(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	hash := chi.URLParam(r, "hash")
+	size, err := strconv.Atoi(chi.URLParam(r, "size"))
+	if err != nil || !slices.Contains(avatar.Sizes, size) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	u, err := h.PgRepo.FindByID(r.Context(), userID)
+	if errors.Is(err, user.ErrNotExist) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		fmt.Println("failed to find user by id:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	profile, err := profileFor(r.Context(), h.Contacts, h.Blocks, u)
+	if err != nil {
+		fmt.Println("failed to apply privacy settings:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if profile == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "private, no-cache")
+	w.Header().Set("Vary", "Authorization")
+
+	etag := fmt.Sprintf(`"%s-%d"`, hash, size)
+	if header := r.Header.Get("If-None-Match"); header != "" && etagMatches(header, etag, true) {
+		w.Header().Set("ETag", etag)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	blob, err := h.Avatars.Open(r.Context(), avatar.Key(userID, hash, size))
+	if errors.Is(err, storage.ErrNotExist) || errors.Is(err, storage.ErrInvalidKey) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		fmt.Println("failed to open avatar:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	defer blob.Close()
+
+	// Renditions are only ever PNG or JPEG, which sniffing tells apart.
+	reader := bufio.NewReader(blob)
+	head, _ := reader.Peek(512)
+
+	w.Header().Set("Content-Type", http.DetectContentType(head))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("ETag", etag)
+	if _, err := io.Copy(w, reader); err != nil {
+		fmt.Println("failed to write avatar:", err)
+	}
+}
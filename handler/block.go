@@ -0,0 +1,241 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+
+	"github.com/CatalinPlesu/user-service/messaging"
+	"github.com/CatalinPlesu/user-service/model"
+	"github.com/CatalinPlesu/user-service/repository/block"
+	"github.com/CatalinPlesu/user-service/repository/contact"
+	"github.com/CatalinPlesu/user-service/repository/user"
+)
+
+// maxBlockChecks caps the pairs of one bulk check.
+const maxBlockChecks = 1000
+
+// Blocks lets users block others. Postgres holds the blocks; Mirror is
+// updated once they are committed and answers the checks.
+type Blocks struct {
+	Repo     *block.PostgresRepo
+	Mirror   *block.RedisRepo
+	Users    *user.PostgresRepo
+	Contacts *contact.PostgresRepo
+	Outbox   messaging.Enqueuer
+}
+
+// Block blocks {target} for the user. Blocking ends any contact between the
+// two and cancels their pending friend requests.
+func (h *Blocks) Block(w http.ResponseWriter, r *http.Request) {
+	userID, targetID, ok := parseBlockPair(w, r)
+	if !ok {
+		return
+	}
+
+	if _, err := h.Users.FindByID(r.Context(), targetID); errors.Is(err, user.ErrNotExist) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		fmt.Println("failed to find user by id:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now().UTC()
+	err := h.Repo.DB.RunInTx(r.Context(), nil, func(ctx context.Context, tx bun.Tx) error {
+		// Friend requests are sent under the same lock, so none can be
+		// created after the pending ones are cancelled below.
+		contacts := h.Contacts.WithTx(tx)
+		if err := contacts.LockPair(ctx, userID, targetID); err != nil {
+			return err
+		}
+
+		created, err := h.Repo.WithTx(tx).Block(ctx, userID, targetID, now)
+		if err != nil || !created {
+			return err
+		}
+
+		events := []messaging.Event{
+			messaging.UserBlocked{
+				UserID:        userID,
+				BlockedUserID: targetID,
+				BlockedAt:     now,
+			},
+		}
+
+		err = contacts.Remove(ctx, userID, targetID)
+		if err == nil {
+			events = append(events,
+				messaging.ContactRemoved{UserID: userID, ContactID: targetID, RemovedAt: now},
+				messaging.ContactRemoved{UserID: targetID, ContactID: userID, RemovedAt: now},
+			)
+		} else if !errors.Is(err, contact.ErrNotContacts) {
+			return err
+		}
+		if err := contacts.CancelBetween(ctx, userID, targetID, now); err != nil {
+			return err
+		}
+
+		return h.Outbox.WithTx(tx).Enqueue(ctx, events...)
+	})
+	if err != nil {
+		fmt.Println("failed to block user:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.Mirror.Add(r.Context(), userID, targetID); err != nil {
+		fmt.Println("failed to mirror block:", err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Blocks) Unblock(w http.ResponseWriter, r *http.Request) {
+	userID, targetID, ok := parseBlockPair(w, r)
+	if !ok {
+		return
+	}
+
+	var existed bool
+	err := h.Repo.DB.RunInTx(r.Context(), nil, func(ctx context.Context, tx bun.Tx) error {
+		var err error
+		existed, err = h.Repo.WithTx(tx).Unblock(ctx, userID, targetID)
+		if err != nil || !existed {
+			return err
+		}
+
+		return h.Outbox.WithTx(tx).Enqueue(ctx, messaging.UserUnblocked{
+			UserID:          userID,
+			UnblockedUserID: targetID,
+			UnblockedAt:     time.Now().UTC(),
+		})
+	})
+	if err != nil {
+		fmt.Println("failed to unblock user:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !existed {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if err := h.Mirror.Remove(r.Context(), userID, targetID); err != nil {
+		fmt.Println("failed to mirror unblock:", err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// List returns a page of the users the user blocked, paginated like the
+// contacts.
+func (h *Blocks) List(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	after, limit, ok := parsePage(w, r)
+	if !ok {
+		return
+	}
+
+	blocks, err := h.Repo.List(r.Context(), userID, block.Page{After: after, Limit: limit})
+	if err != nil {
+		fmt.Println("failed to list blocks:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	var response struct {
+		Items []model.Block `json:"items"`
+		Next  *uuid.UUID    `json:"next,omitempty"`
+	}
+	response.Items = blocks
+	if len(blocks) == limit {
+		response.Next = &blocks[len(blocks)-1].BlockedID
+	}
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		fmt.Println("failed to marshal blocks:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// Check answers, for other services, whether each blocker_id blocked the
+// paired blocked_id.
+func (h *Blocks) Check(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Pairs []block.Pair `json:"pairs"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if len(body.Pairs) > maxBlockChecks {
+		writeProblem(w, http.StatusUnprocessableEntity, "too_many_pairs", "Too many pairs",
+			fmt.Sprintf("At most %d pairs can be checked at once.", maxBlockChecks))
+		return
+	}
+
+	type result struct {
+		block.Pair
+		Blocked bool `json:"blocked"`
+	}
+	results := make([]result, len(body.Pairs))
+
+	if len(body.Pairs) > 0 {
+		blocked, err := h.Mirror.Check(r.Context(), body.Pairs)
+		if err != nil {
+			fmt.Println("failed to check blocks:", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		for i, pair := range body.Pairs {
+			results[i] = result{Pair: pair, Blocked: blocked[i]}
+		}
+	}
+
+	var response struct {
+		Results []result `json:"results"`
+	}
+	response.Results = results
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		fmt.Println("failed to marshal block checks:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+func parseBlockPair(w http.ResponseWriter, r *http.Request) (uuid.UUID, uuid.UUID, bool) {
+	userID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	targetID, err := uuid.Parse(chi.URLParam(r, "target"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	if userID == targetID {
+		writeProblem(w, http.StatusUnprocessableEntity, "self_block", "Cannot block yourself", "")
+		return uuid.Nil, uuid.Nil, false
+	}
+	return userID, targetID, true
+}
@@ -0,0 +1,100 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+
+	"github.com/CatalinPlesu/user-service/messaging"
+	"github.com/CatalinPlesu/user-service/model"
+	"github.com/CatalinPlesu/user-service/repository/audit"
+	"github.com/CatalinPlesu/user-service/repository/jwts"
+	"github.com/CatalinPlesu/user-service/repository/user"
+)
+
+// Commands handles commands other services send over RabbitMQ.
+type Commands struct {
+	PgRepo *user.PostgresRepo
+	RdRepo *jwts.RedisRepo
+	Outbox messaging.Enqueuer
+	Audit  *audit.PostgresRepo
+}
+
+// DeactivateUser stops the user from logging in and revokes all of their
+// sessions.
+func (h *Commands) DeactivateUser(ctx context.Context, d messaging.Delivery) error {
+	var cmd messaging.DeactivateUserCommand
+	if err := d.Decode(&cmd); err != nil {
+		return err
+	}
+
+	err := h.PgRepo.DB.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		u, err := h.PgRepo.WithTx(tx).FindByID(ctx, cmd.UserID)
+		if err != nil {
+			return err
+		}
+		if u.DeactivatedAt != nil {
+			return nil
+		}
+
+		now := time.Now().UTC()
+		u.DeactivatedAt = &now
+		if err := h.PgRepo.WithTx(tx).Update(ctx, u); err != nil {
+			return err
+		}
+		entry := newCommandAuditEntry(d, model.AuditUserDeactivated, &u.UserID, &model.AuditDetails{Reason: cmd.Reason})
+		if err := h.Audit.WithTx(tx).Append(ctx, entry); err != nil {
+			return err
+		}
+		return h.Outbox.WithTx(tx).Enqueue(ctx, messaging.UserDeactivated{
+			UserID:        u.UserID,
+			Reason:        cmd.Reason,
+			DeactivatedAt: now,
+		})
+	})
+	if errors.Is(err, user.ErrNotExist) {
+		return fmt.Errorf("%w: %w", messaging.ErrPermanent, err)
+	} else if err != nil {
+		return err
+	}
+
+	// Sessions are revoked even if the user was already deactivated, in
+	// case an earlier attempt failed after committing.
+	return h.revokeSessions(ctx, d, cmd.UserID, "deactivated")
+}
+
+func (h *Commands) RevokeSessions(ctx context.Context, d messaging.Delivery) error {
+	var cmd messaging.RevokeSessionsCommand
+	if err := d.Decode(&cmd); err != nil {
+		return err
+	}
+
+	reason := cmd.Reason
+	if reason == "" {
+		reason = "command"
+	}
+	return h.revokeSessions(ctx, d, cmd.UserID, reason)
+}
+
+func (h *Commands) revokeSessions(ctx context.Context, d messaging.Delivery, userID uuid.UUID, reason string) error {
+	if err := h.RdRepo.DeleteAll(ctx, userID); err != nil {
+		return err
+	}
+
+	// The sessions are gone by now, so a failure is only logged rather
+	// than redelivering the command.
+	entry := newCommandAuditEntry(d, model.AuditSessionsRevoked, &userID, &model.AuditDetails{Reason: reason})
+	if err := h.Audit.Append(ctx, entry); err != nil {
+		fmt.Println("failed to append audit entry:", err)
+	}
+
+	return h.Outbox.Enqueue(ctx, messaging.UserSessionsRevoked{
+		UserID:    userID,
+		Reason:    reason,
+		RevokedAt: time.Now().UTC(),
+	})
+}
@@ -0,0 +1,394 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+
+	"github.com/CatalinPlesu/user-service/messaging"
+	"github.com/CatalinPlesu/user-service/model"
+	"github.com/CatalinPlesu/user-service/repository/block"
+	"github.com/CatalinPlesu/user-service/repository/contact"
+	"github.com/CatalinPlesu/user-service/repository/user"
+)
+
+const (
+	pageDefaultLimit = 50
+	pageMaxLimit     = 100
+)
+
+// Contacts manages friend requests and the contacts they create. Every
+// endpoint acts on behalf of the user in the {id} URL parameter.
+type Contacts struct {
+	Repo   *contact.PostgresRepo
+	Users  *user.PostgresRepo
+	Blocks *block.RedisRepo
+	Outbox messaging.Enqueuer
+}
+
+type contactView struct {
+	ContactID uuid.UUID            `json:"contact_id"`
+	CreatedAt time.Time            `json:"created_at"`
+	Profile   *model.PublicProfile `json:"profile,omitempty"`
+}
+
+func (h *Contacts) SendRequest(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var body struct {
+		UserID uuid.UUID `json:"user_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if _, err := h.Users.FindByID(r.Context(), body.UserID); errors.Is(err, user.ErrNotExist) {
+		writeProblem(w, http.StatusUnprocessableEntity, "user_not_found", "User not found", "There is no user with that ID.")
+		return
+	} else if err != nil {
+		fmt.Println("failed to find user by id:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	request, err := h.Repo.Send(r.Context(), userID, body.UserID)
+	if errors.Is(err, contact.ErrBlockedBy) {
+		// Users who blocked the sender look like they do not exist to them.
+		writeProblem(w, http.StatusUnprocessableEntity, "user_not_found", "User not found", "There is no user with that ID.")
+		return
+	} else if errors.Is(err, contact.ErrBlocking) {
+		writeProblem(w, http.StatusConflict, "user_blocked", "User is blocked", "Unblock the user before sending them a friend request.")
+		return
+	} else if errors.Is(err, contact.ErrSelf) {
+		writeProblem(w, http.StatusUnprocessableEntity, "self_request", "Cannot befriend yourself", "")
+		return
+	} else if errors.Is(err, contact.ErrAlreadyContacts) {
+		writeProblem(w, http.StatusConflict, "already_contacts", "Already contacts", "")
+		return
+	} else if errors.Is(err, contact.ErrRequestPending) {
+		writeProblem(w, http.StatusConflict, "request_pending", "Friend request already pending",
+			"A friend request between you and this user is waiting for an answer.")
+		return
+	} else if err != nil {
+		fmt.Println("failed to send friend request:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	res, err := json.Marshal(request)
+	if err != nil {
+		fmt.Println("failed to marshal friend request:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	w.Write(res)
+}
+
+// ListRequests lists pending requests sent to the user, or with
+// ?direction=outgoing the ones they sent.
+func (h *Contacts) ListRequests(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var incoming bool
+	switch r.URL.Query().Get("direction") {
+	case "", "incoming":
+		incoming = true
+	case "outgoing":
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	requests, err := h.Repo.ListRequests(r.Context(), userID, incoming)
+	if err != nil {
+		fmt.Println("failed to list friend requests:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(requests); err != nil {
+		fmt.Println("failed to marshal friend requests:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *Contacts) AcceptRequest(w http.ResponseWriter, r *http.Request) {
+	h.respond(w, r, func(ctx context.Context, tx bun.Tx, id, userID uuid.UUID, now time.Time) (*model.FriendRequest, error) {
+		request, err := h.Repo.WithTx(tx).Accept(ctx, id, userID, now)
+		if err != nil {
+			return nil, err
+		}
+
+		return request, h.Outbox.WithTx(tx).Enqueue(ctx,
+			messaging.ContactAdded{
+				UserID:    request.FromUserID,
+				ContactID: request.ToUserID,
+				RequestID: request.ID,
+				AddedAt:   now,
+			},
+			messaging.ContactAdded{
+				UserID:    request.ToUserID,
+				ContactID: request.FromUserID,
+				RequestID: request.ID,
+				AddedAt:   now,
+			},
+		)
+	})
+}
+
+func (h *Contacts) DeclineRequest(w http.ResponseWriter, r *http.Request) {
+	h.respond(w, r, func(ctx context.Context, tx bun.Tx, id, userID uuid.UUID, now time.Time) (*model.FriendRequest, error) {
+		return h.Repo.WithTx(tx).Decline(ctx, id, userID, now)
+	})
+}
+
+func (h *Contacts) CancelRequest(w http.ResponseWriter, r *http.Request) {
+	h.respond(w, r, func(ctx context.Context, tx bun.Tx, id, userID uuid.UUID, now time.Time) (*model.FriendRequest, error) {
+		return h.Repo.WithTx(tx).Cancel(ctx, id, userID, now)
+	})
+}
+
+// respond answers the friend request in the {requestID} URL parameter with
+// answer, run in a transaction.
+func (h *Contacts) respond(w http.ResponseWriter, r *http.Request, answer func(ctx context.Context, tx bun.Tx, id, userID uuid.UUID, now time.Time) (*model.FriendRequest, error)) {
+	userID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	requestID, err := uuid.Parse(chi.URLParam(r, "requestID"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var request *model.FriendRequest
+	err = h.Repo.DB.RunInTx(r.Context(), nil, func(ctx context.Context, tx bun.Tx) error {
+		var err error
+		request, err = answer(ctx, tx, requestID, userID, time.Now().UTC())
+		return err
+	})
+	if errors.Is(err, contact.ErrNotExist) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if errors.Is(err, contact.ErrNotPending) {
+		writeProblem(w, http.StatusConflict, "request_not_pending", "Friend request already answered", "")
+		return
+	} else if err != nil {
+		fmt.Println("failed to answer friend request:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(request); err != nil {
+		fmt.Println("failed to marshal friend request:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// List returns a page of the user's contacts. Pages are requested with the
+// after and limit query parameters, after being the last contact_id seen.
+func (h *Contacts) List(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	after, limit, ok := parsePage(w, r)
+	if !ok {
+		return
+	}
+	page := contact.Page{After: after, Limit: limit}
+
+	contacts, err := h.Repo.List(r.Context(), userID, page)
+	if err != nil {
+		fmt.Println("failed to list contacts:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	h.writeContacts(w, r, contacts, page)
+}
+
+// Mutual returns a page of the contacts the user shares with {otherID}, as
+// long as the other user's profile is visible to them.
+func (h *Contacts) Mutual(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	otherID, err := uuid.Parse(chi.URLParam(r, "otherID"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	after, limit, ok := parsePage(w, r)
+	if !ok {
+		return
+	}
+	page := contact.Page{After: after, Limit: limit}
+
+	other, err := h.Users.FindByID(r.Context(), otherID)
+	if errors.Is(err, user.ErrNotExist) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		fmt.Println("failed to find user by id:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	profile, err := profileFor(r.Context(), h.Repo, h.Blocks, other)
+	if err != nil {
+		fmt.Println("failed to apply privacy settings:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if profile == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	contacts, err := h.Repo.Mutual(r.Context(), userID, otherID, page)
+	if err != nil {
+		fmt.Println("failed to list mutual contacts:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	h.writeContacts(w, r, contacts, page)
+}
+
+func (h *Contacts) Remove(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	contactID, err := uuid.Parse(chi.URLParam(r, "contactID"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now().UTC()
+	err = h.Repo.DB.RunInTx(r.Context(), nil, func(ctx context.Context, tx bun.Tx) error {
+		if err := h.Repo.WithTx(tx).Remove(ctx, userID, contactID); err != nil {
+			return err
+		}
+
+		return h.Outbox.WithTx(tx).Enqueue(ctx,
+			messaging.ContactRemoved{
+				UserID:    userID,
+				ContactID: contactID,
+				RemovedAt: now,
+			},
+			messaging.ContactRemoved{
+				UserID:    contactID,
+				ContactID: userID,
+				RemovedAt: now,
+			},
+		)
+	})
+	if errors.Is(err, contact.ErrNotContacts) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		fmt.Println("failed to remove contact:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// parsePage reads the after and limit query parameters of a keyset
+// paginated list, after being the last ID of the previous page.
+func parsePage(w http.ResponseWriter, r *http.Request) (uuid.UUID, int, bool) {
+	after, limit := uuid.Nil, pageDefaultLimit
+	query := r.URL.Query()
+
+	if param := query.Get("after"); param != "" {
+		id, err := uuid.Parse(param)
+		if err != nil {
+			writeProblem(w, http.StatusBadRequest, "invalid_cursor", "Invalid cursor", "after must be an ID from the previous page")
+			return after, limit, false
+		}
+		after = id
+	}
+
+	if param := query.Get("limit"); param != "" {
+		n, err := strconv.Atoi(param)
+		if err != nil || n < 1 || n > pageMaxLimit {
+			writeProblem(w, http.StatusBadRequest, "invalid_limit", "Invalid limit", fmt.Sprintf("limit must be between 1 and %d", pageMaxLimit))
+			return after, limit, false
+		}
+		limit = n
+	}
+	return after, limit, true
+}
+
+// writeContacts responds with a page of contacts and their profiles as the
+// caller may see them. Contacts whose account is deleted are left out.
+func (h *Contacts) writeContacts(w http.ResponseWriter, r *http.Request, contacts []model.Contact, page contact.Page) {
+	var response struct {
+		Items []contactView `json:"items"`
+		Next  *uuid.UUID    `json:"next,omitempty"`
+	}
+	response.Items = []contactView{}
+
+	for _, c := range contacts {
+		if c.User == nil {
+			continue
+		}
+
+		profile, err := profileFor(r.Context(), h.Repo, h.Blocks, c.User)
+		if err != nil {
+			fmt.Println("failed to apply privacy settings:", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		response.Items = append(response.Items, contactView{
+			ContactID: c.ContactID,
+			CreatedAt: c.CreatedAt,
+			Profile:   profile,
+		})
+	}
+	if len(contacts) == page.Limit {
+		response.Next = &contacts[len(contacts)-1].ContactID
+	}
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		fmt.Println("failed to marshal contacts:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
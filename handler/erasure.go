@@ -0,0 +1,266 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+
+	"github.com/CatalinPlesu/user-service/messaging"
+	"github.com/CatalinPlesu/user-service/model"
+	"github.com/CatalinPlesu/user-service/repository/audit"
+	"github.com/CatalinPlesu/user-service/repository/avatar"
+	"github.com/CatalinPlesu/user-service/repository/block"
+	"github.com/CatalinPlesu/user-service/repository/erasure"
+	"github.com/CatalinPlesu/user-service/repository/eventstream"
+	"github.com/CatalinPlesu/user-service/repository/jwts"
+	"github.com/CatalinPlesu/user-service/repository/lockout"
+	"github.com/CatalinPlesu/user-service/repository/storage"
+	"github.com/CatalinPlesu/user-service/repository/user"
+)
+
+// Erasure permanently erases users on request, unlike soft deletion which
+// keeps them restorable for a grace period. Every service listed in
+// RequiredAcks must acknowledge the user.erased event before the erasure
+// certificate is complete.
+type Erasure struct {
+	Users        *user.PostgresRepo
+	Certificates *erasure.PostgresRepo
+	Outbox       messaging.Enqueuer
+	Sessions     *jwts.RedisRepo
+	Cache        *user.RedisRepo
+	Lockout      *lockout.RedisRepo
+	Stream       *eventstream.RedisRepo
+	Audit        *audit.PostgresRepo
+	Avatars      storage.BlobStore
+	Blocks       *block.RedisRepo
+	SubjectKey   string
+	RequiredAcks []string
+}
+
+// Erase removes the user and everything stored about them, then issues a
+// certificate listing the steps taken.
+func (h *Erasure) Erase(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var body struct {
+		Reason string `json:"reason"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	now := time.Now().UTC()
+	certificate := &model.ErasureCertificate{
+		SubjectHash:      erasure.SubjectHash(h.SubjectKey, userID),
+		Reason:           body.Reason,
+		RequiredAcks:     h.RequiredAcks,
+		Status:           model.ErasurePendingAcknowledgement,
+		ErasedAt:         now,
+		Acknowledgements: []model.ErasureAcknowledgement{},
+	}
+	if len(h.RequiredAcks) == 0 {
+		certificate.Status = model.ErasureCompleted
+		certificate.CompletedAt = &now
+	}
+
+	var erased *model.User
+	err = h.Users.DB.RunInTx(r.Context(), nil, func(ctx context.Context, tx bun.Tx) error {
+		erased, err = h.Users.WithTx(tx).Erase(ctx, userID)
+		if err != nil {
+			return err
+		}
+
+		outbox := h.Outbox.WithTx(tx)
+		forgotten, err := outbox.Forget(ctx, userID)
+		if err != nil {
+			return err
+		}
+		certificate.Steps = append([]string{"profile", "mfa", "passkeys", "data_exports", "data_key"}, forgotten...)
+
+		if err := h.Certificates.WithTx(tx).Insert(ctx, certificate); err != nil {
+			return err
+		}
+
+		// The user's key is gone by now, so the entry is written without
+		// details rather than creating a new one.
+		entry := newAuditEntry(r, model.AuditUserErased, &userID, nil)
+		entry.Details = nil
+		if err := h.Audit.WithTx(tx).Append(ctx, entry); err != nil {
+			return err
+		}
+		return outbox.Enqueue(ctx, messaging.UserErased{
+			UserID:    userID,
+			ErasureID: certificate.ID,
+			ErasedAt:  now,
+		})
+	})
+	if errors.Is(err, user.ErrNotExist) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		fmt.Println("failed to erase user:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// Redis cannot take part in the transaction, so its steps are only
+	// added to the certificate once they succeed.
+	steps := h.eraseCaches(r.Context(), erased)
+	if err := h.Certificates.AddSteps(r.Context(), certificate.ID, steps...); err != nil {
+		fmt.Println("failed to record erasure steps:", err)
+	}
+	certificate.Steps = append(certificate.Steps, steps...)
+
+	res, err := json.Marshal(certificate)
+	if err != nil {
+		fmt.Println("failed to marshal erasure certificate:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	w.Write(res)
+}
+
+// eraseCaches removes the user from Redis and returns the steps that
+// succeeded.
+func (h *Erasure) eraseCaches(ctx context.Context, erased *model.User) []string {
+	var steps []string
+
+	if err := h.Sessions.DeleteAll(ctx, erased.UserID); err != nil {
+		fmt.Println("failed to erase sessions:", err)
+	} else {
+		steps = append(steps, "sessions")
+	}
+
+	if err := h.Cache.DeleteByID(ctx, erased.UserID); err != nil {
+		fmt.Println("failed to erase cached user:", err)
+	} else {
+		steps = append(steps, "user_cache")
+	}
+
+	accounts := []string{
+		loginAccount(erased, ""),
+		"mfa:" + erased.UserID.String(),
+		"password:" + erased.UserID.String(),
+	}
+	unlocked := true
+	for _, account := range accounts {
+		if err := h.Lockout.UnlockAccount(ctx, account); err != nil {
+			fmt.Println("failed to erase login failures:", err)
+			unlocked = false
+		}
+	}
+	if unlocked {
+		steps = append(steps, "login_failures")
+	}
+
+	if _, err := h.Stream.DeleteUser(ctx, erased.UserID); err != nil {
+		fmt.Println("failed to erase streamed events:", err)
+	} else {
+		steps = append(steps, "event_stream")
+	}
+
+	if err := avatar.RemoveAll(ctx, h.Avatars, erased.UserID); err != nil {
+		fmt.Println("failed to erase avatars:", err)
+	} else {
+		steps = append(steps, "avatars")
+	}
+
+	if err := h.Blocks.DeleteUser(ctx, erased.UserID); err != nil {
+		fmt.Println("failed to erase mirrored blocks:", err)
+	} else {
+		steps = append(steps, "blocks")
+	}
+
+	return steps
+}
+
+func (h *Erasure) Get(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	certificate, err := h.Certificates.FindByID(r.Context(), id)
+	if errors.Is(err, erasure.ErrNotExist) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		fmt.Println("failed to find erasure certificate:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(certificate); err != nil {
+		fmt.Println("failed to marshal erasure certificate:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// Find looks up the certificates of the user given in the user_id query
+// parameter, to prove they were erased.
+func (h *Erasure) Find(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(r.URL.Query().Get("user_id"))
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, "invalid_user_id", "Invalid user ID", "user_id must be a UUID")
+		return
+	}
+
+	certificates, err := h.Certificates.FindBySubject(r.Context(), erasure.SubjectHash(h.SubjectKey, userID))
+	if err != nil {
+		fmt.Println("failed to find erasure certificates:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(certificates); err != nil {
+		fmt.Println("failed to marshal erasure certificates:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// Acknowledge handles CommandAcknowledgeErasure from downstream services.
+func (h *Erasure) Acknowledge(ctx context.Context, d messaging.Delivery) error {
+	var cmd messaging.AcknowledgeErasureCommand
+	if err := d.Decode(&cmd); err != nil {
+		return err
+	}
+	if cmd.Service == "" {
+		return fmt.Errorf("%w: service is required", messaging.ErrPermanent)
+	}
+
+	err := h.Certificates.Acknowledge(ctx, cmd.ErasureID, cmd.Service, time.Now().UTC())
+	if errors.Is(err, erasure.ErrNotExist) {
+		return fmt.Errorf("%w: %w", messaging.ErrPermanent, err)
+	} else if err != nil {
+		return err
+	}
+
+	// The user is gone, so the entry names the certificate instead.
+	entry := newCommandAuditEntry(d, model.AuditErasureAcknowledged, nil, &model.AuditDetails{
+		Service:   cmd.Service,
+		ErasureID: &cmd.ErasureID,
+	})
+	if err := h.Audit.Append(ctx, entry); err != nil {
+		fmt.Println("failed to append audit entry:", err)
+	}
+	return nil
+}
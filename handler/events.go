@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/CatalinPlesu/user-service/messaging"
+)
+
+type Events struct {
+	SchemaBase string
+}
+
+// ListSchemas describes the current version of every event type along with
+// where its schema is served.
+func (h *Events) ListSchemas(w http.ResponseWriter, r *http.Request) {
+	type schemaRef struct {
+		Type    string `json:"type"`
+		Version int    `json:"version"`
+		URL     string `json:"url"`
+	}
+
+	response := make([]schemaRef, len(messaging.EventSchemas))
+	for i, s := range messaging.EventSchemas {
+		response[i] = schemaRef{
+			Type:    s.Type,
+			Version: s.Version,
+			URL:     messaging.SchemaURL(h.SchemaBase, s.Type, s.Version),
+		}
+	}
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		fmt.Println("failed to marshal schemas:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// GetSchema serves a published schema. The version parameter has the form
+// "v1.json" so the URL matches the dataschema attribute of the events.
+func (h *Events) GetSchema(w http.ResponseWriter, r *http.Request) {
+	versionParam := strings.TrimSuffix(strings.TrimPrefix(chi.URLParam(r, "version"), "v"), ".json")
+
+	version, err := strconv.Atoi(versionParam)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	data, err := messaging.PublishedSchema(chi.URLParam(r, "type"), version)
+	if errors.Is(err, messaging.ErrSchemaNotExist) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		fmt.Println("failed to read schema:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/schema+json")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Write(data)
+}
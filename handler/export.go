@@ -0,0 +1,176 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+
+	"github.com/CatalinPlesu/user-service/model"
+	"github.com/CatalinPlesu/user-service/repository/export"
+	"github.com/CatalinPlesu/user-service/repository/keys"
+	"github.com/CatalinPlesu/user-service/repository/storage"
+)
+
+// Export lets users request a copy of their data and download it once the
+// export worker has assembled it.
+type Export struct {
+	Repo  *export.PostgresRepo
+	Store storage.BlobStore
+	Keys  *keys.PostgresRepo
+}
+
+// ExportDownloadPath is where a ready export can be downloaded from.
+func ExportDownloadPath(userID, exportID uuid.UUID) string {
+	return fmt.Sprintf("/users/%s/exports/%s/download", userID, exportID)
+}
+
+// Request queues an export. While one is still being prepared, it is
+// returned instead of starting another.
+func (h *Export) Request(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	requested, err := h.Repo.Request(r.Context(), userID)
+	if err != nil {
+		fmt.Println("failed to request data export:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	res, err := json.Marshal(requested)
+	if err != nil {
+		fmt.Println("failed to marshal data export:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/users/%s/exports/%s", userID, requested.ID))
+	w.WriteHeader(http.StatusAccepted)
+	w.Write(res)
+}
+
+func (h *Export) List(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	exports, err := h.Repo.ListByUserID(r.Context(), userID)
+	if err != nil {
+		fmt.Println("failed to list data exports:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(exports); err != nil {
+		fmt.Println("failed to marshal data exports:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *Export) Get(w http.ResponseWriter, r *http.Request) {
+	found, ok := h.find(w, r)
+	if !ok {
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(found); err != nil {
+		fmt.Println("failed to marshal data export:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// Download streams the export's archive. Links stop working once the export
+// has expired, even if the archive was not cleaned up yet.
+func (h *Export) Download(w http.ResponseWriter, r *http.Request) {
+	found, ok := h.find(w, r)
+	if !ok {
+		return
+	}
+
+	expired := found.Status == model.DataExportExpired ||
+		(found.ExpiresAt != nil && time.Now().After(*found.ExpiresAt))
+	if expired {
+		writeProblem(w, http.StatusGone, "export_expired", "Export has expired", "Request a new export to download your data.")
+		return
+	}
+	if found.Status != model.DataExportReady {
+		writeProblem(w, http.StatusConflict, "export_not_ready", "Export is not ready", "")
+		return
+	}
+
+	archive, err := h.open(r.Context(), found)
+	if errors.Is(err, storage.ErrNotExist) || errors.Is(err, keys.ErrShredded) {
+		writeProblem(w, http.StatusGone, "export_expired", "Export has expired", "Request a new export to download your data.")
+		return
+	} else if err != nil {
+		fmt.Println("failed to open data export:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="export-%s.zip"`, found.ID))
+	w.Header().Set("Content-Length", fmt.Sprint(len(archive)))
+	w.Header().Set("Cache-Control", "no-store")
+
+	w.Write(archive)
+}
+
+// open reads and decrypts the export's archive.
+func (h *Export) open(ctx context.Context, found *model.DataExport) ([]byte, error) {
+	f, err := h.Store.Open(ctx, found.FileKey)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	sealed, err := io.ReadAll(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read data export: %w", err)
+	}
+
+	dataKey, err := h.Keys.Find(ctx, found.UserID)
+	if err != nil {
+		return nil, err
+	}
+	return keys.Open(dataKey, sealed)
+}
+
+func (h *Export) find(w http.ResponseWriter, r *http.Request) (*model.DataExport, bool) {
+	userID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return nil, false
+	}
+
+	exportID, err := uuid.Parse(chi.URLParam(r, "exportID"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return nil, false
+	}
+
+	found, err := h.Repo.FindByID(r.Context(), userID, exportID)
+	if errors.Is(err, export.ErrNotExist) {
+		w.WriteHeader(http.StatusNotFound)
+		return nil, false
+	} else if err != nil {
+		fmt.Println("failed to find data export:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return nil, false
+	}
+	return found, true
+}
@@ -0,0 +1,66 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/uptrace/bun"
+
+	"github.com/CatalinPlesu/user-service/messaging"
+	"github.com/CatalinPlesu/user-service/repository/breached"
+)
+
+type Health struct {
+	Redis    *redis.Client
+	DB       *bun.DB
+	RabbitMQ *messaging.RabbitMQ
+	Breached *breached.Monitor
+}
+
+type dependencyStatus struct {
+	State string `json:"state"`
+	Error string `json:"error,omitempty"`
+}
+
+// Check reports the state of every dependency. It responds 503 when any of
+// them is unavailable so load balancers can take the instance out.
+func (h *Health) Check(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+
+	response := struct {
+		Redis    dependencyStatus `json:"redis"`
+		Postgres dependencyStatus `json:"postgres"`
+		RabbitMQ messaging.Status `json:"rabbitmq"`
+		Breached breached.Status  `json:"breached_passwords"`
+	}{
+		Redis:    pingStatus(h.Redis.Ping(ctx).Err()),
+		Postgres: pingStatus(h.DB.PingContext(ctx)),
+		RabbitMQ: h.RabbitMQ.Status(),
+		Breached: h.Breached.Status(),
+	}
+
+	healthy := response.Redis.Error == "" &&
+		response.Postgres.Error == "" &&
+		h.RabbitMQ.State() == messaging.StateConnected
+
+	w.Header().Set("Content-Type", "application/json")
+	if !healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		fmt.Println("failed to marshal health:", err)
+	}
+}
+
+func pingStatus(err error) dependencyStatus {
+	if err != nil {
+		return dependencyStatus{State: "down", Error: err.Error()}
+	}
+	return dependencyStatus{State: "up"}
+}
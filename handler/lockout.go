@@ -0,0 +1,92 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/CatalinPlesu/user-service/model"
+	"github.com/CatalinPlesu/user-service/repository/lockout"
+	"github.com/CatalinPlesu/user-service/repository/user"
+)
+
+type Lockout struct {
+	Repo  *lockout.RedisRepo
+	Users *user.PostgresRepo
+}
+
+// loginAccount is the lockout account password logins are counted on.
+// Failures count against the user whichever identifier named them, and
+// identifiers of no user get an account of their own so they lock out the
+// same way.
+func loginAccount(u *model.User, identifier string) string {
+	if u != nil {
+		return "user:" + u.UserID.String()
+	}
+	return "unknown:" + strings.ToLower(identifier)
+}
+
+// UnlockAccount lets an administrator clear the failed attempts and any
+// active lockout of the user with the given username or email.
+func (h *Lockout) UnlockAccount(w http.ResponseWriter, r *http.Request) {
+	u, err := h.Users.FindByLogin(r.Context(), chi.URLParam(r, "username"))
+	if errors.Is(err, user.ErrNotExist) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		fmt.Println("failed to find user by login:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.Repo.UnlockAccount(r.Context(), loginAccount(u, "")); err != nil {
+		fmt.Println("failed to unlock account:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Lockout) UnlockIP(w http.ResponseWriter, r *http.Request) {
+	ip := net.ParseIP(chi.URLParam(r, "ip"))
+	if ip == nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if err := h.Repo.UnlockIP(r.Context(), ip.String()); err != nil {
+		fmt.Println("failed to unlock ip:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// clientIP uses the connection's remote address. Forwarding headers are
+// deliberately ignored since a client could set them to dodge IP limits.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func setRetryAfter(w http.ResponseWriter, wait time.Duration) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+}
+
+func writeTooManyAttempts(w http.ResponseWriter, wait time.Duration) {
+	setRetryAfter(w, wait)
+	w.WriteHeader(http.StatusTooManyRequests)
+}
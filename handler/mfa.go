@@ -0,0 +1,394 @@
+package handler
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+
+	"github.com/CatalinPlesu/user-service/model"
+	"github.com/CatalinPlesu/user-service/repository/audit"
+	"github.com/CatalinPlesu/user-service/repository/lockout"
+	"github.com/CatalinPlesu/user-service/repository/mfa"
+	"github.com/CatalinPlesu/user-service/repository/user"
+)
+
+const recoveryCodeCount = 10
+const qrCodeSize = 256
+
+type MFA struct {
+	MfaRepo  *mfa.PostgresRepo
+	UserRepo *user.PostgresRepo
+	TOTP     *mfa.TOTP
+	Audit    *audit.PostgresRepo
+	Lockout  *lockout.RedisRepo
+}
+
+// checkCodeLockout writes a 429 and returns false while the user's TOTP
+// codes are locked out. Codes are counted on the same account as LoginMFA,
+// so these endpoints cannot be used for extra guesses.
+func (h *MFA) checkCodeLockout(w http.ResponseWriter, r *http.Request, userID uuid.UUID) bool {
+	wait, err := h.Lockout.Check(r.Context(), "mfa:"+userID.String(), clientIP(r))
+	if err != nil {
+		fmt.Println("failed to check login lockout:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return false
+	}
+	if wait > 0 {
+		writeTooManyAttempts(w, wait)
+		return false
+	}
+	return true
+}
+
+// rejectCode counts a wrong code towards the lockout and writes a 422.
+func (h *MFA) rejectCode(w http.ResponseWriter, r *http.Request, userID uuid.UUID) {
+	wait, err := h.Lockout.RecordFailure(r.Context(), "mfa:"+userID.String(), clientIP(r))
+	if err != nil {
+		fmt.Println("failed to record login failure:", err)
+	}
+	if wait > 0 {
+		setRetryAfter(w, wait)
+	}
+	w.WriteHeader(http.StatusUnprocessableEntity)
+}
+
+func (h *MFA) acceptCode(r *http.Request, userID uuid.UUID) {
+	if err := h.Lockout.Reset(r.Context(), "mfa:"+userID.String()); err != nil {
+		fmt.Println("failed to reset login failures:", err)
+	}
+}
+
+func (h *MFA) EnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	u, err := h.UserRepo.FindByID(r.Context(), userID)
+	if err != nil {
+		fmt.Println("failed to find user by id:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	existing, err := h.MfaRepo.FindByUserID(r.Context(), userID)
+	if err != nil && !errors.Is(err, mfa.ErrNotExist) {
+		fmt.Println("failed to find mfa enrollment:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if existing != nil && existing.Confirmed {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+
+	secret, err := mfa.GenerateSecret()
+	if err != nil {
+		fmt.Println("failed to generate totp secret:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	err = h.MfaRepo.Enroll(r.Context(), userID, secret)
+	if err != nil {
+		fmt.Println("failed to enroll mfa:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	uri := h.TOTP.URI(u.Username, secret)
+	png, err := mfa.QRCode(uri, qrCodeSize)
+	if err != nil {
+		fmt.Println("failed to generate qr code:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	response := struct {
+		Secret string `json:"secret"`
+		URI    string `json:"otpauth_uri"`
+		QRCode string `json:"qr_png"`
+	}{
+		Secret: secret,
+		URI:    uri,
+		QRCode: base64.StdEncoding.EncodeToString(png),
+	}
+
+	res, err := json.Marshal(response)
+	if err != nil {
+		fmt.Println("failed to marshal response:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	w.Write(res)
+}
+
+// QRCode serves the pending enrollment's otpauth URI as a PNG image.
+func (h *MFA) QRCode(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	u, err := h.UserRepo.FindByID(r.Context(), userID)
+	if err != nil {
+		fmt.Println("failed to find user by id:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	enrollment, err := h.MfaRepo.FindByUserID(r.Context(), userID)
+	if errors.Is(err, mfa.ErrNotExist) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		fmt.Println("failed to find mfa enrollment:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if enrollment.Confirmed {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	png, err := mfa.QRCode(h.TOTP.URI(u.Username, enrollment.Secret), qrCodeSize)
+	if err != nil {
+		fmt.Println("failed to generate qr code:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(png)
+}
+
+func (h *MFA) ConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Code string `json:"code"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	userID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	enrollment, err := h.MfaRepo.FindByUserID(r.Context(), userID)
+	if errors.Is(err, mfa.ErrNotExist) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		fmt.Println("failed to find mfa enrollment:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if enrollment.Confirmed {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+
+	if !h.checkCodeLockout(w, r, userID) {
+		return
+	}
+
+	step, ok := h.TOTP.Validate(enrollment.Secret, body.Code, enrollment.LastUsedStep)
+	if !ok {
+		h.rejectCode(w, r, userID)
+		return
+	}
+	h.acceptCode(r, userID)
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		fmt.Println("failed to generate recovery codes:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	err = h.MfaRepo.Confirm(r.Context(), userID, step, hashes)
+	if err != nil {
+		fmt.Println("failed to confirm mfa:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	writeRecoveryCodes(w, codes)
+}
+
+// RegenerateRecoveryCodes invalidates all existing recovery codes. A current
+// TOTP code is required so a stolen session alone cannot mint new ones.
+func (h *MFA) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Code string `json:"code"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	userID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	enrollment, err := h.MfaRepo.FindByUserID(r.Context(), userID)
+	if errors.Is(err, mfa.ErrNotExist) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		fmt.Println("failed to find mfa enrollment:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !enrollment.Confirmed {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if !h.checkCodeLockout(w, r, userID) {
+		return
+	}
+
+	step, ok := h.TOTP.Validate(enrollment.Secret, body.Code, enrollment.LastUsedStep)
+	if !ok {
+		h.rejectCode(w, r, userID)
+		return
+	}
+
+	accepted, err := h.MfaRepo.MarkStepUsed(r.Context(), userID, step)
+	if err != nil {
+		fmt.Println("failed to update mfa step:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !accepted {
+		h.rejectCode(w, r, userID)
+		return
+	}
+	h.acceptCode(r, userID)
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		fmt.Println("failed to generate recovery codes:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	err = h.MfaRepo.ReplaceRecoveryCodes(r.Context(), userID, hashes)
+	if err != nil {
+		fmt.Println("failed to replace recovery codes:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	writeRecoveryCodes(w, codes)
+}
+
+func (h *MFA) Status(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var response struct {
+		Enabled                bool `json:"enabled"`
+		RemainingRecoveryCodes int  `json:"remaining_recovery_codes"`
+	}
+
+	enrollment, err := h.MfaRepo.FindByUserID(r.Context(), userID)
+	if err != nil && !errors.Is(err, mfa.ErrNotExist) {
+		fmt.Println("failed to find mfa enrollment:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if enrollment != nil && enrollment.Confirmed {
+		response.Enabled = true
+		response.RemainingRecoveryCodes, err = h.MfaRepo.CountUnusedRecoveryCodes(r.Context(), userID)
+		if err != nil {
+			fmt.Println("failed to count recovery codes:", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		fmt.Println("failed to marshal mfa status:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// Reset is the admin-initiated removal of a user's MFA enrollment, used when
+// a user has lost both their authenticator and recovery codes.
+func (h *MFA) Reset(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	err = h.MfaRepo.Reset(r.Context(), userID)
+	if errors.Is(err, mfa.ErrNotExist) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		fmt.Println("failed to reset mfa:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	recordAudit(r, h.Audit, newAuditEntry(r, model.AuditMFAReset, &userID, nil))
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func newRecoveryCodes() ([]string, []string, error) {
+	codes, err := mfa.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = mfa.HashRecoveryCode(code)
+	}
+	return codes, hashes, nil
+}
+
+func writeRecoveryCodes(w http.ResponseWriter, codes []string) {
+	response := struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}{
+		RecoveryCodes: codes,
+	}
+
+	res, err := json.Marshal(response)
+	if err != nil {
+		fmt.Println("failed to marshal response:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	w.Write(res)
+}
@@ -0,0 +1,460 @@
+package handler
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+
+	"github.com/CatalinPlesu/user-service/messaging"
+	"github.com/CatalinPlesu/user-service/model"
+	"github.com/CatalinPlesu/user-service/repository/audit"
+	"github.com/CatalinPlesu/user-service/repository/jwts"
+	"github.com/CatalinPlesu/user-service/repository/passkey"
+	"github.com/CatalinPlesu/user-service/repository/user"
+)
+
+// Only self-attested or unattested credentials are accepted; we do not keep a
+// trust store for vendor attestation roots.
+var allowedAttestationFormats = map[string]bool{
+	"none":   true,
+	"packed": true,
+}
+
+var (
+	errAttestationFormat = errors.New("attestation format not accepted")
+	errPasskeyRejected   = errors.New("passkey assertion rejected")
+	// errClonedPasskey means the sign count did not increase, so the
+	// credential may have been cloned.
+	errClonedPasskey = errors.New("passkey sign count did not increase")
+)
+
+type Passkey struct {
+	WebAuthn    *webauthn.WebAuthn
+	PasskeyRepo *passkey.PostgresRepo
+	SessionRepo *passkey.SessionRepo
+	UserRepo    *user.PostgresRepo
+	RdRepo      *jwts.RedisRepo
+	Outbox      messaging.Enqueuer
+	Audit       *audit.PostgresRepo
+	// DecoyKey derives the stand-in credentials offered for usernames that
+	// have no passkeys.
+	DecoyKey []byte
+}
+
+type passkeyUser struct {
+	user        *model.User
+	credentials []model.PasskeyCredential
+}
+
+func (u *passkeyUser) WebAuthnID() []byte {
+	id := u.user.UserID
+	return id[:]
+}
+
+func (u *passkeyUser) WebAuthnName() string {
+	return u.user.Username
+}
+
+func (u *passkeyUser) WebAuthnDisplayName() string {
+	return u.user.DisplayName
+}
+
+func (u *passkeyUser) WebAuthnIcon() string {
+	return ""
+}
+
+func (u *passkeyUser) WebAuthnCredentials() []webauthn.Credential {
+	credentials := make([]webauthn.Credential, len(u.credentials))
+	for i, c := range u.credentials {
+		transports := make([]protocol.AuthenticatorTransport, len(c.Transports))
+		for j, t := range c.Transports {
+			transports[j] = protocol.AuthenticatorTransport(t)
+		}
+
+		credentials[i] = webauthn.Credential{
+			ID:              c.CredentialID,
+			PublicKey:       c.PublicKey,
+			AttestationType: c.AttestationType,
+			Transport:       transports,
+			Flags: webauthn.CredentialFlags{
+				BackupEligible: c.BackupEligible,
+				BackupState:    c.BackupState,
+			},
+			Authenticator: webauthn.Authenticator{
+				AAGUID:    c.AAGUID,
+				SignCount: uint32(c.SignCount),
+			},
+		}
+	}
+	return credentials
+}
+
+func (h *Passkey) loadUser(r *http.Request, userID uuid.UUID) (*passkeyUser, error) {
+	u, err := h.UserRepo.FindByID(r.Context(), userID)
+	if err != nil {
+		return nil, err
+	}
+
+	credentials, err := h.PasskeyRepo.FindByUserID(r.Context(), userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &passkeyUser{user: u, credentials: credentials}, nil
+}
+
+// decoyUser stands in for a username that does not exist or has no
+// passkeys, so BeginLogin answers it like any other. Its credential ID is
+// derived from the username, so asking twice gives the same challenge; its
+// user ID matches no user, so the login can never finish.
+func (h *Passkey) decoyUser(username string) *passkeyUser {
+	mac := hmac.New(sha256.New, h.DecoyKey)
+	mac.Write([]byte("passkey-decoy:" + username))
+
+	return &passkeyUser{
+		user: &model.User{UserID: uuid.New(), Username: username},
+		credentials: []model.PasskeyCredential{{
+			CredentialID: mac.Sum(nil),
+			Transports:   []string{"internal", "hybrid"},
+		}},
+	}
+}
+
+// verifyRegistration checks the attestation response in r against session
+// and returns the credential to store.
+func (h *Passkey) verifyRegistration(pu *passkeyUser, session webauthn.SessionData, r *http.Request) (*model.PasskeyCredential, error) {
+	credential, err := h.WebAuthn.FinishRegistration(pu, session, r)
+	if err != nil {
+		return nil, err
+	}
+
+	if !allowedAttestationFormats[credential.AttestationType] {
+		return nil, errAttestationFormat
+	}
+
+	transports := make([]string, len(credential.Transport))
+	for i, t := range credential.Transport {
+		transports[i] = string(t)
+	}
+
+	now := time.Now().UTC()
+	return &model.PasskeyCredential{
+		CredentialID:    credential.ID,
+		UserID:          pu.user.UserID,
+		Name:            r.URL.Query().Get("name"),
+		PublicKey:       credential.PublicKey,
+		AttestationType: credential.AttestationType,
+		Transports:      transports,
+		AAGUID:          credential.Authenticator.AAGUID,
+		SignCount:       int64(credential.Authenticator.SignCount),
+		BackupEligible:  credential.Flags.BackupEligible,
+		BackupState:     credential.Flags.BackupState,
+		CreatedAt:       &now,
+	}, nil
+}
+
+// verifyLogin checks the assertion in r against session. load finds the
+// user the assertion is for, named by the session or, for discoverable
+// logins, by the user handle. Assertions that do not verify return
+// errPasskeyRejected or errClonedPasskey.
+func (h *Passkey) verifyLogin(session webauthn.SessionData, r *http.Request, load func(userID uuid.UUID) (*passkeyUser, error)) (*passkeyUser, *webauthn.Credential, error) {
+	var pu *passkeyUser
+	var credential *webauthn.Credential
+	var err error
+
+	if len(session.UserID) == 0 {
+		credential, err = h.WebAuthn.FinishDiscoverableLogin(func(rawID, userHandle []byte) (webauthn.User, error) {
+			userID, err := uuid.FromBytes(userHandle)
+			if err != nil {
+				return nil, err
+			}
+
+			pu, err = load(userID)
+			return pu, err
+		}, session, r)
+	} else {
+		userID, parseErr := uuid.FromBytes(session.UserID)
+		if parseErr != nil {
+			return nil, nil, fmt.Errorf("%w: %v", errPasskeyRejected, parseErr)
+		}
+
+		// Sessions started for a decoy name a user that does not exist.
+		pu, err = load(userID)
+		if errors.Is(err, user.ErrNotExist) {
+			return nil, nil, fmt.Errorf("%w: %v", errPasskeyRejected, err)
+		} else if err != nil {
+			return nil, nil, err
+		}
+
+		credential, err = h.WebAuthn.FinishLogin(pu, session, r)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", errPasskeyRejected, err)
+	}
+
+	if credential.Authenticator.CloneWarning {
+		return nil, nil, fmt.Errorf("%w for user %s", errClonedPasskey, pu.user.UserID)
+	}
+	return pu, credential, nil
+}
+
+func writeCeremony(w http.ResponseWriter, sessionID string, options interface{}) {
+	response := struct {
+		SessionID string      `json:"session_id"`
+		Options   interface{} `json:"options"`
+	}{
+		SessionID: sessionID,
+		Options:   options,
+	}
+
+	res, err := json.Marshal(response)
+	if err != nil {
+		fmt.Println("failed to marshal response:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(res)
+}
+
+func (h *Passkey) BeginRegistration(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	pu, err := h.loadUser(r, userID)
+	if err != nil {
+		fmt.Println("failed to load passkey user:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	exclusions := make([]protocol.CredentialDescriptor, 0, len(pu.credentials))
+	for _, c := range pu.WebAuthnCredentials() {
+		exclusions = append(exclusions, c.Descriptor())
+	}
+
+	creation, session, err := h.WebAuthn.BeginRegistration(pu,
+		webauthn.WithExclusions(exclusions),
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementPreferred),
+	)
+	if err != nil {
+		fmt.Println("failed to begin passkey registration:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	sessionID, err := h.SessionRepo.Insert(r.Context(), session)
+	if err != nil {
+		fmt.Println("failed to store webauthn session:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	writeCeremony(w, sessionID, creation)
+}
+
+// FinishRegistration expects the authenticator's attestation response as the
+// request body and the session_id returned by BeginRegistration as a query
+// parameter, along with an optional name for the passkey.
+func (h *Passkey) FinishRegistration(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	session, err := h.SessionRepo.Take(r.Context(), r.URL.Query().Get("session_id"))
+	if errors.Is(err, passkey.ErrSessionNotExist) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	} else if err != nil {
+		fmt.Println("failed to load webauthn session:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	pu, err := h.loadUser(r, userID)
+	if err != nil {
+		fmt.Println("failed to load passkey user:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	stored, err := h.verifyRegistration(pu, *session, r)
+	if errors.Is(err, errAttestationFormat) {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		return
+	} else if err != nil {
+		fmt.Println("failed to finish passkey registration:", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	err = h.PasskeyRepo.Insert(r.Context(), *stored)
+	if err != nil {
+		fmt.Println("failed to insert passkey:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	res, err := json.Marshal(stored)
+	if err != nil {
+		fmt.Println("failed to marshal response:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	w.Write(res)
+}
+
+func (h *Passkey) List(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	credentials, err := h.PasskeyRepo.FindByUserID(r.Context(), userID)
+	if err != nil {
+		fmt.Println("failed to find passkeys:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(credentials); err != nil {
+		fmt.Println("failed to marshal passkeys:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *Passkey) Delete(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	credentialID, err := base64.RawURLEncoding.DecodeString(chi.URLParam(r, "credentialID"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	err = h.PasskeyRepo.Delete(r.Context(), userID, credentialID)
+	if errors.Is(err, passkey.ErrNotExist) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		fmt.Println("failed to delete passkey:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// BeginLogin starts an assertion ceremony. When a username is supplied the
+// user's credentials are sent as allowCredentials, otherwise a discoverable
+// credential login is started and the user is resolved from the user handle.
+// Usernames without passkeys get a decoy challenge, so the response does not
+// reveal which accounts exist.
+func (h *Passkey) BeginLogin(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Username string `json:"username"`
+	}
+
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	var assertion *protocol.CredentialAssertion
+	var session *webauthn.SessionData
+	var err error
+
+	if body.Username == "" {
+		assertion, session, err = h.WebAuthn.BeginDiscoverableLogin()
+	} else {
+		var pu *passkeyUser
+		u, findErr := h.UserRepo.FindByUsername(r.Context(), body.Username)
+		if findErr == nil {
+			pu, findErr = h.loadUser(r, u.UserID)
+		}
+		if findErr != nil && !errors.Is(findErr, user.ErrNotExist) {
+			fmt.Println("failed to load passkey user:", findErr)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if pu == nil || len(pu.credentials) == 0 {
+			pu = h.decoyUser(body.Username)
+		}
+
+		assertion, session, err = h.WebAuthn.BeginLogin(pu)
+	}
+	if err != nil {
+		fmt.Println("failed to begin passkey login:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	sessionID, err := h.SessionRepo.Insert(r.Context(), session)
+	if err != nil {
+		fmt.Println("failed to store webauthn session:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	writeCeremony(w, sessionID, assertion)
+}
+
+func (h *Passkey) FinishLogin(w http.ResponseWriter, r *http.Request) {
+	session, err := h.SessionRepo.Take(r.Context(), r.URL.Query().Get("session_id"))
+	if errors.Is(err, passkey.ErrSessionNotExist) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	} else if err != nil {
+		fmt.Println("failed to load webauthn session:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	pu, credential, err := h.verifyLogin(*session, r, func(userID uuid.UUID) (*passkeyUser, error) {
+		return h.loadUser(r, userID)
+	})
+	if errors.Is(err, errPasskeyRejected) || errors.Is(err, errClonedPasskey) {
+		fmt.Println("failed to finish passkey login:", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	} else if err != nil {
+		fmt.Println("failed to load passkey user:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	err = h.PasskeyRepo.UpdateSignCount(r.Context(), credential.ID, credential.Authenticator.SignCount, credential.Flags.BackupState)
+	if err != nil {
+		fmt.Println("failed to update passkey sign count:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	issueSession(w, r, h.UserRepo.DB, h.RdRepo, h.Outbox, h.Audit, pu.user, "passkey")
+}
@@ -0,0 +1,341 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+
+	"github.com/CatalinPlesu/user-service/model"
+	"github.com/CatalinPlesu/user-service/repository/user"
+)
+
+const (
+	testRPID   = "localhost"
+	testOrigin = "https://localhost"
+)
+
+// Authenticator data flags.
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttested     = 0x40
+)
+
+// softAuthenticator is a software WebAuthn authenticator holding a single
+// ES256 credential. It produces "none" attestations.
+type softAuthenticator struct {
+	origin       string
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	signCount    uint32
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	credentialID := make([]byte, 32)
+	rand.Read(credentialID)
+
+	return &softAuthenticator{origin: testOrigin, key: key, credentialID: credentialID}
+}
+
+func (a *softAuthenticator) authenticatorData(flags byte, attested []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(testRPID))
+	data := append(rpIDHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+	return append(data, attested...)
+}
+
+func (a *softAuthenticator) clientData(ceremony protocol.CeremonyType, challenge string) []byte {
+	data, _ := json.Marshal(map[string]string{
+		"type":      string(ceremony),
+		"challenge": challenge,
+		"origin":    a.origin,
+	})
+	return data
+}
+
+// create answers a navigator.credentials.create() call.
+func (a *softAuthenticator) create(t *testing.T, challenge string) *http.Request {
+	t.Helper()
+
+	publicKey, err := webauthncbor.Marshal(webauthncose.EC2PublicKeyData{
+		PublicKeyData: webauthncose.PublicKeyData{
+			KeyType:   int64(webauthncose.EllipticKey),
+			Algorithm: int64(webauthncose.AlgES256),
+		},
+		Curve:  1, // P-256
+		XCoord: a.key.X.FillBytes(make([]byte, 32)),
+		YCoord: a.key.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		t.Fatalf("failed to encode public key: %v", err)
+	}
+
+	attested := make([]byte, 16) // zero AAGUID
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(a.credentialID)))
+	attested = append(attested, a.credentialID...)
+	attested = append(attested, publicKey...)
+
+	attestation, err := webauthncbor.Marshal(map[string]any{
+		"fmt":      "none",
+		"attStmt":  map[string]any{},
+		"authData": a.authenticatorData(flagUserPresent|flagUserVerified|flagAttested, attested),
+	})
+	if err != nil {
+		t.Fatalf("failed to encode attestation: %v", err)
+	}
+
+	return credentialRequest(t, a.credentialID, map[string]string{
+		"clientDataJSON":    encode(a.clientData(protocol.CreateCeremony, challenge)),
+		"attestationObject": encode(attestation),
+	})
+}
+
+// get answers a navigator.credentials.get() call, incrementing the sign
+// count first like a real authenticator.
+func (a *softAuthenticator) get(t *testing.T, challenge string, userHandle []byte) *http.Request {
+	t.Helper()
+
+	a.signCount++
+	authData := a.authenticatorData(flagUserPresent|flagUserVerified, nil)
+	clientData := a.clientData(protocol.AssertCeremony, challenge)
+
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(authData, clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatalf("failed to sign assertion: %v", err)
+	}
+
+	return credentialRequest(t, a.credentialID, map[string]string{
+		"clientDataJSON":    encode(clientData),
+		"authenticatorData": encode(authData),
+		"signature":         encode(signature),
+		"userHandle":        encode(userHandle),
+	})
+}
+
+func credentialRequest(t *testing.T, credentialID []byte, response map[string]string) *http.Request {
+	t.Helper()
+
+	body, err := json.Marshal(map[string]any{
+		"id":       encode(credentialID),
+		"rawId":    encode(credentialID),
+		"type":     "public-key",
+		"response": response,
+	})
+	if err != nil {
+		t.Fatalf("failed to encode credential: %v", err)
+	}
+	return httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+}
+
+func encode(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func newTestPasskey(t *testing.T) *Passkey {
+	t.Helper()
+
+	wa, err := webauthn.New(&webauthn.Config{
+		RPID:                  testRPID,
+		RPDisplayName:         "test",
+		RPOrigins:             []string{testOrigin},
+		AttestationPreference: protocol.PreferNoAttestation,
+	})
+	if err != nil {
+		t.Fatalf("failed to configure webauthn: %v", err)
+	}
+	return &Passkey{WebAuthn: wa, DecoyKey: []byte("test-decoy-key")}
+}
+
+func newTestPasskeyUser() *passkeyUser {
+	return &passkeyUser{user: &model.User{UserID: uuid.New(), Username: "alice", DisplayName: "Alice"}}
+}
+
+// register runs a registration ceremony and stores the credential on pu.
+func register(t *testing.T, h *Passkey, pu *passkeyUser, a *softAuthenticator) {
+	t.Helper()
+
+	_, session, err := h.WebAuthn.BeginRegistration(pu)
+	if err != nil {
+		t.Fatalf("failed to begin registration: %v", err)
+	}
+
+	stored, err := h.verifyRegistration(pu, *session, a.create(t, session.Challenge))
+	if err != nil {
+		t.Fatalf("failed to verify registration: %v", err)
+	}
+	pu.credentials = append(pu.credentials, *stored)
+}
+
+// login runs a login ceremony for pu's credentials and records the new
+// sign count like FinishLogin does.
+func login(t *testing.T, h *Passkey, pu *passkeyUser, a *softAuthenticator) (*webauthn.Credential, error) {
+	t.Helper()
+
+	_, session, err := h.WebAuthn.BeginLogin(pu)
+	if err != nil {
+		t.Fatalf("failed to begin login: %v", err)
+	}
+
+	_, credential, err := h.verifyLogin(*session, a.get(t, session.Challenge, pu.WebAuthnID()), func(uuid.UUID) (*passkeyUser, error) {
+		return pu, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	pu.credentials[0].SignCount = int64(credential.Authenticator.SignCount)
+	return credential, nil
+}
+
+func TestPasskeyRegistration(t *testing.T) {
+	h := newTestPasskey(t)
+	pu := newTestPasskeyUser()
+	a := newSoftAuthenticator(t)
+
+	register(t, h, pu, a)
+
+	stored := pu.credentials[0]
+	if !bytes.Equal(stored.CredentialID, a.credentialID) {
+		t.Errorf("credential ID = %x, want %x", stored.CredentialID, a.credentialID)
+	}
+	if stored.UserID != pu.user.UserID {
+		t.Errorf("user ID = %s, want %s", stored.UserID, pu.user.UserID)
+	}
+	if stored.AttestationType != "none" {
+		t.Errorf("attestation type = %q, want none", stored.AttestationType)
+	}
+}
+
+func TestPasskeyLogin(t *testing.T) {
+	h := newTestPasskey(t)
+	pu := newTestPasskeyUser()
+	a := newSoftAuthenticator(t)
+	register(t, h, pu, a)
+
+	for want := uint32(1); want <= 3; want++ {
+		credential, err := login(t, h, pu, a)
+		if err != nil {
+			t.Fatalf("login %d failed: %v", want, err)
+		}
+		if credential.Authenticator.SignCount != want {
+			t.Errorf("sign count = %d, want %d", credential.Authenticator.SignCount, want)
+		}
+	}
+}
+
+func TestPasskeyDiscoverableLogin(t *testing.T) {
+	h := newTestPasskey(t)
+	pu := newTestPasskeyUser()
+	a := newSoftAuthenticator(t)
+	register(t, h, pu, a)
+
+	_, session, err := h.WebAuthn.BeginDiscoverableLogin()
+	if err != nil {
+		t.Fatalf("failed to begin login: %v", err)
+	}
+
+	found, _, err := h.verifyLogin(*session, a.get(t, session.Challenge, pu.WebAuthnID()), func(userID uuid.UUID) (*passkeyUser, error) {
+		if userID != pu.user.UserID {
+			return nil, user.ErrNotExist
+		}
+		return pu, nil
+	})
+	if err != nil {
+		t.Fatalf("failed to verify login: %v", err)
+	}
+	if found != pu {
+		t.Errorf("login resolved the wrong user")
+	}
+}
+
+func TestPasskeyLoginReplayedSignCount(t *testing.T) {
+	h := newTestPasskey(t)
+	pu := newTestPasskeyUser()
+	a := newSoftAuthenticator(t)
+	register(t, h, pu, a)
+
+	if _, err := login(t, h, pu, a); err != nil {
+		t.Fatalf("first login failed: %v", err)
+	}
+
+	// A clone of the authenticator starts from the count the original had.
+	clone := *a
+	clone.signCount--
+	if _, err := login(t, h, pu, &clone); !errors.Is(err, errClonedPasskey) {
+		t.Errorf("replayed sign count: err = %v, want %v", err, errClonedPasskey)
+	}
+}
+
+func TestPasskeyWrongOrigin(t *testing.T) {
+	h := newTestPasskey(t)
+	pu := newTestPasskeyUser()
+	a := newSoftAuthenticator(t)
+	a.origin = "https://evil.example"
+
+	_, session, err := h.WebAuthn.BeginRegistration(pu)
+	if err != nil {
+		t.Fatalf("failed to begin registration: %v", err)
+	}
+	if _, err := h.verifyRegistration(pu, *session, a.create(t, session.Challenge)); err == nil {
+		t.Errorf("registration from the wrong origin was accepted")
+	}
+
+	a.origin = testOrigin
+	register(t, h, pu, a)
+
+	a.origin = "https://evil.example"
+	if _, err := login(t, h, pu, a); !errors.Is(err, errPasskeyRejected) {
+		t.Errorf("login from the wrong origin: err = %v, want %v", err, errPasskeyRejected)
+	}
+}
+
+func TestPasskeyDecoyLogin(t *testing.T) {
+	h := newTestPasskey(t)
+
+	first, second := h.decoyUser("nobody"), h.decoyUser("nobody")
+	if !bytes.Equal(first.credentials[0].CredentialID, second.credentials[0].CredentialID) {
+		t.Errorf("decoy credentials differ between requests for the same username")
+	}
+	if bytes.Equal(first.credentials[0].CredentialID, h.decoyUser("somebody").credentials[0].CredentialID) {
+		t.Errorf("decoy credentials are the same for different usernames")
+	}
+
+	assertion, session, err := h.WebAuthn.BeginLogin(first)
+	if err != nil {
+		t.Fatalf("failed to begin decoy login: %v", err)
+	}
+	if len(assertion.Response.AllowedCredentials) != 1 {
+		t.Errorf("decoy challenge lists %d credentials, want 1", len(assertion.Response.AllowedCredentials))
+	}
+
+	a := newSoftAuthenticator(t)
+	_, _, err = h.verifyLogin(*session, a.get(t, session.Challenge, nil), func(uuid.UUID) (*passkeyUser, error) {
+		return nil, user.ErrNotExist
+	})
+	if !errors.Is(err, errPasskeyRejected) {
+		t.Errorf("decoy login: err = %v, want %v", err, errPasskeyRejected)
+	}
+}
@@ -0,0 +1,113 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/uptrace/bun"
+
+	"github.com/CatalinPlesu/user-service/model"
+	"github.com/CatalinPlesu/user-service/repository/audit"
+	"github.com/CatalinPlesu/user-service/repository/user"
+)
+
+// ChangePassword replaces the user's password. The current one must be
+// given, so a stolen session alone cannot take the account over, and wrong
+// guesses are throttled like logins.
+func (h *User) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if body.CurrentPassword == "" || body.NewPassword == "" {
+		writeProblem(w, http.StatusUnprocessableEntity, "field_required", "Field is required",
+			"current_password and new_password are both required.")
+		return
+	}
+
+	theUser := h.loadForUpdate(w, r)
+	if theUser == nil {
+		return
+	}
+
+	account := "password:" + theUser.UserID.String()
+	ip := clientIP(r)
+	wait, err := h.Lockout.Check(r.Context(), account, ip)
+	if err != nil {
+		fmt.Println("failed to check login lockout:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if wait > 0 {
+		writeTooManyAttempts(w, wait)
+		return
+	}
+
+	if match, _ := user.VerifyPassword(theUser.Password, body.CurrentPassword); !match {
+		wait, err := h.Lockout.RecordFailure(r.Context(), account, ip)
+		if err != nil {
+			fmt.Println("failed to record login failure:", err)
+		}
+		if wait > 0 {
+			setRetryAfter(w, wait)
+		}
+		writeProblem(w, http.StatusForbidden, "wrong_password", "Wrong password", "The current password is not correct.")
+		return
+	}
+
+	if err := h.Lockout.Reset(r.Context(), account); err != nil {
+		fmt.Println("failed to reset login failures:", err)
+	}
+
+	if !h.acceptablePassword(w, body.NewPassword) {
+		return
+	}
+
+	passwordHash, err := user.HashPassword(body.NewPassword)
+	if err != nil {
+		fmt.Println("failed to hash password:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	before := theUser.Password
+	now := time.Now().UTC()
+	theUser.Password = passwordHash
+	theUser.UpdatedAt = &now
+
+	// The password is not part of the user's JSON, so the change is
+	// recorded on its own; Diff redacts it.
+	changes := audit.Diff(map[string]any{"password": before}, map[string]any{"password": passwordHash})
+
+	err = h.PgRepo.DB.RunInTx(r.Context(), nil, func(ctx context.Context, tx bun.Tx) error {
+		if err := h.PgRepo.WithTx(tx).Update(ctx, theUser); err != nil {
+			return err
+		}
+		return h.Audit.WithTx(tx).Append(ctx, newAuditEntry(r, model.AuditPasswordChanged, &theUser.UserID, changes))
+	})
+	var conflict *user.ConflictError
+	if errors.As(err, &conflict) {
+		writeConflict(w, r, conflict.Current)
+		return
+	} else if errors.Is(err, user.ErrNotExist) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		fmt.Println("failed to change password:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("ETag", userETag(theUser))
+	w.WriteHeader(http.StatusNoContent)
+}
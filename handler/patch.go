@@ -0,0 +1,258 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+var (
+	errInvalidPatch = errors.New("invalid patch")
+	// errPatchTest means a JSON Patch test operation did not hold.
+	errPatchTest = errors.New("patch test failed")
+)
+
+// mergePatch applies an RFC 7396 JSON Merge Patch to doc.
+func mergePatch(doc, patch any) any {
+	patchObject, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+
+	docObject, ok := doc.(map[string]any)
+	if !ok {
+		docObject = map[string]any{}
+	}
+
+	for name, value := range patchObject {
+		if value == nil {
+			delete(docObject, name)
+			continue
+		}
+		docObject[name] = mergePatch(docObject[name], value)
+	}
+	return docObject
+}
+
+// patchOperation is one operation of an RFC 6902 JSON Patch.
+type patchOperation struct {
+	Op    string `json:"op"`
+	Path  string `json:"path"`
+	From  string `json:"from"`
+	Value any    `json:"value"`
+}
+
+// jsonPatch applies the operations to doc in order. It fails with
+// errInvalidPatch for malformed operations and errPatchTest when a test
+// operation does not hold; either way nothing is applied.
+func jsonPatch(doc any, operations []patchOperation) (any, error) {
+	doc = deepCopy(doc)
+
+	var err error
+	for i, op := range operations {
+		switch op.Op {
+		case "add":
+			doc, err = pointerAdd(doc, op.Path, deepCopy(op.Value))
+		case "remove":
+			doc, _, err = pointerRemove(doc, op.Path)
+		case "replace":
+			if doc, _, err = pointerRemove(doc, op.Path); err == nil {
+				doc, err = pointerAdd(doc, op.Path, deepCopy(op.Value))
+			}
+		case "move":
+			if strings.HasPrefix(op.Path, op.From+"/") {
+				return nil, fmt.Errorf("%w: operation %d moves %s into itself", errInvalidPatch, i, op.From)
+			}
+			var value any
+			if doc, value, err = pointerRemove(doc, op.From); err == nil {
+				doc, err = pointerAdd(doc, op.Path, value)
+			}
+		case "copy":
+			var value any
+			if value, err = pointerGet(doc, op.From); err == nil {
+				doc, err = pointerAdd(doc, op.Path, deepCopy(value))
+			}
+		case "test":
+			// A missing path makes the patch invalid rather than the test
+			// fail, the same as for the other operations.
+			var value any
+			value, err = pointerGet(doc, op.Path)
+			if err == nil && !reflect.DeepEqual(value, op.Value) {
+				return nil, fmt.Errorf("%w: %s", errPatchTest, op.Path)
+			}
+		default:
+			return nil, fmt.Errorf("%w: operation %d has unknown op %q", errInvalidPatch, i, op.Op)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("operation %d: %w", i, err)
+		}
+	}
+	return doc, nil
+}
+
+// parsePointer splits an RFC 6901 JSON Pointer into unescaped tokens.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("%w: pointer %q must start with /", errInvalidPatch, pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+	}
+	return tokens, nil
+}
+
+func pointerGet(doc any, pointer string) (any, error) {
+	tokens, err := parsePointer(pointer)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, token := range tokens {
+		switch container := doc.(type) {
+		case map[string]any:
+			value, ok := container[token]
+			if !ok {
+				return nil, fmt.Errorf("%w: %s does not exist", errInvalidPatch, pointer)
+			}
+			doc = value
+		case []any:
+			index, err := arrayIndex(token, len(container)-1)
+			if err != nil {
+				return nil, err
+			}
+			doc = container[index]
+		default:
+			return nil, fmt.Errorf("%w: %s does not exist", errInvalidPatch, pointer)
+		}
+	}
+	return doc, nil
+}
+
+// pointerAdd adds value at pointer and returns the updated document, which
+// is value itself when pointer refers to the whole document.
+func pointerAdd(doc any, pointer string, value any) (any, error) {
+	tokens, err := parsePointer(pointer)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return value, nil
+	}
+
+	parent, err := pointerGet(doc, joinPointer(tokens[:len(tokens)-1]))
+	if err != nil {
+		return nil, err
+	}
+
+	last := tokens[len(tokens)-1]
+	switch container := parent.(type) {
+	case map[string]any:
+		container[last] = value
+		return doc, nil
+	case []any:
+		index := len(container)
+		if last != "-" {
+			if index, err = arrayIndex(last, len(container)); err != nil {
+				return nil, err
+			}
+		}
+		grown := append(container[:index:index], append([]any{value}, container[index:]...)...)
+		return replaceAt(doc, tokens[:len(tokens)-1], grown)
+	default:
+		return nil, fmt.Errorf("%w: cannot add to %s", errInvalidPatch, pointer)
+	}
+}
+
+// pointerRemove removes the value at pointer and returns the updated
+// document and the removed value.
+func pointerRemove(doc any, pointer string) (any, any, error) {
+	tokens, err := parsePointer(pointer)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, doc, nil
+	}
+
+	parent, err := pointerGet(doc, joinPointer(tokens[:len(tokens)-1]))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	last := tokens[len(tokens)-1]
+	switch container := parent.(type) {
+	case map[string]any:
+		value, ok := container[last]
+		if !ok {
+			return nil, nil, fmt.Errorf("%w: %s does not exist", errInvalidPatch, pointer)
+		}
+		delete(container, last)
+		return doc, value, nil
+	case []any:
+		index, err := arrayIndex(last, len(container)-1)
+		if err != nil {
+			return nil, nil, err
+		}
+		value := container[index]
+		shrunk := append(container[:index:index], container[index+1:]...)
+		doc, err = replaceAt(doc, tokens[:len(tokens)-1], shrunk)
+		return doc, value, err
+	default:
+		return nil, nil, fmt.Errorf("%w: %s does not exist", errInvalidPatch, pointer)
+	}
+}
+
+// replaceAt stores value at the location of tokens, which is needed after
+// an array changed length.
+func replaceAt(doc any, tokens []string, value any) (any, error) {
+	if len(tokens) == 0 {
+		return value, nil
+	}
+	if _, _, err := pointerRemove(doc, joinPointer(tokens)); err != nil {
+		return nil, err
+	}
+	return pointerAdd(doc, joinPointer(tokens), value)
+}
+
+func joinPointer(tokens []string) string {
+	var b strings.Builder
+	for _, token := range tokens {
+		b.WriteString("/")
+		b.WriteString(strings.NewReplacer("~", "~0", "/", "~1").Replace(token))
+	}
+	return b.String()
+}
+
+func arrayIndex(token string, max int) (int, error) {
+	index, err := strconv.Atoi(token)
+	if err != nil || index < 0 || index > max || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("%w: invalid array index %q", errInvalidPatch, token)
+	}
+	return index, nil
+}
+
+func deepCopy(v any) any {
+	switch value := v.(type) {
+	case map[string]any:
+		copied := make(map[string]any, len(value))
+		for k, item := range value {
+			copied[k] = deepCopy(item)
+		}
+		return copied
+	case []any:
+		copied := make([]any, len(value))
+		for i, item := range value {
+			copied[i] = deepCopy(item)
+		}
+		return copied
+	default:
+		return value
+	}
+}
@@ -0,0 +1,346 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func decodeJSON(t *testing.T, s string) any {
+	t.Helper()
+
+	var v any
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		t.Fatalf("failed to decode %s: %v", s, err)
+	}
+	return v
+}
+
+// The cases up to A.16 are the examples of RFC 6902 appendix A, except A.13,
+// which is about duplicate JSON members and never reaches jsonPatch.
+func TestJSONPatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		doc     string
+		patch   string
+		want    string
+		wantErr error
+	}{
+		{
+			name:  "A.1 adding an object member",
+			doc:   `{"foo": "bar"}`,
+			patch: `[{"op": "add", "path": "/baz", "value": "qux"}]`,
+			want:  `{"baz": "qux", "foo": "bar"}`,
+		},
+		{
+			name:  "A.2 adding an array element",
+			doc:   `{"foo": ["bar", "baz"]}`,
+			patch: `[{"op": "add", "path": "/foo/1", "value": "qux"}]`,
+			want:  `{"foo": ["bar", "qux", "baz"]}`,
+		},
+		{
+			name:  "A.3 removing an object member",
+			doc:   `{"baz": "qux", "foo": "bar"}`,
+			patch: `[{"op": "remove", "path": "/baz"}]`,
+			want:  `{"foo": "bar"}`,
+		},
+		{
+			name:  "A.4 removing an array element",
+			doc:   `{"foo": ["bar", "qux", "baz"]}`,
+			patch: `[{"op": "remove", "path": "/foo/1"}]`,
+			want:  `{"foo": ["bar", "baz"]}`,
+		},
+		{
+			name:  "A.5 replacing a value",
+			doc:   `{"baz": "qux", "foo": "bar"}`,
+			patch: `[{"op": "replace", "path": "/baz", "value": "boo"}]`,
+			want:  `{"baz": "boo", "foo": "bar"}`,
+		},
+		{
+			name:  "A.6 moving a value",
+			doc:   `{"foo": {"bar": "baz", "waldo": "fred"}, "qux": {"corge": "grault"}}`,
+			patch: `[{"op": "move", "from": "/foo/waldo", "path": "/qux/thud"}]`,
+			want:  `{"foo": {"bar": "baz"}, "qux": {"corge": "grault", "thud": "fred"}}`,
+		},
+		{
+			name:  "A.7 moving an array element",
+			doc:   `{"foo": ["all", "grass", "cows", "eat"]}`,
+			patch: `[{"op": "move", "from": "/foo/1", "path": "/foo/3"}]`,
+			want:  `{"foo": ["all", "cows", "eat", "grass"]}`,
+		},
+		{
+			name: "A.8 testing a value: success",
+			doc:  `{"baz": "qux", "foo": ["a", 2, "c"]}`,
+			patch: `[
+				{"op": "test", "path": "/baz", "value": "qux"},
+				{"op": "test", "path": "/foo/1", "value": 2}
+			]`,
+			want: `{"baz": "qux", "foo": ["a", 2, "c"]}`,
+		},
+		{
+			name:    "A.9 testing a value: error",
+			doc:     `{"baz": "qux"}`,
+			patch:   `[{"op": "test", "path": "/baz", "value": "bar"}]`,
+			wantErr: errPatchTest,
+		},
+		{
+			name:  "A.10 adding a nested member object",
+			doc:   `{"foo": "bar"}`,
+			patch: `[{"op": "add", "path": "/child", "value": {"grandchild": {}}}]`,
+			want:  `{"foo": "bar", "child": {"grandchild": {}}}`,
+		},
+		{
+			name:  "A.11 ignoring unrecognized elements",
+			doc:   `{"foo": "bar"}`,
+			patch: `[{"op": "add", "path": "/baz", "value": "qux", "xyz": 123}]`,
+			want:  `{"foo": "bar", "baz": "qux"}`,
+		},
+		{
+			name:    "A.12 adding to a nonexistent target",
+			doc:     `{"foo": "bar"}`,
+			patch:   `[{"op": "add", "path": "/baz/bat", "value": "qux"}]`,
+			wantErr: errInvalidPatch,
+		},
+		{
+			name:  "A.14 ~ escape ordering",
+			doc:   `{"/": 9, "~1": 10}`,
+			patch: `[{"op": "test", "path": "/~01", "value": 10}]`,
+			want:  `{"/": 9, "~1": 10}`,
+		},
+		{
+			name:    "A.15 comparing strings and numbers",
+			doc:     `{"/": 9, "~1": 10}`,
+			patch:   `[{"op": "test", "path": "/~01", "value": "10"}]`,
+			wantErr: errPatchTest,
+		},
+		{
+			name:  "A.16 adding an array value",
+			doc:   `{"foo": ["bar"]}`,
+			patch: `[{"op": "add", "path": "/foo/-", "value": ["abc", "def"]}]`,
+			want:  `{"foo": ["bar", ["abc", "def"]]}`,
+		},
+		{
+			name:    "test on a missing path is invalid",
+			doc:     `{"foo": "bar"}`,
+			patch:   `[{"op": "test", "path": "/baz", "value": "qux"}]`,
+			wantErr: errInvalidPatch,
+		},
+		{
+			name:    "moving a value into itself",
+			doc:     `{"foo": {"bar": "baz"}}`,
+			patch:   `[{"op": "move", "from": "/foo", "path": "/foo/bar/qux"}]`,
+			wantErr: errInvalidPatch,
+		},
+		{
+			name:    "moving the root into itself",
+			doc:     `{"foo": "bar"}`,
+			patch:   `[{"op": "move", "from": "", "path": "/baz"}]`,
+			wantErr: errInvalidPatch,
+		},
+		{
+			name:  "moving a value onto itself",
+			doc:   `{"foo": {"bar": "baz"}}`,
+			patch: `[{"op": "move", "from": "/foo", "path": "/foo"}]`,
+			want:  `{"foo": {"bar": "baz"}}`,
+		},
+		{
+			name:  "moving to a sibling with a shared prefix",
+			doc:   `{"foo": 1}`,
+			patch: `[{"op": "move", "from": "/foo", "path": "/foobar"}]`,
+			want:  `{"foobar": 1}`,
+		},
+		{
+			name:  "adding to the end of a nested array",
+			doc:   `{"foo": [["a"], ["b"]]}`,
+			patch: `[{"op": "add", "path": "/foo/1/-", "value": "c"}]`,
+			want:  `{"foo": [["a"], ["b", "c"]]}`,
+		},
+		{
+			name:    "removing the end of an array",
+			doc:     `{"foo": ["bar"]}`,
+			patch:   `[{"op": "remove", "path": "/foo/-"}]`,
+			wantErr: errInvalidPatch,
+		},
+		{
+			name:    "adding past the end of an array",
+			doc:     `{"foo": ["bar"]}`,
+			patch:   `[{"op": "add", "path": "/foo/2", "value": "baz"}]`,
+			wantErr: errInvalidPatch,
+		},
+		{
+			name:    "array index with a leading zero",
+			doc:     `{"foo": ["bar", "baz"]}`,
+			patch:   `[{"op": "remove", "path": "/foo/01"}]`,
+			wantErr: errInvalidPatch,
+		},
+		{
+			name:  "replacing the root",
+			doc:   `{"foo": "bar"}`,
+			patch: `[{"op": "replace", "path": "", "value": {"baz": "qux"}}]`,
+			want:  `{"baz": "qux"}`,
+		},
+		{
+			name:  "copying a value",
+			doc:   `{"foo": {"bar": "baz"}}`,
+			patch: `[{"op": "copy", "from": "/foo", "path": "/qux"}, {"op": "add", "path": "/qux/bar", "value": 1}]`,
+			want:  `{"foo": {"bar": "baz"}, "qux": {"bar": 1}}`,
+		},
+		{
+			name:    "replacing a missing member",
+			doc:     `{"foo": "bar"}`,
+			patch:   `[{"op": "replace", "path": "/baz", "value": "qux"}]`,
+			wantErr: errInvalidPatch,
+		},
+		{
+			name:    "unknown op",
+			doc:     `{"foo": "bar"}`,
+			patch:   `[{"op": "merge", "path": "/foo", "value": "baz"}]`,
+			wantErr: errInvalidPatch,
+		},
+		{
+			name:    "pointer without a leading slash",
+			doc:     `{"foo": "bar"}`,
+			patch:   `[{"op": "remove", "path": "foo"}]`,
+			wantErr: errInvalidPatch,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var operations []patchOperation
+			if err := json.Unmarshal([]byte(tt.patch), &operations); err != nil {
+				t.Fatalf("failed to decode patch: %v", err)
+			}
+			doc := decodeJSON(t, tt.doc)
+			original := decodeJSON(t, tt.doc)
+
+			got, err := jsonPatch(doc, operations)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("error = %v, want %v", err, tt.wantErr)
+				}
+				if tt.wantErr == errInvalidPatch && errors.Is(err, errPatchTest) {
+					t.Fatalf("error = %v, want only %v", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			} else if want := decodeJSON(t, tt.want); !reflect.DeepEqual(got, want) {
+				t.Errorf("patched = %v, want %v", got, want)
+			}
+
+			if !reflect.DeepEqual(doc, original) {
+				t.Errorf("patch changed the original document to %v", doc)
+			}
+		})
+	}
+}
+
+func TestPointerRemoveRoot(t *testing.T) {
+	doc := decodeJSON(t, `{"foo": "bar"}`)
+
+	got, removed, err := pointerRemove(doc, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != nil {
+		t.Errorf("document = %v, want nil", got)
+	}
+	if !reflect.DeepEqual(removed, doc) {
+		t.Errorf("removed = %v, want the whole document", removed)
+	}
+}
+
+func TestPointerAddRoot(t *testing.T) {
+	value := decodeJSON(t, `["foo"]`)
+
+	got, err := pointerAdd(decodeJSON(t, `{"foo": "bar"}`), "", value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, value) {
+		t.Errorf("document = %v, want %v", got, value)
+	}
+}
+
+func TestReplaceAt(t *testing.T) {
+	tests := []struct {
+		name   string
+		doc    string
+		tokens []string
+		value  string
+		want   string
+	}{
+		{
+			name:  "root",
+			doc:   `{"foo": "bar"}`,
+			value: `[1]`,
+			want:  `[1]`,
+		},
+		{
+			name:   "object member",
+			doc:    `{"foo": [1, 2], "bar": true}`,
+			tokens: []string{"foo"},
+			value:  `[1]`,
+			want:   `{"foo": [1], "bar": true}`,
+		},
+		{
+			name:   "array element keeps its position",
+			doc:    `{"foo": [[1], [2], [3]]}`,
+			tokens: []string{"foo", "1"},
+			value:  `[2, 2]`,
+			want:   `{"foo": [[1], [2, 2], [3]]}`,
+		},
+		{
+			name:   "escaped member",
+			doc:    `{"a/b": [1], "m~n": [2]}`,
+			tokens: []string{"m~n"},
+			value:  `[]`,
+			want:   `{"a/b": [1], "m~n": []}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := replaceAt(decodeJSON(t, tt.doc), tt.tokens, decodeJSON(t, tt.value))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := decodeJSON(t, tt.want); !reflect.DeepEqual(got, want) {
+				t.Errorf("document = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+// The examples of RFC 7396 appendix A.
+func TestMergePatch(t *testing.T) {
+	tests := []struct {
+		doc   string
+		patch string
+		want  string
+	}{
+		{`{"a": "b"}`, `{"a": "c"}`, `{"a": "c"}`},
+		{`{"a": "b"}`, `{"b": "c"}`, `{"a": "b", "b": "c"}`},
+		{`{"a": "b"}`, `{"a": null}`, `{}`},
+		{`{"a": "b", "b": "c"}`, `{"a": null}`, `{"b": "c"}`},
+		{`{"a": ["b"]}`, `{"a": "c"}`, `{"a": "c"}`},
+		{`{"a": "c"}`, `{"a": ["b"]}`, `{"a": ["b"]}`},
+		{`{"a": {"b": "c"}}`, `{"a": {"b": "d", "c": null}}`, `{"a": {"b": "d"}}`},
+		{`{"a": [{"b": "c"}]}`, `{"a": [1]}`, `{"a": [1]}`},
+		{`["a", "b"]`, `["c", "d"]`, `["c", "d"]`},
+		{`{"a": "b"}`, `["c"]`, `["c"]`},
+		{`{"a": "foo"}`, `null`, `null`},
+		{`{"a": "foo"}`, `"bar"`, `"bar"`},
+		{`{"e": null}`, `{"a": 1}`, `{"e": null, "a": 1}`},
+		{`[1, 2]`, `{"a": "b", "c": null}`, `{"a": "b"}`},
+		{`{}`, `{"a": {"bb": {"ccc": null}}}`, `{"a": {"bb": {}}}`},
+	}
+
+	for _, tt := range tests {
+		got := mergePatch(decodeJSON(t, tt.doc), decodeJSON(t, tt.patch))
+		if want := decodeJSON(t, tt.want); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s merged with %s = %v, want %v", tt.doc, tt.patch, got, want)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/CatalinPlesu/user-service/model"
+)
+
+// userETag identifies a version of a user. Every write increments the
+// version, so it changes whenever the representation does.
+func userETag(u *model.User) string {
+	return fmt.Sprintf(`"%d"`, u.Version)
+}
+
+// viewETag identifies the representation of u served as view. The whole
+// user is tagged like any write, so the user can send it back in If-Match;
+// a public profile also depends on the caller and their contacts, so its
+// tag covers the profile itself.
+func viewETag(u *model.User, view any) (string, error) {
+	if view == any(u) {
+		return userETag(u), nil
+	}
+
+	data, err := json.Marshal(view)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return fmt.Sprintf(`"%d-%x"`, u.Version, sum[:8]), nil
+}
+
+// etagMatches reports whether header, an If-Match or If-None-Match value,
+// is "*" or lists etag. Weak comparison ignores the W/ prefix, as
+// If-None-Match requires; If-Match uses strong comparison.
+func etagMatches(header, etag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if weak {
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+		if candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// checkIfMatch writes a 412 response and returns false when the request's
+// If-Match header does not match the user's current version.
+func checkIfMatch(w http.ResponseWriter, r *http.Request, u *model.User) bool {
+	header := r.Header.Get("If-Match")
+	if header == "" || etagMatches(header, userETag(u), false) {
+		return true
+	}
+
+	writePreconditionFailed(w, userETag(u))
+	return false
+}
+
+func writePreconditionFailed(w http.ResponseWriter, currentETag string) {
+	if currentETag != "" {
+		w.Header().Set("ETag", currentETag)
+	}
+	writeProblem(w, http.StatusPreconditionFailed, "precondition_failed", "Precondition failed", "The user was modified since it was read.")
+}
+
+// writeConflict reports a write that lost a race with another one. Clients
+// that sent If-Match get 412 like any other stale precondition.
+func writeConflict(w http.ResponseWriter, r *http.Request, current int64) {
+	etag := fmt.Sprintf(`"%d"`, current)
+	if r.Header.Get("If-Match") != "" {
+		writePreconditionFailed(w, etag)
+		return
+	}
+
+	w.Header().Set("ETag", etag)
+	writeProblem(w, http.StatusConflict, "edit_conflict", "Edit conflict", "The user was modified concurrently; read it again and retry.")
+}
@@ -0,0 +1,39 @@
+package handler
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/CatalinPlesu/user-service/model"
+)
+
+func TestViewETag(t *testing.T) {
+	u := &model.User{UserID: uuid.New(), Username: "alice", Email: "alice@example.com", Version: 3}
+
+	full, err := viewETag(u, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if full != userETag(u) {
+		t.Errorf("full view ETag = %s, want %s so it can be sent in If-Match", full, userETag(u))
+	}
+
+	profile := u.PublicProfile()
+	withoutEmail, err := viewETag(u, &profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile.Email = u.Email
+	withEmail, err := viewETag(u, &profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if withoutEmail == full || withEmail == full {
+		t.Errorf("public profile shares the full view's ETag %s", full)
+	}
+	if withoutEmail == withEmail {
+		t.Errorf("profiles with and without the email share the ETag %s", withEmail)
+	}
+}
@@ -0,0 +1,191 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+
+	"github.com/CatalinPlesu/user-service/model"
+	"github.com/CatalinPlesu/user-service/repository/audit"
+	"github.com/CatalinPlesu/user-service/repository/block"
+	"github.com/CatalinPlesu/user-service/repository/contact"
+	"github.com/CatalinPlesu/user-service/repository/user"
+)
+
+// visibleTo reports whether the caller may see the part of u guarded by
+// visibility. Users always see themselves, and admins see everyone.
+func visibleTo(ctx context.Context, contacts *contact.PostgresRepo, u *model.User, visibility string) (bool, error) {
+	if admin, _ := ctx.Value(adminKey).(bool); admin {
+		return true, nil
+	}
+	callerID, ok := CallerID(ctx)
+	if ok && callerID == u.UserID {
+		return true, nil
+	}
+
+	switch visibility {
+	case model.VisibilityPublic:
+		return true, nil
+	case model.VisibilityContacts:
+		if !ok || contacts == nil {
+			return false, nil
+		}
+		return contacts.AreContacts(ctx, u.UserID, callerID)
+	default:
+		return false, nil
+	}
+}
+
+// profileFor returns the public profile of u as the caller may see it, or
+// nil when u's privacy settings hide it from them or u blocked them.
+func profileFor(ctx context.Context, contacts *contact.PostgresRepo, blocks *block.RedisRepo, u *model.User) (*model.PublicProfile, error) {
+	admin, _ := ctx.Value(adminKey).(bool)
+	if callerID, ok := CallerID(ctx); ok && !admin && callerID != u.UserID && blocks != nil {
+		blocked, err := blocks.IsBlocked(ctx, u.UserID, callerID)
+		if err != nil || blocked {
+			return nil, err
+		}
+	}
+
+	visible, err := visibleTo(ctx, contacts, u, u.Privacy.ProfileVisibility)
+	if err != nil || !visible {
+		return nil, err
+	}
+
+	profile := u.PublicProfile()
+	emailVisible, err := visibleTo(ctx, contacts, u, u.Privacy.EmailVisibility)
+	if err != nil {
+		return nil, err
+	}
+	if emailVisible {
+		profile.Email = u.Email
+	}
+	return &profile, nil
+}
+
+// viewUser is what every lookup responds with for u: the whole user for
+// the user themselves and admins, the public profile for anyone u's privacy
+// settings allow, and nil for everyone else.
+func (h *User) viewUser(ctx context.Context, u *model.User) (any, error) {
+	callerID, ok := CallerID(ctx)
+	admin, _ := ctx.Value(adminKey).(bool)
+	if admin || (ok && callerID == u.UserID) {
+		return u, nil
+	}
+
+	profile, err := profileFor(ctx, h.Contacts, h.Blocks, u)
+	if err != nil || profile == nil {
+		return nil, err
+	}
+	return profile, nil
+}
+
+// viewUsers applies viewUser to a list, leaving out the hidden users.
+func (h *User) viewUsers(ctx context.Context, users []model.User) ([]any, error) {
+	views := []any{}
+	for i := range users {
+		view, err := h.viewUser(ctx, &users[i])
+		if err != nil {
+			return nil, err
+		}
+		if view != nil {
+			views = append(views, view)
+		}
+	}
+	return views, nil
+}
+
+func (h *User) GetPrivacy(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	theUser, err := h.PgRepo.FindByID(r.Context(), userID)
+	if errors.Is(err, user.ErrNotExist) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		fmt.Println("failed to find user by id:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("ETag", userETag(theUser))
+	if err := json.NewEncoder(w).Encode(theUser.Privacy); err != nil {
+		fmt.Println("failed to marshal privacy settings:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// UpdatePrivacy replaces the user's privacy settings; every setting must
+// be given.
+func (h *User) UpdatePrivacy(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		ProfileVisibility *string `json:"profile_visibility"`
+		Searchable        *bool   `json:"searchable"`
+		EmailVisibility   *string `json:"email_visibility"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if body.ProfileVisibility == nil || body.Searchable == nil || body.EmailVisibility == nil {
+		writeProblem(w, http.StatusUnprocessableEntity, "field_required", "Field is required",
+			"profile_visibility, searchable and email_visibility are all required.")
+		return
+	}
+	if !model.ValidVisibility(*body.ProfileVisibility) || !model.ValidVisibility(*body.EmailVisibility) {
+		writeProblem(w, http.StatusUnprocessableEntity, "invalid_field", "Invalid field",
+			"Visibility must be public, contacts or private.")
+		return
+	}
+
+	theUser := h.loadForUpdate(w, r)
+	if theUser == nil {
+		return
+	}
+
+	before := theUser.Privacy
+	theUser.Privacy = model.PrivacySettings{
+		ProfileVisibility: *body.ProfileVisibility,
+		Searchable:        *body.Searchable,
+		EmailVisibility:   *body.EmailVisibility,
+	}
+
+	err := h.PgRepo.DB.RunInTx(r.Context(), nil, func(ctx context.Context, tx bun.Tx) error {
+		if err := h.PgRepo.WithTx(tx).Update(ctx, theUser); err != nil {
+			return err
+		}
+		return h.Audit.WithTx(tx).Append(ctx, newAuditEntry(r, model.AuditPrivacyUpdated, &theUser.UserID, audit.Diff(before, theUser.Privacy)))
+	})
+	var conflict *user.ConflictError
+	if errors.As(err, &conflict) {
+		writeConflict(w, r, conflict.Current)
+		return
+	} else if errors.Is(err, user.ErrNotExist) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		fmt.Println("failed to update privacy settings:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("ETag", userETag(theUser))
+	if err := json.NewEncoder(w).Encode(theUser.Privacy); err != nil {
+		fmt.Println("failed to marshal privacy settings:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
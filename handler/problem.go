@@ -0,0 +1,40 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// problem is an RFC 7807 problem details body. Code is a stable, machine
+// readable identifier clients can switch on.
+type problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Code   string `json:"code"`
+	Detail string `json:"detail,omitempty"`
+}
+
+func writeProblem(w http.ResponseWriter, status int, code, title, detail string) {
+	res, err := json.Marshal(problem{
+		Type:   "about:blank",
+		Title:  title,
+		Status: status,
+		Code:   code,
+		Detail: detail,
+	})
+	if err != nil {
+		fmt.Println("failed to marshal problem:", err)
+		w.WriteHeader(status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	w.Write(res)
+}
+
+func writeInvalidCredentials(w http.ResponseWriter) {
+	writeProblem(w, http.StatusUnauthorized, "invalid_credentials", "Invalid credentials", "")
+}
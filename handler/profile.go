@@ -0,0 +1,121 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+
+	"github.com/CatalinPlesu/user-service/repository/user"
+)
+
+const (
+	maxBioLength           = 500
+	maxPronounsLength      = 40
+	maxStatusMessageLength = 100
+	maxAvatarLength        = 2048
+)
+
+// languageTag matches the well-formed language tags of BCP 47 (RFC 5646),
+// leaving out the grandfathered ones.
+var languageTag = regexp.MustCompile(`^(?i)` +
+	`(?:[a-z]{2,3}(?:-[a-z]{3}){0,3}|[a-z]{4,8})` + // language
+	`(?:-[a-z]{4})?` + // script
+	`(?:-(?:[a-z]{2}|[0-9]{3}))?` + // region
+	`(?:-(?:[a-z0-9]{5,8}|[0-9][a-z0-9]{3}))*` + // variants
+	`(?:-[0-9a-wyz](?:-[a-z0-9]{2,8})+)*` + // extensions
+	`(?:-x(?:-[a-z0-9]{1,8})+)?$`) // private use
+
+// validateUsername keeps usernames apart from emails, which logins tell
+// apart by the '@'.
+func validateUsername(value string) error {
+	if strings.Contains(value, "@") {
+		return errors.New("must not contain @")
+	}
+	return nil
+}
+
+func validateLocale(value string) error {
+	if !languageTag.MatchString(value) {
+		return errors.New("must be a BCP 47 language tag such as en-US")
+	}
+	return nil
+}
+
+func validateTimezone(value string) error {
+	if value == "Local" {
+		return errors.New("must be an IANA time zone such as Europe/Chisinau")
+	}
+	if _, err := time.LoadLocation(value); err != nil {
+		return errors.New("must be an IANA time zone such as Europe/Chisinau")
+	}
+	return nil
+}
+
+func validateAvatar(value string) error {
+	u, err := url.Parse(value)
+	if err != nil || len(value) > maxAvatarLength || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return errors.New("must be an http or https URL")
+	}
+	return nil
+}
+
+// maxLength limits a field to n characters. Single-line fields also reject
+// line breaks and other control characters.
+func maxLength(n int, multiline bool) func(value string) error {
+	return func(value string) error {
+		if utf8.RuneCountInString(value) > n {
+			return fmt.Errorf("must be at most %d characters", n)
+		}
+		if !multiline && strings.IndexFunc(value, unicode.IsControl) >= 0 {
+			return errors.New("must be a single line")
+		}
+		return nil
+	}
+}
+
+// GetProfile responds with the public profile of a user, as far as their
+// privacy settings let the caller see it.
+func (h *User) GetProfile(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	u, err := h.PgRepo.FindByID(r.Context(), userID)
+	if errors.Is(err, user.ErrNotExist) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		fmt.Println("failed to find user by id:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	profile, err := profileFor(r.Context(), h.Contacts, h.Blocks, u)
+	if err != nil {
+		fmt.Println("failed to apply privacy settings:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if profile == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(profile); err != nil {
+		fmt.Println("failed to marshal profile:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,204 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+
+	"github.com/CatalinPlesu/user-service/messaging"
+	"github.com/CatalinPlesu/user-service/repository/block"
+	"github.com/CatalinPlesu/user-service/repository/contact"
+	"github.com/CatalinPlesu/user-service/repository/eventstream"
+	"github.com/CatalinPlesu/user-service/repository/user"
+)
+
+const (
+	streamReplayBatch = 500
+	streamHeartbeat   = 15 * time.Second
+
+	streamVisibilityTTL = 10 * time.Second
+)
+
+// Stream serves user events as Server-Sent Events. Each event's ID is its
+// Redis stream ID, so a reconnecting client's Last-Event-ID resumes where it
+// left off as long as the events are still buffered. Events about other
+// users are only sent to callers their privacy settings allow.
+type Stream struct {
+	Repo     *eventstream.RedisRepo
+	Hub      *eventstream.Hub
+	Users    *user.PostgresRepo
+	Contacts *contact.PostgresRepo
+	Blocks   *block.RedisRepo
+}
+
+// All streams the events of every user.
+func (h *Stream) All(w http.ResponseWriter, r *http.Request) {
+	h.serve(w, r, "")
+}
+
+// ByUser streams the events of the user named by the {id} URL parameter,
+// which must be the caller.
+func (h *Stream) ByUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	h.serve(w, r, userID.String())
+}
+
+// visibility is what the caller may see of one user's events, remembered
+// per connection until expires so a busy stream does not look the user up
+// for every event.
+type visibility struct {
+	profile bool
+	email   bool
+	expires time.Time
+}
+
+// visibleTo reports whether the caller may receive entry. The events carry
+// public profile fields, so they go to whoever may see the profile, which
+// leaves out users the subject blocked; events carrying the email also need
+// the email to be visible. Decisions are cached in seen for
+// streamVisibilityTTL, so changes to privacy settings, contacts and blocks
+// apply to open streams after at most that long.
+func (h *Stream) visibleTo(ctx context.Context, entry eventstream.Entry, seen map[uuid.UUID]visibility) (bool, error) {
+	callerID, _ := CallerID(ctx)
+	if admin, _ := ctx.Value(adminKey).(bool); admin || entry.UserID == callerID.String() {
+		return true, nil
+	}
+
+	userID, err := uuid.Parse(entry.UserID)
+	if err != nil {
+		return false, nil
+	}
+
+	now := time.Now()
+	v, ok := seen[userID]
+	if !ok || now.After(v.expires) {
+		v, err = h.visibility(ctx, userID)
+		if err != nil {
+			return false, err
+		}
+		v.expires = now.Add(streamVisibilityTTL)
+		seen[userID] = v
+	}
+
+	if entry.Type == messaging.EventUserEmailChanged {
+		return v.email, nil
+	}
+	return v.profile, nil
+}
+
+func (h *Stream) visibility(ctx context.Context, userID uuid.UUID) (visibility, error) {
+	// Deletion events are about users that are already soft-deleted.
+	u, err := h.Users.FindByIDWithDeleted(ctx, userID)
+	if errors.Is(err, user.ErrNotExist) {
+		return visibility{}, nil
+	} else if err != nil {
+		return visibility{}, err
+	}
+
+	profile, err := profileFor(ctx, h.Contacts, h.Blocks, u)
+	if err != nil || profile == nil {
+		return visibility{}, err
+	}
+	return visibility{profile: true, email: profile.Email != ""}, nil
+}
+
+func (h *Stream) serve(w http.ResponseWriter, r *http.Request, userID string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		fmt.Println("failed to stream events: response writer cannot flush")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	last := r.Header.Get("Last-Event-ID")
+	if last != "" && !eventstream.ValidID(last) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Subscribe before replaying so nothing published in between is missed;
+	// entries already replayed are skipped below.
+	live, unsubscribe := h.Hub.Subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	seen := map[uuid.UUID]visibility{}
+	send := func(entry eventstream.Entry) {
+		last = entry.ID
+		if userID != "" && entry.UserID != userID {
+			return
+		}
+		visible, err := h.visibleTo(r.Context(), entry, seen)
+		if err != nil {
+			fmt.Println("failed to check event visibility:", err)
+			return
+		}
+		if !visible {
+			return
+		}
+		fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", entry.ID, entry.Type, entry.Data)
+	}
+
+	if last != "" {
+		for {
+			entries, err := h.Repo.After(r.Context(), last, streamReplayBatch)
+			if errors.Is(err, eventstream.ErrTrimmed) {
+				// The client missed events that are gone; tell it to
+				// refetch what it shows before continuing.
+				fmt.Fprint(w, "event: reset\ndata: {}\n\n")
+			} else if err != nil {
+				fmt.Println("failed to replay events:", err)
+				return
+			}
+
+			for _, entry := range entries {
+				send(entry)
+			}
+			if len(entries) < streamReplayBatch {
+				break
+			}
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case entry, ok := <-live:
+			if !ok {
+				return
+			}
+			if last != "" && !eventstream.Less(last, entry.ID) {
+				continue
+			}
+			send(entry)
+		case now := <-heartbeat.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			for id, v := range seen {
+				if now.After(v.expires) {
+					delete(seen, id)
+				}
+			}
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,41 @@
+package handler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/CatalinPlesu/user-service/messaging"
+	"github.com/CatalinPlesu/user-service/repository/eventstream"
+)
+
+// The stream has no repositories, so any lookup would panic: every decision
+// below must come from the per-connection cache.
+func TestStreamVisibleToUsesCache(t *testing.T) {
+	subject := uuid.New()
+	seen := map[uuid.UUID]visibility{
+		subject: {profile: true, email: false, expires: time.Now().Add(time.Minute)},
+	}
+	h := &Stream{}
+
+	tests := []struct {
+		event string
+		want  bool
+	}{
+		{messaging.EventUserProfileUpdated, true},
+		{messaging.EventUserEmailChanged, false},
+		{messaging.EventUserDeleted, true},
+	}
+	for _, tt := range tests {
+		entry := eventstream.Entry{UserID: subject.String(), Type: tt.event}
+		got, err := h.visibleTo(context.Background(), entry, seen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("%s visible = %v, want %v", tt.event, got, tt.want)
+		}
+	}
+}
@@ -1,28 +1,62 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
+	"github.com/uptrace/bun"
 
 	"github.com/CatalinPlesu/user-service/messaging"
 	"github.com/CatalinPlesu/user-service/model"
+	"github.com/CatalinPlesu/user-service/repository/audit"
+	"github.com/CatalinPlesu/user-service/repository/block"
+	"github.com/CatalinPlesu/user-service/repository/breached"
+	"github.com/CatalinPlesu/user-service/repository/contact"
 	"github.com/CatalinPlesu/user-service/repository/jwts"
+	"github.com/CatalinPlesu/user-service/repository/lockout"
+	"github.com/CatalinPlesu/user-service/repository/mfa"
+	"github.com/CatalinPlesu/user-service/repository/storage"
 	"github.com/CatalinPlesu/user-service/repository/user"
 )
 
 type User struct {
 	RdRepo   *jwts.RedisRepo
 	PgRepo   *user.PostgresRepo
-	RabbitMQ *messaging.RabbitMQ
+	MfaRepo  *mfa.PostgresRepo
+	TOTP     *mfa.TOTP
+	Lockout  *lockout.RedisRepo
+	Breached breached.Screener
+	Outbox   messaging.Enqueuer
+	Audit    *audit.PostgresRepo
+	Contacts *contact.PostgresRepo
+	Blocks   *block.RedisRepo
+
+	// Deleted accounts can be restored for DeletionGracePeriod. With
+	// ReleaseUsernames their username is freed as soon as they are deleted.
+	DeletionGracePeriod time.Duration
+	ReleaseUsernames    bool
+
+	// BreachedFailClosed rejects new passwords while Breached cannot be
+	// checked.
+	BreachedFailClosed bool
+
+	// Uploaded avatars are kept in Avatars and linked below AvatarBaseURL.
+	Avatars            storage.BlobStore
+	AvatarBaseURL      string
+	AvatarMaxBytes     int64
+	AvatarMaxDimension int
 }
 
+
+
 func (h *User) Register(w http.ResponseWriter, r *http.Request) {
 	var body struct {
 		Username    string `json:"username"`
@@ -36,39 +70,71 @@ func (h *User) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := validateUsername(body.Username); err != nil {
+		writeProblem(w, http.StatusUnprocessableEntity, "invalid_field", "Invalid field",
+			fmt.Sprintf("The field %q %s.", "username", err))
+		return
+	}
+
+	if !h.acceptablePassword(w, body.Password) {
+		return
+	}
+
+	passwordHash, err := user.HashPassword(body.Password)
+	if err != nil {
+		fmt.Println("failed to hash password:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
 	now := time.Now().UTC()
 	user := model.User{
 		UserID:      uuid.New(),
 		Username:    body.Username,
 		DisplayName: body.DisplayName,
 		Email:       body.Email,
-		Password:    body.Password,
+		Password:    passwordHash,
 		CreatedAt:   &now,
 		UpdatedAt:   &now,
+		Privacy:     model.DefaultPrivacySettings(),
+		Version:     1,
 	}
 
-	err := h.PgRepo.Insert(r.Context(), user)
+	userID := user.UserID
+	session, err := jwts.GenerateSession(userID)
 	if err != nil {
-		fmt.Println("failed to insert user:", err)
+		fmt.Println("failed to generate jwt:", jwts.Redact(err.Error()))
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	userID := user.UserID
-	jwt, err := jwts.GenerateJWT(userID)
-	if err != nil {
-		fmt.Println("failed to generate jwt:", err)
-		return
-	}
-	err = h.RdRepo.Insert(r.Context(), user.UserID, jwt)
+	err = h.PgRepo.DB.RunInTx(r.Context(), nil, func(ctx context.Context, tx bun.Tx) error {
+		if err := h.PgRepo.WithTx(tx).Insert(ctx, user); err != nil {
+			return err
+		}
+
+		events := h.Outbox.WithTx(tx)
+		if err := events.EnqueueLoginRegister(ctx, userID, session); err != nil {
+			return err
+		}
+		return events.Enqueue(ctx, messaging.UserRegistered{
+			UserID:       userID,
+			Username:     user.Username,
+			DisplayName:  user.DisplayName,
+			Email:        user.Email,
+			RegisteredAt: now,
+		})
+	})
 	if err != nil {
-		fmt.Println("failed to insert user jwt:", err)
+		fmt.Println("failed to insert user:", err)
+		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	err = h.RabbitMQ.PublishLoginRegisterMessage("user_id_jwt", userID, jwt)
+	err = h.RdRepo.Insert(r.Context(), user.UserID, session.Token)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to publish to RabbitMQ: %v", err), http.StatusInternalServerError)
+		fmt.Println("failed to insert user jwt:", jwts.Redact(err.Error()))
+		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
@@ -77,7 +143,7 @@ func (h *User) Register(w http.ResponseWriter, r *http.Request) {
 		UserJWT string     `json:"jwt"`
 	}{
 		User:    user,
-		UserJWT: jwt,
+		UserJWT: session.Token,
 	}
 
 	res, err := json.Marshal(response)
@@ -93,8 +159,10 @@ func (h *User) Register(w http.ResponseWriter, r *http.Request) {
 
 func (h *User) Login(w http.ResponseWriter, r *http.Request) {
 	var body struct {
-		Username string `json:"username"`
-		Password string `json:"password"`
+		Identifier string `json:"identifier"`
+		Username   string `json:"username"`
+		Email      string `json:"email"`
+		Password   string `json:"password"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
@@ -102,51 +170,314 @@ func (h *User) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	u, err := h.PgRepo.FindByUsername(r.Context(), body.Username)
-	if errors.Is(err, user.ErrNotExist) {
-		w.WriteHeader(http.StatusNotFound)
+	// identifier may hold either a username or an email; the dedicated
+	// fields are still accepted from older clients.
+	identifier := body.Identifier
+	if identifier == "" {
+		identifier = body.Username
+	}
+	if identifier == "" {
+		identifier = body.Email
+	}
+	identifier = strings.TrimSpace(identifier)
+
+	u, err := h.PgRepo.FindByLogin(r.Context(), identifier)
+	if err != nil && !errors.Is(err, user.ErrNotExist) {
+		fmt.Println("failed to find user by login:", err)
+		w.WriteHeader(http.StatusInternalServerError)
 		return
-	} else if err != nil {
-		fmt.Println("failed to find user by username:", err)
+	}
+
+	ip := clientIP(r)
+	account := loginAccount(u, identifier)
+	wait, err := h.Lockout.Check(r.Context(), account, ip)
+	if err != nil {
+		fmt.Println("failed to check login lockout:", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	if wait > 0 {
+		writeTooManyAttempts(w, wait)
+		return
+	}
 
-	if body.Password == u.Password {
-		fmt.Println("Succes login")
+	// Unknown users still go through a hash verification and are counted as
+	// a failure exactly like a wrong password, so neither the response nor
+	// its timing reveals whether the account exists.
+	var match, needsRehash bool
+	if u != nil {
+		match, needsRehash = user.VerifyPassword(u.Password, body.Password)
 	} else {
-		fmt.Println("fail login")
-		w.WriteHeader(http.StatusNotFound)
+		match = user.VerifyUnknownUser(body.Password)
+	}
+
+	if !match {
+		var target *uuid.UUID
+		if u != nil {
+			target = &u.UserID
+		}
+		entry := newAuditEntry(r, model.AuditLoginFailed, target, nil)
+		entry.Details.Method = "password"
+		recordAudit(r, h.Audit, entry)
+
+		wait, err := h.Lockout.RecordFailure(r.Context(), account, ip)
+		if err != nil {
+			fmt.Println("failed to record login failure:", err)
+		}
+		if wait > 0 {
+			setRetryAfter(w, wait)
+		}
+		writeInvalidCredentials(w)
 		return
 	}
 
-	userID := u.UserID
-	jwt, err := jwts.GenerateJWT(userID)
+	if err := h.Lockout.Reset(r.Context(), account); err != nil {
+		fmt.Println("failed to reset login failures:", err)
+	}
+
+	if needsRehash {
+		h.rehashPassword(r, u, body.Password)
+	}
+
+	enrollment, err := h.MfaRepo.FindByUserID(r.Context(), u.UserID)
+	if err != nil && !errors.Is(err, mfa.ErrNotExist) {
+		fmt.Println("failed to find mfa enrollment:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if enrollment != nil && enrollment.Confirmed {
+		challenge, err := jwts.GenerateMFAChallenge(u.UserID)
+		if err != nil {
+			fmt.Println("failed to generate mfa challenge:", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		response := struct {
+			MFARequired bool   `json:"mfa_required"`
+			MFAToken    string `json:"mfa_token"`
+		}{
+			MFARequired: true,
+			MFAToken:    challenge,
+		}
+
+		res, err := json.Marshal(response)
+		if err != nil {
+			fmt.Println("failed to marshal response:", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		w.Write(res)
+		return
+	}
+
+	issueSession(w, r, h.PgRepo.DB, h.RdRepo, h.Outbox, h.Audit, u, "password")
+}
+
+// LoginMFA completes a login started by Login for a user with MFA enabled,
+// accepting either a TOTP code or an unused recovery code.
+func (h *User) LoginMFA(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		MFAToken     string `json:"mfa_token"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	claims, err := jwts.ValidateMFAChallenge(body.MFAToken)
 	if err != nil {
-		fmt.Println("failed to generate jwt:", err)
+		writeInvalidCredentials(w)
 		return
 	}
 
-	err = h.RdRepo.Insert(r.Context(), u.UserID, jwt)
+	// Second factor attempts are throttled separately from passwords so a
+	// valid challenge token cannot be used to brute-force the code space.
+	mfaAccount := "mfa:" + claims.UserID.String()
+	ip := clientIP(r)
+	wait, err := h.Lockout.Check(r.Context(), mfaAccount, ip)
 	if err != nil {
-		fmt.Println("failed to insert user jwt:", err)
+		fmt.Println("failed to check login lockout:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if wait > 0 {
+		writeTooManyAttempts(w, wait)
+		return
+	}
+
+	enrollment, err := h.MfaRepo.FindByUserID(r.Context(), claims.UserID)
+	if errors.Is(err, mfa.ErrNotExist) {
+		writeInvalidCredentials(w)
+		return
+	} else if err != nil {
+		fmt.Println("failed to find mfa enrollment:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	var accepted bool
+	switch {
+	case body.Code != "":
+		step, ok := h.TOTP.Validate(enrollment.Secret, body.Code, enrollment.LastUsedStep)
+		if ok {
+			accepted, err = h.MfaRepo.MarkStepUsed(r.Context(), claims.UserID, step)
+		}
+	case body.RecoveryCode != "":
+		accepted, err = h.MfaRepo.UseRecoveryCode(r.Context(), claims.UserID, mfa.HashRecoveryCode(body.RecoveryCode))
+	default:
+		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	
-	err = h.RabbitMQ.PublishLoginRegisterMessage("user_id_jwt", userID, jwt)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to publish to RabbitMQ: %v", err), http.StatusInternalServerError)
+		fmt.Println("failed to verify mfa code:", err)
+		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	
+	if !enrollment.Confirmed || !accepted {
+		entry := newAuditEntry(r, model.AuditLoginFailed, &claims.UserID, nil)
+		entry.Details.Method = "mfa"
+		recordAudit(r, h.Audit, entry)
+
+		wait, err := h.Lockout.RecordFailure(r.Context(), mfaAccount, ip)
+		if err != nil {
+			fmt.Println("failed to record login failure:", err)
+		}
+		if wait > 0 {
+			setRetryAfter(w, wait)
+		}
+		writeInvalidCredentials(w)
+		return
+	}
+
+	if err := h.Lockout.Reset(r.Context(), mfaAccount); err != nil {
+		fmt.Println("failed to reset login failures:", err)
+	}
+
+	u, err := h.PgRepo.FindByID(r.Context(), claims.UserID)
+	if err != nil {
+		fmt.Println("failed to find user by id:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	issueSession(w, r, h.PgRepo.DB, h.RdRepo, h.Outbox, h.Audit, u, "mfa")
+}
+
+// acceptablePassword applies the password policy, writing a problem response
+// and returning false when the password is rejected. Screening fails open
+// unless BreachedFailClosed is set: an unreadable corpus is logged, and
+// counted in the health check, rather than blocking every password change.
+func (h *User) acceptablePassword(w http.ResponseWriter, password string) bool {
+	if h.Breached == nil {
+		return true
+	}
+
+	found, err := h.Breached.Contains(password)
+	if err != nil {
+		fmt.Println("failed to screen password:", err)
+		if h.BreachedFailClosed {
+			writeProblem(w, http.StatusServiceUnavailable, "password_screening_unavailable", "Password screening unavailable",
+				"The password could not be checked against known breaches; try again later.")
+			return false
+		}
+		return true
+	}
+
+	if found {
+		writeProblem(w, http.StatusUnprocessableEntity, "password_breached", "Password found in breach corpus",
+			"This password has appeared in a data breach and cannot be used.")
+		return false
+	}
+	return true
+}
+
+// rehashPassword upgrades a legacy plaintext or low-cost password after the
+// user proved they know it. Failure only delays the upgrade to a later login.
+func (h *User) rehashPassword(r *http.Request, u *model.User, password string) {
+	passwordHash, err := user.HashPassword(password)
+	if err != nil {
+		fmt.Println("failed to hash password:", err)
+		return
+	}
+
+	if err := h.PgRepo.UpgradePasswordHash(r.Context(), u.UserID, u.Password, passwordHash); err != nil {
+		fmt.Println("failed to upgrade password hash:", err)
+		return
+	}
+	u.Password = passwordHash
+}
+
+func issueSession(w http.ResponseWriter, r *http.Request, db bun.IDB, rdRepo *jwts.RedisRepo, events messaging.Enqueuer, auditLog *audit.PostgresRepo, u *model.User, method string) {
+	if u.DeactivatedAt != nil {
+		writeProblem(w, http.StatusForbidden, "account_deactivated", "Account deactivated", "")
+		return
+	}
+
+	userID := u.UserID
+	session, err := jwts.GenerateSession(userID)
+	if err != nil {
+		fmt.Println("failed to generate jwt:", jwts.Redact(err.Error()))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	entry := newAuditEntry(r, model.AuditLogin, &userID, nil)
+	entry.Details.Method = method
+
+	// Redis cannot take part in the transaction, so the session is stored
+	// last and removed again if the commit fails.
+	var stored bool
+	err = db.RunInTx(r.Context(), nil, func(ctx context.Context, tx bun.Tx) error {
+		outbox := events.WithTx(tx)
+		if err := outbox.EnqueueLoginRegister(ctx, userID, session); err != nil {
+			return err
+		}
+		err := outbox.Enqueue(ctx, messaging.UserLoggedIn{
+			UserID:     userID,
+			Method:     method,
+			SessionID:  session.ID,
+			ExpiresAt:  session.ExpiresAt,
+			LoggedInAt: session.IssuedAt,
+		})
+		if err != nil {
+			return err
+		}
+		if err := auditLog.WithTx(tx).Append(ctx, entry); err != nil {
+			return err
+		}
+
+		if err := rdRepo.Insert(ctx, userID, session.Token); err != nil {
+			return err
+		}
+		stored = true
+		return nil
+	})
+	if err != nil {
+		if stored {
+			if err := rdRepo.Remove(r.Context(), userID, session.Token); err != nil {
+				fmt.Println("failed to remove user jwt:", jwts.Redact(err.Error()))
+			}
+		}
+		fmt.Println("failed to issue session:", jwts.Redact(err.Error()))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
 	response := struct {
 		User    model.User `json:"user"`
 		UserJWT string     `json:"jwt"`
 	}{
 		User:    *u,
-		UserJWT: jwt,
+		UserJWT: session.Token,
 	}
-	
+
 	res, err := json.Marshal(response)
 	if err != nil {
 		fmt.Println("failed to marshal response:", err)
@@ -182,7 +513,7 @@ func (h *User) Auth(w http.ResponseWriter, r *http.Request) {
 	userID := u.UserID
 	claims, err := jwts.ValidateJWT(body.JWT)
 	if err != nil {
-		fmt.Println("bad jwt jwt:", err)
+		fmt.Println("bad jwt jwt:", jwts.Redact(err.Error()))
 		return
 	}
 
@@ -234,11 +565,18 @@ func (h *User) List(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	items, err := h.viewUsers(r.Context(), res.Users)
+	if err != nil {
+		fmt.Println("failed to apply privacy settings:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
 	var response struct {
-		Items []model.User `json:"items"`
-		Next  uint64       `json:"next,omitempty"`
+		Items []any  `json:"items"`
+		Next  uint64 `json:"next,omitempty"`
 	}
-	response.Items = res.Users
+	response.Items = items
 	response.Next = res.Cursor
 
 	data, err := json.Marshal(response)
@@ -270,7 +608,34 @@ func (h *User) GetByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := json.NewEncoder(w).Encode(u); err != nil {
+	view, err := h.viewUser(r.Context(), u)
+	if err != nil {
+		fmt.Println("failed to apply privacy settings:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if view == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	etag, err := viewETag(u, view)
+	if err != nil {
+		fmt.Println("failed to marshal user:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// The view depends on who is asking.
+	w.Header().Set("Vary", "Authorization")
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Accept-Patch", acceptPatch)
+	if header := r.Header.Get("If-None-Match"); header != "" && etagMatches(header, etag, true) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(view); err != nil {
 		fmt.Println("failed to marshal user:", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
@@ -280,14 +645,22 @@ func (h *User) GetByID(w http.ResponseWriter, r *http.Request) {
 func (h *User) GetByDisplayName(w http.ResponseWriter, r *http.Request) {
 	displayNameParam := chi.URLParam(r, "displayname")
 
-	res, err := h.PgRepo.FindByDisplayName(r.Context(), displayNameParam)
+	callerID, _ := CallerID(r.Context())
+	res, err := h.PgRepo.FindByDisplayName(r.Context(), displayNameParam, callerID)
 	if err != nil {
 		fmt.Println("failed to find all users:", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	data, err := json.Marshal(res)
+	views, err := h.viewUsers(r.Context(), res)
+	if err != nil {
+		fmt.Println("failed to apply privacy settings:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	data, err := json.Marshal(views)
 	if err != nil {
 		fmt.Println("failed to marshal users:", err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -310,26 +683,48 @@ func (h *User) GetByUsername(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := json.NewEncoder(w).Encode(u); err != nil {
+	view, err := h.viewUser(r.Context(), u)
+	if err != nil {
+		fmt.Println("failed to apply privacy settings:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if view == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(view); err != nil {
 		fmt.Println("failed to marshal user:", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 }
 
+// UpdateByID replaces the user's mutable fields with the body. Optional
+// fields left out are cleared.
 func (h *User) UpdateByID(w http.ResponseWriter, r *http.Request) {
-	var body struct {
-		Username    *string `json:"username,omitempty"`
-		DisplayName *string `json:"display_name,omitempty"`
-		Email       *string `json:"email,omitempty"`
-		Password    *string `json:"password,omitempty"`
+	var body map[string]any
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body == nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+	for name := range body {
+		if readOnlyUserFields[name] {
+			delete(body, name)
+		}
+	}
+
+	theUser := h.loadForUpdate(w, r)
+	if theUser == nil {
 		return
 	}
 
+	h.saveUpdate(w, r, theUser, body)
+}
+
+func (h *User) DeleteByID(w http.ResponseWriter, r *http.Request) {
 	idParam := chi.URLParam(r, "id")
 
 	userID, err := uuid.Parse(idParam) // Parse as UUID
@@ -338,61 +733,142 @@ func (h *User) UpdateByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	theUser, err := h.PgRepo.FindByID(r.Context(), userID)
-	if errors.Is(err, user.ErrNotExist) {
+	// With If-Match the deletion only goes ahead if the user is still at the
+	// version the client saw.
+	var version int64
+	if r.Header.Get("If-Match") != "" {
+		current, err := h.PgRepo.FindByID(r.Context(), userID)
+		if errors.Is(err, user.ErrNotExist) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		} else if err != nil {
+			fmt.Println("failed to find user by id:", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		if !checkIfMatch(w, r, current) {
+			return
+		}
+		version = current.Version
+	}
+
+	now := time.Now().UTC()
+	err = h.PgRepo.DB.RunInTx(r.Context(), nil, func(ctx context.Context, tx bun.Tx) error {
+		if err := h.PgRepo.WithTx(tx).DeleteByID(ctx, userID, h.ReleaseUsernames, version); err != nil {
+			return err
+		}
+		if err := h.Audit.WithTx(tx).Append(ctx, newAuditEntry(r, model.AuditUserDeleted, &userID, nil)); err != nil {
+			return err
+		}
+		return h.Outbox.WithTx(tx).Enqueue(ctx, messaging.UserDeleted{
+			UserID:    userID,
+			DeletedAt: now,
+		}, messaging.UserSessionsRevoked{
+			UserID:    userID,
+			Reason:    "deleted",
+			RevokedAt: now,
+		})
+	})
+	var conflict *user.ConflictError
+	if errors.As(err, &conflict) {
+		writeConflict(w, r, conflict.Current)
+		return
+	} else if errors.Is(err, user.ErrNotExist) {
 		w.WriteHeader(http.StatusNotFound)
 		return
 	} else if err != nil {
-		fmt.Println("failed to find user by id:", err)
+		fmt.Println("failed to delete user by id:", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	now := time.Now().UTC()
-	if body.Username != nil {
-		theUser.Username = *body.Username
-	}
-	if body.DisplayName != nil {
-		theUser.DisplayName = *body.DisplayName
-	}
-	if body.Email != nil {
-		theUser.Email = *body.Email
-	}
-	if body.Password != nil {
-		theUser.Password = *body.Password
+	err = h.RdRepo.DeleteAll(r.Context(), userID)
+	if err != nil {
+		fmt.Println("failed to revoke sessions:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
 	}
-	theUser.UpdatedAt = &now
+}
 
-	err = h.PgRepo.Update(r.Context(), theUser)
+// Restore brings back a deleted account within the grace period. Its
+// sessions stay revoked, so the user has to log in again.
+func (h *User) Restore(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(chi.URLParam(r, "id"))
 	if err != nil {
-		fmt.Println("failed to update user:", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now().UTC()
+	var restored *model.User
+	err = h.PgRepo.DB.RunInTx(r.Context(), nil, func(ctx context.Context, tx bun.Tx) error {
+		restored, err = h.PgRepo.WithTx(tx).Restore(ctx, userID, now.Add(-h.DeletionGracePeriod))
+		if err != nil {
+			return err
+		}
+		if err := h.Audit.WithTx(tx).Append(ctx, newAuditEntry(r, model.AuditUserRestored, &userID, nil)); err != nil {
+			return err
+		}
+		return h.Outbox.WithTx(tx).Enqueue(ctx, messaging.UserRestored{
+			UserID:     userID,
+			Username:   restored.Username,
+			RestoredAt: now,
+		})
+	})
+	if errors.Is(err, user.ErrNotExist) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if errors.Is(err, user.ErrRestoreExpired) {
+		writeProblem(w, http.StatusGone, "restore_expired", "Account can no longer be restored", "")
+		return
+	} else if errors.Is(err, user.ErrUsernameTaken) {
+		writeProblem(w, http.StatusConflict, "username_taken", "Username is taken", "The account's username was claimed after it was deleted.")
+		return
+	} else if err != nil {
+		fmt.Println("failed to restore user:", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	if err := json.NewEncoder(w).Encode(theUser); err != nil {
+	res, err := json.Marshal(restored)
+	if err != nil {
 		fmt.Println("failed to marshal user:", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-}
 
-func (h *User) DeleteByID(w http.ResponseWriter, r *http.Request) {
-	idParam := chi.URLParam(r, "id")
+	w.Write(res)
+}
 
-	userID, err := uuid.Parse(idParam) // Parse as UUID
+// RevokeSessions signs the user out everywhere by dropping all of their
+// JWTs.
+func (h *User) RevokeSessions(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(chi.URLParam(r, "id"))
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
-	err = h.PgRepo.DeleteByID(r.Context(), userID)
-	if errors.Is(err, user.ErrNotExist) {
-		w.WriteHeader(http.StatusNotFound)
+	err = h.RdRepo.DeleteAll(r.Context(), userID)
+	if err != nil {
+		fmt.Println("failed to revoke sessions:", err)
+		w.WriteHeader(http.StatusInternalServerError)
 		return
-	} else if err != nil {
-		fmt.Println("failed to delete user by id:", err)
+	}
+
+	err = h.Outbox.Enqueue(r.Context(), messaging.UserSessionsRevoked{
+		UserID:    userID,
+		Reason:    "user_request",
+		RevokedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		fmt.Println("failed to insert outbox message:", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+
+	recordAudit(r, h.Audit, newAuditEntry(r, model.AuditSessionsRevoked, &userID, nil))
+
+	w.WriteHeader(http.StatusNoContent)
 }
@@ -0,0 +1,326 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+
+	"github.com/CatalinPlesu/user-service/messaging"
+	"github.com/CatalinPlesu/user-service/model"
+	"github.com/CatalinPlesu/user-service/repository/audit"
+	"github.com/CatalinPlesu/user-service/repository/user"
+)
+
+const (
+	mergePatchType = "application/merge-patch+json"
+	jsonPatchType  = "application/json-patch+json"
+)
+
+// acceptPatch lists the patch formats PATCH /users/{id} understands.
+var acceptPatch = mergePatchType + ", " + jsonPatchType
+
+// userField is a user field clients may write. The password is not one of
+// them; it has an endpoint of its own that asks for the current one.
+type userField struct {
+	Name     string
+	Required bool
+	Get      func(u *model.User) string
+	Set      func(u *model.User, value string)
+	// Validate, when set, checks non-empty values.
+	Validate func(value string) error
+}
+
+// mutableUserFields is the whitelist of fields PUT and PATCH may change.
+var mutableUserFields = []userField{
+	{
+		Name:     "username",
+		Required: true,
+		Get:      func(u *model.User) string { return u.Username },
+		Set:      func(u *model.User, value string) { u.Username = value },
+		Validate: validateUsername,
+	},
+	{
+		Name: "display_name",
+		Get:  func(u *model.User) string { return u.DisplayName },
+		Set:  func(u *model.User, value string) { u.DisplayName = value },
+	},
+	{
+		Name:     "email",
+		Required: true,
+		Get:      func(u *model.User) string { return u.Email },
+		Set:      func(u *model.User, value string) { u.Email = value },
+	},
+	{
+		Name:     "bio",
+		Get:      func(u *model.User) string { return u.Bio },
+		Set:      func(u *model.User, value string) { u.Bio = value },
+		Validate: maxLength(maxBioLength, true),
+	},
+	{
+		Name:     "avatar",
+		Get:      func(u *model.User) string { return u.Avatar },
+		Set:      func(u *model.User, value string) { u.Avatar = value },
+		Validate: validateAvatar,
+	},
+	{
+		Name:     "locale",
+		Get:      func(u *model.User) string { return u.Locale },
+		Set:      func(u *model.User, value string) { u.Locale = value },
+		Validate: validateLocale,
+	},
+	{
+		Name:     "timezone",
+		Get:      func(u *model.User) string { return u.Timezone },
+		Set:      func(u *model.User, value string) { u.Timezone = value },
+		Validate: validateTimezone,
+	},
+	{
+		Name:     "pronouns",
+		Get:      func(u *model.User) string { return u.Pronouns },
+		Set:      func(u *model.User, value string) { u.Pronouns = value },
+		Validate: maxLength(maxPronounsLength, false),
+	},
+	{
+		Name:     "status_message",
+		Get:      func(u *model.User) string { return u.StatusMessage },
+		Set:      func(u *model.User, value string) { u.StatusMessage = value },
+		Validate: maxLength(maxStatusMessageLength, false),
+	},
+}
+
+// readOnlyUserFields may appear in a PUT body, since clients send back what
+// they read, but are ignored. Privacy settings have an endpoint of their own.
+var readOnlyUserFields = map[string]bool{
+	"privacy":        true,
+	"user_id":        true,
+	"created_at":     true,
+	"updated_at":     true,
+	"deactivated_at": true,
+	"deleted_at":     true,
+	"version":        true,
+}
+
+// userDocument is the JSON document of the user's mutable fields that
+// patches are applied to.
+func userDocument(u *model.User) map[string]any {
+	doc := map[string]any{}
+	for _, field := range mutableUserFields {
+		doc[field.Name] = field.Get(u)
+	}
+	return doc
+}
+
+// setUserDocument replaces the user's mutable fields with doc. Optional
+// fields that are missing or null are cleared. It writes a problem response
+// and returns false when doc is not acceptable.
+func setUserDocument(w http.ResponseWriter, u *model.User, doc map[string]any) bool {
+	known := map[string]bool{}
+	for _, field := range mutableUserFields {
+		known[field.Name] = true
+	}
+	for name := range doc {
+		if !known[name] {
+			writeProblem(w, http.StatusUnprocessableEntity, "field_not_writable", "Field cannot be changed",
+				fmt.Sprintf("The field %q does not exist or cannot be changed.", name))
+			return false
+		}
+	}
+
+	for _, field := range mutableUserFields {
+		raw := doc[field.Name]
+		if raw == nil && field.Required {
+			writeProblem(w, http.StatusUnprocessableEntity, "field_required", "Field is required",
+				fmt.Sprintf("The field %q is required and cannot be removed.", field.Name))
+			return false
+		}
+
+		var value string
+		if raw != nil {
+			s, isString := raw.(string)
+			if !isString {
+				writeProblem(w, http.StatusUnprocessableEntity, "invalid_field", "Invalid field",
+					fmt.Sprintf("The field %q must be a string.", field.Name))
+				return false
+			}
+			value = s
+		}
+
+		if value != "" && field.Validate != nil {
+			if err := field.Validate(value); err != nil {
+				writeProblem(w, http.StatusUnprocessableEntity, "invalid_field", "Invalid field",
+					fmt.Sprintf("The field %q %s.", field.Name, err))
+				return false
+			}
+		}
+
+		field.Set(u, value)
+	}
+	return true
+}
+
+// loadForUpdate finds the user named in the URL and checks If-Match,
+// writing the response and returning nil when the update cannot go ahead.
+func (h *User) loadForUpdate(w http.ResponseWriter, r *http.Request) *model.User {
+	userID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return nil
+	}
+
+	theUser, err := h.PgRepo.FindByID(r.Context(), userID)
+	if errors.Is(err, user.ErrNotExist) {
+		w.WriteHeader(http.StatusNotFound)
+		return nil
+	} else if err != nil {
+		fmt.Println("failed to find user by id:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return nil
+	}
+
+	if !checkIfMatch(w, r, theUser) {
+		return nil
+	}
+	return theUser
+}
+
+// PatchByID applies a JSON Merge Patch (RFC 7396) or a JSON Patch
+// (RFC 6902) to the user's mutable fields.
+func (h *User) PatchByID(w http.ResponseWriter, r *http.Request) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != mergePatchType && mediaType != jsonPatchType {
+		w.Header().Set("Accept-Patch", acceptPatch)
+		writeProblem(w, http.StatusUnsupportedMediaType, "unsupported_patch_format", "Unsupported patch format",
+			"Send the patch as "+mergePatchType+" or "+jsonPatchType+".")
+		return
+	}
+
+	var mergeBody any
+	var operations []patchOperation
+	if mediaType == mergePatchType {
+		err := json.NewDecoder(r.Body).Decode(&mergeBody)
+		if _, isObject := mergeBody.(map[string]any); err != nil || !isObject {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	} else if err := json.NewDecoder(r.Body).Decode(&operations); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	theUser := h.loadForUpdate(w, r)
+	if theUser == nil {
+		return
+	}
+
+	var patched any
+	if mediaType == mergePatchType {
+		patched = mergePatch(userDocument(theUser), mergeBody)
+	} else {
+		var err error
+		patched, err = jsonPatch(userDocument(theUser), operations)
+		if errors.Is(err, errPatchTest) {
+			writeProblem(w, http.StatusConflict, "patch_test_failed", "Patch test failed", err.Error())
+			return
+		} else if err != nil {
+			writeProblem(w, http.StatusUnprocessableEntity, "invalid_patch", "Patch cannot be applied", err.Error())
+			return
+		}
+	}
+
+	doc, isObject := patched.(map[string]any)
+	if !isObject {
+		writeProblem(w, http.StatusUnprocessableEntity, "invalid_patch", "Patch cannot be applied",
+			"The patched user must be a JSON object.")
+		return
+	}
+
+	h.saveUpdate(w, r, theUser, doc)
+}
+
+// saveUpdate replaces theUser's mutable fields with doc and writes it back
+// along with its audit entry and events. It reports whether the user was
+// saved; either way the response has been written.
+func (h *User) saveUpdate(w http.ResponseWriter, r *http.Request, theUser *model.User, doc map[string]any) bool {
+	before := *theUser
+
+	if !setUserDocument(w, theUser, doc) {
+		return false
+	}
+
+	now := time.Now().UTC()
+	theUser.UpdatedAt = &now
+
+	// The email has an event of its own.
+	var changed []string
+	for _, field := range mutableUserFields {
+		if field.Name == "email" {
+			continue
+		}
+		if field.Get(theUser) != field.Get(&before) {
+			changed = append(changed, field.Name)
+		}
+	}
+
+	var events []messaging.Event
+	if len(changed) > 0 {
+		events = append(events, messaging.UserProfileUpdated{
+			UserID:        theUser.UserID,
+			Username:      theUser.Username,
+			DisplayName:   theUser.DisplayName,
+			Bio:           theUser.Bio,
+			Avatar:        theUser.Avatar,
+			Locale:        theUser.Locale,
+			Timezone:      theUser.Timezone,
+			Pronouns:      theUser.Pronouns,
+			StatusMessage: theUser.StatusMessage,
+			ChangedFields: changed,
+			UpdatedAt:     now,
+		})
+	}
+	if theUser.Email != before.Email {
+		events = append(events, messaging.UserEmailChanged{
+			UserID:    theUser.UserID,
+			Email:     theUser.Email,
+			ChangedAt: now,
+		})
+	}
+
+	err := h.PgRepo.DB.RunInTx(r.Context(), nil, func(ctx context.Context, tx bun.Tx) error {
+		if err := h.PgRepo.WithTx(tx).Update(ctx, theUser); err != nil {
+			return err
+		}
+		if err := h.Audit.WithTx(tx).Append(ctx, newAuditEntry(r, model.AuditUserUpdated, &theUser.UserID, audit.Diff(before, theUser))); err != nil {
+			return err
+		}
+		return h.Outbox.WithTx(tx).Enqueue(ctx, events...)
+	})
+	var conflict *user.ConflictError
+	if errors.As(err, &conflict) {
+		writeConflict(w, r, conflict.Current)
+		return false
+	} else if errors.Is(err, user.ErrNotExist) {
+		w.WriteHeader(http.StatusNotFound)
+		return false
+	} else if err != nil {
+		fmt.Println("failed to update user:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return false
+	}
+
+	h.removeReplacedAvatar(r, &before, theUser)
+
+	w.Header().Set("ETag", userETag(theUser))
+	if err := json.NewEncoder(w).Encode(theUser); err != nil {
+		fmt.Println("failed to marshal user:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	return true
+}
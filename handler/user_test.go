@@ -0,0 +1,63 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/CatalinPlesu/user-service/messaging"
+	"github.com/CatalinPlesu/user-service/messaging/messagingtest"
+	"github.com/CatalinPlesu/user-service/repository/jwts"
+)
+
+// deletingRedis answers every command as a successful DEL without a server.
+type deletingRedis struct{}
+
+func (deletingRedis) DialHook(next redis.DialHook) redis.DialHook { return next }
+
+func (deletingRedis) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		cmd.(*redis.IntCmd).SetVal(1)
+		return nil
+	}
+}
+
+func (deletingRedis) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return next
+}
+
+func TestRevokeSessionsEnqueuesEvent(t *testing.T) {
+	client := redis.NewClient(&redis.Options{Addr: "localhost:0"})
+	client.AddHook(deletingRedis{})
+	defer client.Close()
+
+	outbox := messaging.NewMemoryOutbox("users")
+	h := &User{RdRepo: &jwts.RedisRepo{Client: client}, Outbox: outbox}
+
+	userID := uuid.New()
+	router := chi.NewRouter()
+	router.Delete("/{id}/sessions", h.RevokeSessions)
+
+	res := httptest.NewRecorder()
+	events := messagingtest.Enqueued(t, outbox, func() {
+		router.ServeHTTP(res, httptest.NewRequest(http.MethodDelete, "/"+userID.String()+"/sessions", nil))
+	})
+	if res.Code != http.StatusNoContent {
+		t.Fatalf("status = %d, want %d", res.Code, http.StatusNoContent)
+	}
+
+	messagingtest.AssertPublished(t, events, messaging.EventUserSessionsRevoked)
+	var revoked messaging.UserSessionsRevoked
+	messagingtest.Data(t, events, messaging.EventUserSessionsRevoked, &revoked)
+	if revoked.UserID != userID || revoked.Reason != "user_request" {
+		t.Errorf("event = %+v, want user %s revoked by user_request", revoked, userID)
+	}
+	if events[0].Subject != userID.String() {
+		t.Errorf("subject = %s, want %s", events[0].Subject, userID)
+	}
+}
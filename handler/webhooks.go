@@ -0,0 +1,318 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+
+	"github.com/CatalinPlesu/user-service/messaging"
+	"github.com/CatalinPlesu/user-service/model"
+	"github.com/CatalinPlesu/user-service/repository/webhook"
+)
+
+const webhookDeliveriesLimit = 100
+
+// Webhooks lets administrators manage webhook endpoints and inspect their
+// deliveries.
+type Webhooks struct {
+	Repo *webhook.PostgresRepo
+}
+
+type webhookBody struct {
+	URL        *string   `json:"url,omitempty"`
+	EventTypes *[]string `json:"event_types,omitempty"`
+	Enabled    *bool     `json:"enabled,omitempty"`
+}
+
+// validate checks the fields that are set and returns a problem detail
+// describing the first invalid one.
+func (b webhookBody) validate() string {
+	if b.URL != nil {
+		u, err := url.Parse(*b.URL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return "url must be an absolute http or https URL"
+		}
+	}
+
+	if b.EventTypes != nil {
+		if len(*b.EventTypes) == 0 {
+			return "event_types must not be empty"
+		}
+		for _, eventType := range *b.EventTypes {
+			if eventType != webhook.AllEvents && messaging.SchemaVersion(eventType) == 0 {
+				return fmt.Sprintf("unknown event type %q", eventType)
+			}
+		}
+	}
+	return ""
+}
+
+// Create registers an endpoint. The signing secret is only ever returned
+// here.
+func (h *Webhooks) Create(w http.ResponseWriter, r *http.Request) {
+	var body webhookBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if body.URL == nil || body.EventTypes == nil {
+		writeProblem(w, http.StatusUnprocessableEntity, "invalid_webhook", "Invalid webhook", "url and event_types are required")
+		return
+	}
+	if detail := body.validate(); detail != "" {
+		writeProblem(w, http.StatusUnprocessableEntity, "invalid_webhook", "Invalid webhook", detail)
+		return
+	}
+
+	secret, err := webhook.GenerateSecret()
+	if err != nil {
+		fmt.Println("failed to generate webhook secret:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now().UTC()
+	endpoint := model.WebhookEndpoint{
+		ID:         uuid.New(),
+		URL:        *body.URL,
+		Secret:     secret,
+		EventTypes: *body.EventTypes,
+		Enabled:    body.Enabled == nil || *body.Enabled,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+
+	if err := h.Repo.InsertEndpoint(r.Context(), &endpoint); err != nil {
+		fmt.Println("failed to insert webhook endpoint:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	response := struct {
+		model.WebhookEndpoint
+		Secret string `json:"secret"`
+	}{
+		WebhookEndpoint: endpoint,
+		Secret:          secret,
+	}
+
+	res, err := json.Marshal(response)
+	if err != nil {
+		fmt.Println("failed to marshal response:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	w.Write(res)
+}
+
+func (h *Webhooks) List(w http.ResponseWriter, r *http.Request) {
+	endpoints, err := h.Repo.ListEndpoints(r.Context())
+	if err != nil {
+		fmt.Println("failed to list webhook endpoints:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(endpoints); err != nil {
+		fmt.Println("failed to marshal webhook endpoints:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *Webhooks) Get(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	endpoint, err := h.Repo.FindEndpoint(r.Context(), id)
+	if errors.Is(err, webhook.ErrNotExist) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		fmt.Println("failed to find webhook endpoint:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(endpoint); err != nil {
+		fmt.Println("failed to marshal webhook endpoint:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// Update changes the URL, subscriptions or enabled state of an endpoint.
+// Enabling an endpoint clears its failure count.
+func (h *Webhooks) Update(w http.ResponseWriter, r *http.Request) {
+	var body webhookBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if detail := body.validate(); detail != "" {
+		writeProblem(w, http.StatusUnprocessableEntity, "invalid_webhook", "Invalid webhook", detail)
+		return
+	}
+
+	endpoint, err := h.Repo.FindEndpoint(r.Context(), id)
+	if errors.Is(err, webhook.ErrNotExist) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		fmt.Println("failed to find webhook endpoint:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if body.URL != nil {
+		endpoint.URL = *body.URL
+	}
+	if body.EventTypes != nil {
+		endpoint.EventTypes = *body.EventTypes
+	}
+	if body.Enabled != nil {
+		if *body.Enabled && !endpoint.Enabled {
+			endpoint.ConsecutiveFailures = 0
+			endpoint.DisabledReason = ""
+		}
+		endpoint.Enabled = *body.Enabled
+	}
+	endpoint.UpdatedAt = time.Now().UTC()
+
+	err = h.Repo.UpdateEndpoint(r.Context(), endpoint)
+	if errors.Is(err, webhook.ErrNotExist) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		fmt.Println("failed to update webhook endpoint:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(endpoint); err != nil {
+		fmt.Println("failed to marshal webhook endpoint:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *Webhooks) Delete(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	err = h.Repo.DeleteEndpoint(r.Context(), id)
+	if errors.Is(err, webhook.ErrNotExist) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		fmt.Println("failed to delete webhook endpoint:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListDeliveries returns the most recent deliveries to an endpoint with
+// their status and last response code.
+func (h *Webhooks) ListDeliveries(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	deliveries, err := h.Repo.ListDeliveries(r.Context(), id, webhookDeliveriesLimit)
+	if err != nil {
+		fmt.Println("failed to list webhook deliveries:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(deliveries); err != nil {
+		fmt.Println("failed to marshal webhook deliveries:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// ListAttempts returns every request made for a delivery.
+func (h *Webhooks) ListAttempts(w http.ResponseWriter, r *http.Request) {
+	id, deliveryID, ok := parseDeliveryParams(w, r)
+	if !ok {
+		return
+	}
+
+	attempts, err := h.Repo.ListAttempts(r.Context(), id, deliveryID)
+	if errors.Is(err, webhook.ErrNotExist) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		fmt.Println("failed to list webhook attempts:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(attempts); err != nil {
+		fmt.Println("failed to marshal webhook attempts:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// Redeliver sends a delivery again, whether it succeeded or gave up.
+func (h *Webhooks) Redeliver(w http.ResponseWriter, r *http.Request) {
+	id, deliveryID, ok := parseDeliveryParams(w, r)
+	if !ok {
+		return
+	}
+
+	err := h.Repo.Redeliver(r.Context(), id, deliveryID, time.Now().UTC())
+	if errors.Is(err, webhook.ErrNotExist) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		fmt.Println("failed to redeliver webhook:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func parseDeliveryParams(w http.ResponseWriter, r *http.Request) (uuid.UUID, uuid.UUID, bool) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	deliveryID, err := uuid.Parse(chi.URLParam(r, "deliveryID"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return uuid.Nil, uuid.Nil, false
+	}
+	return id, deliveryID, true
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	_ "time/tzdata" // profile time zones are validated without relying on the host
 
 	"github.com/CatalinPlesu/user-service/application"
 )
@@ -0,0 +1,36 @@
+package messaging
+
+import (
+	"context"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// Message is a message as seen by a subscriber.
+type Message struct {
+	Exchange   string
+	RoutingKey string
+	amqp091.Publishing
+}
+
+type MessageHandler func(ctx context.Context, msg Message) error
+
+// Publisher sends messages to an exchange. The empty exchange is the default
+// exchange, where the routing key names a queue.
+type Publisher interface {
+	Publish(ctx context.Context, exchange, routingKey string, msg amqp091.Publishing) error
+}
+
+// Subscriber delivers messages matching bindingKey on exchange to handler
+// until ctx is done. Named exchanges are topic exchanges, so bindingKey may
+// use the "*" and "#" wildcards; on the default exchange it names a queue.
+type Subscriber interface {
+	Subscribe(ctx context.Context, exchange, bindingKey string, handler MessageHandler) error
+}
+
+var (
+	_ Publisher  = (*RabbitMQ)(nil)
+	_ Subscriber = (*RabbitMQ)(nil)
+	_ Publisher  = (*MemoryBroker)(nil)
+	_ Subscriber = (*MemoryBroker)(nil)
+)
@@ -0,0 +1,171 @@
+package messaging
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	cloudEventsSpecVersion = "1.0"
+	cloudEventsContentType = "application/cloudevents+json"
+
+	// Prefix of CloudEvents attributes carried as AMQP application
+	// properties in binary content mode.
+	cloudEventsHeaderPrefix = "cloudEvents:"
+)
+
+var ErrNotCloudEvent = errors.New("message is not a cloud event")
+
+// ContentMode selects how CloudEvents are mapped onto AMQP messages, as
+// defined by the CloudEvents AMQP protocol binding.
+type ContentMode string
+
+const (
+	// ContentModeStructured sends the whole envelope as the message body.
+	ContentModeStructured ContentMode = "structured"
+	// ContentModeBinary sends only the data as the body and the remaining
+	// attributes as application properties.
+	ContentModeBinary ContentMode = "binary"
+)
+
+func ParseContentMode(s string) (ContentMode, error) {
+	switch mode := ContentMode(strings.ToLower(s)); mode {
+	case ContentModeStructured, ContentModeBinary:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown content mode %q", s)
+	}
+}
+
+// CloudEvent is a CloudEvents 1.0 envelope in its JSON format.
+// SchemaVersion is an extension attribute with the version of the event
+// type's data schema.
+type CloudEvent struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Subject         string          `json:"subject,omitempty"`
+	Time            time.Time       `json:"time"`
+	DataContentType string          `json:"datacontenttype"`
+	DataSchema      string          `json:"dataschema,omitempty"`
+	SchemaVersion   int             `json:"schemaversion"`
+	Data            json.RawMessage `json:"data"`
+}
+
+// NewCloudEvent wraps event in an envelope. source identifies this service
+// and schemaBase is the URL prefix schemas are served under.
+func NewCloudEvent(event Event, source, schemaBase string, now time.Time) (CloudEvent, error) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return CloudEvent{}, fmt.Errorf("failed to marshal %s event: %w", event.EventType(), err)
+	}
+
+	version := SchemaVersion(event.EventType())
+
+	return CloudEvent{
+		SpecVersion:     cloudEventsSpecVersion,
+		ID:              uuid.NewString(),
+		Source:          source,
+		Type:            event.EventType(),
+		Subject:         event.EventUserID().String(),
+		Time:            now.UTC(),
+		DataContentType: "application/json",
+		DataSchema:      SchemaURL(schemaBase, event.EventType(), version),
+		SchemaVersion:   version,
+		Data:            data,
+	}, nil
+}
+
+// Publishing maps the envelope onto an AMQP message in the given mode.
+func (e CloudEvent) Publishing(mode ContentMode) (amqp091.Publishing, error) {
+	if mode == ContentModeBinary {
+		headers := amqp091.Table{
+			cloudEventsHeaderPrefix + "specversion":   e.SpecVersion,
+			cloudEventsHeaderPrefix + "id":            e.ID,
+			cloudEventsHeaderPrefix + "source":        e.Source,
+			cloudEventsHeaderPrefix + "type":          e.Type,
+			cloudEventsHeaderPrefix + "time":          e.Time.Format(time.RFC3339Nano),
+			cloudEventsHeaderPrefix + "schemaversion": int32(e.SchemaVersion),
+		}
+		if e.Subject != "" {
+			headers[cloudEventsHeaderPrefix+"subject"] = e.Subject
+		}
+		if e.DataSchema != "" {
+			headers[cloudEventsHeaderPrefix+"dataschema"] = e.DataSchema
+		}
+
+		return amqp091.Publishing{
+			ContentType:  e.DataContentType,
+			DeliveryMode: amqp091.Persistent,
+			MessageId:    e.ID,
+			Timestamp:    e.Time,
+			Type:         e.Type,
+			Headers:      headers,
+			Body:         e.Data,
+		}, nil
+	}
+
+	body, err := json.Marshal(e)
+	if err != nil {
+		return amqp091.Publishing{}, fmt.Errorf("failed to marshal cloud event: %w", err)
+	}
+
+	return amqp091.Publishing{
+		ContentType:  cloudEventsContentType,
+		DeliveryMode: amqp091.Persistent,
+		MessageId:    e.ID,
+		Timestamp:    e.Time,
+		Type:         e.Type,
+		Body:         body,
+	}, nil
+}
+
+// CloudEventFromMessage reads the envelope back from a message published in
+// either content mode.
+func CloudEventFromMessage(msg Message) (CloudEvent, error) {
+	if msg.ContentType == cloudEventsContentType {
+		var e CloudEvent
+		if err := json.Unmarshal(msg.Body, &e); err != nil {
+			return CloudEvent{}, fmt.Errorf("failed to decode cloud event: %w", err)
+		}
+		return e, nil
+	}
+
+	header := func(name string) string {
+		value, _ := msg.Headers[cloudEventsHeaderPrefix+name].(string)
+		return value
+	}
+	if header("specversion") == "" {
+		return CloudEvent{}, ErrNotCloudEvent
+	}
+
+	e := CloudEvent{
+		SpecVersion:     header("specversion"),
+		ID:              header("id"),
+		Source:          header("source"),
+		Type:            header("type"),
+		Subject:         header("subject"),
+		DataContentType: msg.ContentType,
+		DataSchema:      header("dataschema"),
+		Data:            msg.Body,
+	}
+	if t, err := time.Parse(time.RFC3339Nano, header("time")); err == nil {
+		e.Time = t
+	}
+	switch version := msg.Headers[cloudEventsHeaderPrefix+"schemaversion"].(type) {
+	case int32:
+		e.SchemaVersion = int(version)
+	case int64:
+		e.SchemaVersion = int(version)
+	case int:
+		e.SchemaVersion = version
+	}
+	return e, nil
+}
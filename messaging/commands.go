@@ -0,0 +1,26 @@
+package messaging
+
+import "github.com/google/uuid"
+
+// Types of the commands other services send to the user service. They are
+// used as routing keys on the commands exchange.
+const (
+	CommandDeactivateUser     = "user.deactivate"
+	CommandRevokeSessions     = "user.revoke_sessions"
+	CommandAcknowledgeErasure = "user.acknowledge_erasure"
+)
+
+type DeactivateUserCommand struct {
+	UserID uuid.UUID `json:"user_id"`
+	Reason string    `json:"reason"`
+}
+
+type RevokeSessionsCommand struct {
+	UserID uuid.UUID `json:"user_id"`
+	Reason string    `json:"reason"`
+}
+
+type AcknowledgeErasureCommand struct {
+	ErasureID uuid.UUID `json:"erasure_id"`
+	Service   string    `json:"service"`
+}
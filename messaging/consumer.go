@@ -0,0 +1,358 @@
+package messaging
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rabbitmq/amqp091-go"
+
+	"github.com/CatalinPlesu/user-service/repository/inbox"
+)
+
+// ErrPermanent marks a command that can never succeed, such as one with a
+// malformed body. Handlers wrap it to dead-letter the message without
+// retrying.
+var ErrPermanent = errors.New("permanent failure")
+
+const retryCountHeader = "x-retry-count"
+
+// Delivery is an inbound command handed to a CommandHandler.
+type Delivery struct {
+	ID      string
+	Type    string
+	Body    []byte
+	Attempt int // 1 on the first delivery
+}
+
+// Decode unmarshals the JSON body into v. A body that does not decode is a
+// permanent failure.
+func (d Delivery) Decode(v any) error {
+	if err := json.Unmarshal(d.Body, v); err != nil {
+		return fmt.Errorf("%w: failed to decode %s command: %v", ErrPermanent, d.Type, err)
+	}
+	return nil
+}
+
+type CommandHandler func(ctx context.Context, d Delivery) error
+
+// Consumer receives commands from other services on Queue, which is bound to
+// Exchange for every registered command type. Messages are acknowledged
+// manually once handled, and each message ID is processed only once. A
+// failing message is retried after a growing delay through one retry queue
+// per delay, and sent to DeadLetterExchange after MaxRetries retries.
+type Consumer struct {
+	Exchange           string
+	Queue              string
+	DeadLetterExchange string
+	Prefetch           int
+	MaxRetries         int
+	RetryDelay         time.Duration // Delay before the first retry, doubled for each further one
+	MaxRetryDelay      time.Duration
+	Timeout            time.Duration
+	Processed          *inbox.RedisRepo
+
+	handlers map[string]CommandHandler
+}
+
+func NewConsumer(exchange, queue string, processed *inbox.RedisRepo) *Consumer {
+	return &Consumer{
+		Exchange:           exchange,
+		Queue:              queue,
+		DeadLetterExchange: queue + ".dlx",
+		Prefetch:           10,
+		MaxRetries:         5,
+		RetryDelay:         time.Second,
+		MaxRetryDelay:      5 * time.Minute,
+		Timeout:            30 * time.Second,
+		Processed:          processed,
+		handlers:           map[string]CommandHandler{},
+	}
+}
+
+// Handle registers h for commands of commandType. Handlers must be
+// registered before the consumer is started.
+func (c *Consumer) Handle(commandType string, h CommandHandler) {
+	c.handlers[commandType] = h
+}
+
+// retryQueue names the queue holding messages to be retried after delay.
+// Every message in a queue waits the same time, so none is held up behind
+// one with a longer delay.
+func (c *Consumer) retryQueue(delay time.Duration) string {
+	return fmt.Sprintf("%s.retry.%dms", c.Queue, delay.Milliseconds())
+}
+
+// retryDelays returns the distinct delays retries can wait for.
+func (c *Consumer) retryDelays() []time.Duration {
+	var delays []time.Duration
+	for retries := 1; retries <= max(c.MaxRetries, 1); retries++ {
+		delay := c.retryDelay(retries)
+		if len(delays) == 0 || delays[len(delays)-1] != delay {
+			delays = append(delays, delay)
+		}
+	}
+	return delays
+}
+
+func (c *Consumer) deadLetterQueue() string {
+	return c.Queue + ".dead"
+}
+
+// declare sets up the command queue with its bindings, the retry queues
+// that feed expired messages back into it, and the dead-letter exchange and
+// queue.
+func (c *Consumer) declare(ch *amqp091.Channel) error {
+	if err := declareExchange(ch, c.Exchange); err != nil {
+		return err
+	}
+
+	err := ch.ExchangeDeclare(
+		c.DeadLetterExchange, // name
+		"fanout",             // kind
+		true,                 // durable
+		false,                // auto-deleted
+		false,                // internal
+		false,                // no-wait
+		nil,                  // arguments
+	)
+	if err != nil {
+		return fmt.Errorf("failed to declare dead-letter exchange: %w", err)
+	}
+
+	if err := declareQueue(ch, c.deadLetterQueue(), nil); err != nil {
+		return err
+	}
+	if err := ch.QueueBind(c.deadLetterQueue(), "", c.DeadLetterExchange, false, nil); err != nil {
+		return fmt.Errorf("failed to bind dead-letter queue: %w", err)
+	}
+
+	err = declareQueue(ch, c.Queue, amqp091.Table{
+		"x-dead-letter-exchange": c.DeadLetterExchange,
+	})
+	if err != nil {
+		return err
+	}
+	for commandType := range c.handlers {
+		if err := ch.QueueBind(c.Queue, commandType, c.Exchange, false, nil); err != nil {
+			return fmt.Errorf("failed to bind command queue: %w", err)
+		}
+	}
+
+	// Messages in the retry queues expire back into the command queue.
+	for _, delay := range c.retryDelays() {
+		err := declareQueue(ch, c.retryQueue(delay), amqp091.Table{
+			"x-message-ttl":             delay.Milliseconds(),
+			"x-dead-letter-exchange":    "",
+			"x-dead-letter-routing-key": c.Queue,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// start opens a channel on conn and processes deliveries with up to
+// Prefetch workers until the channel closes.
+func (c *Consumer) start(conn *amqp091.Connection) error {
+	ch, err := conn.Channel()
+	if err != nil {
+		return fmt.Errorf("failed to open a channel: %w", err)
+	}
+
+	if err := ch.Qos(c.Prefetch, 0, false); err != nil {
+		ch.Close()
+		return fmt.Errorf("failed to set prefetch: %w", err)
+	}
+
+	if err := c.declare(ch); err != nil {
+		ch.Close()
+		return err
+	}
+
+	// Retries are only acknowledged once the broker confirms it has them.
+	if err := ch.Confirm(false); err != nil {
+		ch.Close()
+		return fmt.Errorf("failed to enable publisher confirms: %w", err)
+	}
+
+	deliveries, err := ch.Consume(
+		c.Queue, // queue
+		"",      // consumer
+		false,   // auto-ack
+		false,   // exclusive
+		false,   // no-local
+		false,   // no-wait
+		nil,     // args
+	)
+	if err != nil {
+		ch.Close()
+		return fmt.Errorf("failed to consume %s: %w", c.Queue, err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < max(c.Prefetch, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for d := range deliveries {
+				c.process(ch, d)
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		fmt.Println("stopped consuming", c.Queue)
+	}()
+
+	return nil
+}
+
+func (c *Consumer) process(ch *amqp091.Channel, d amqp091.Delivery) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancel()
+
+	commandType := d.Type
+	if commandType == "" {
+		commandType = d.RoutingKey
+	}
+
+	handler, ok := c.handlers[commandType]
+	if !ok || d.MessageId == "" {
+		fmt.Println("dead-lettering", commandType, "command without message ID or handler")
+		c.deadLetter(d)
+		return
+	}
+
+	retries := retryCount(d.Headers)
+
+	if c.Processed != nil {
+		claimed, err := c.Processed.Claim(ctx, d.MessageId)
+		if err != nil {
+			// Either a redelivery is being handled elsewhere or the
+			// inbox is unavailable; look again later without counting
+			// this as a failed attempt.
+			fmt.Println("failed to claim command", d.MessageId, ":", err)
+			c.retry(ctx, ch, d, commandType, retries)
+			return
+		}
+		if !claimed {
+			c.ack(d)
+			return
+		}
+	}
+
+	err := handler(ctx, Delivery{
+		ID:      d.MessageId,
+		Type:    commandType,
+		Body:    d.Body,
+		Attempt: retries + 1,
+	})
+	if err == nil {
+		if c.Processed != nil {
+			if err := c.Processed.Complete(ctx, d.MessageId); err != nil {
+				fmt.Println("failed to record processed command:", err)
+			}
+		}
+		c.ack(d)
+		return
+	}
+
+	fmt.Println("failed to handle", commandType, "command", d.MessageId, ":", err)
+	if c.Processed != nil {
+		if err := c.Processed.Release(ctx, d.MessageId); err != nil {
+			fmt.Println("failed to release command:", err)
+		}
+	}
+
+	if errors.Is(err, ErrPermanent) || retries >= c.MaxRetries {
+		c.deadLetter(d)
+		return
+	}
+	c.retry(ctx, ch, d, commandType, retries+1)
+}
+
+// retry parks a copy of the message in a retry queue until its back-off
+// expires, and acknowledges the original once the broker confirmed the
+// copy.
+func (c *Consumer) retry(ctx context.Context, ch *amqp091.Channel, d amqp091.Delivery, commandType string, retries int) {
+	headers := amqp091.Table{}
+	for k, v := range d.Headers {
+		headers[k] = v
+	}
+	headers[retryCountHeader] = int32(retries)
+
+	queue := c.retryQueue(c.retryDelay(retries))
+	confirm, err := ch.PublishWithDeferredConfirmWithContext(ctx,
+		"",    // exchange
+		queue, // routing key (queue name)
+		false, // mandatory
+		false, // immediate
+		amqp091.Publishing{
+			Headers:      headers,
+			ContentType:  d.ContentType,
+			DeliveryMode: amqp091.Persistent,
+			MessageId:    d.MessageId,
+			Type:         commandType,
+			Timestamp:    d.Timestamp,
+			Body:         d.Body,
+		},
+	)
+	if err == nil {
+		var acked bool
+		acked, err = confirm.WaitContext(ctx)
+		if err == nil && !acked {
+			err = ErrNacked
+		}
+	}
+	if err != nil {
+		fmt.Println("failed to schedule command retry:", err)
+		if err := d.Nack(false, true); err != nil {
+			fmt.Println("failed to requeue command:", err)
+		}
+		return
+	}
+	c.ack(d)
+}
+
+func (c *Consumer) retryDelay(retries int) time.Duration {
+	delay := c.RetryDelay
+	for i := 1; i < retries; i++ {
+		delay *= 2
+		if delay >= c.MaxRetryDelay {
+			return c.MaxRetryDelay
+		}
+	}
+	return delay
+}
+
+func (c *Consumer) ack(d amqp091.Delivery) {
+	if err := d.Ack(false); err != nil {
+		fmt.Println("failed to ack command:", err)
+	}
+}
+
+// deadLetter rejects the message; the queue routes it to the dead-letter
+// exchange.
+func (c *Consumer) deadLetter(d amqp091.Delivery) {
+	if err := d.Nack(false, false); err != nil {
+		fmt.Println("failed to dead-letter command:", err)
+	}
+}
+
+func retryCount(headers amqp091.Table) int {
+	switch n := headers[retryCountHeader].(type) {
+	case int32:
+		return int(n)
+	case int64:
+		return int(n)
+	case int:
+		return n
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,31 @@
+package messaging
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestConsumerRetryDelays(t *testing.T) {
+	c := NewConsumer("commands", "service.commands", nil)
+	c.RetryDelay = time.Second
+	c.MaxRetryDelay = 5 * time.Second
+	c.MaxRetries = 6
+
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second}
+	if got := c.retryDelays(); !slices.Equal(got, want) {
+		t.Errorf("retry delays = %v, want %v", got, want)
+	}
+
+	// Every retry must go to a declared queue, including retries that do
+	// not count as an attempt.
+	declared := map[string]bool{}
+	for _, delay := range c.retryDelays() {
+		declared[c.retryQueue(delay)] = true
+	}
+	for retries := 0; retries <= c.MaxRetries; retries++ {
+		if queue := c.retryQueue(c.retryDelay(retries)); !declared[queue] {
+			t.Errorf("retry %d goes to undeclared queue %s", retries, queue)
+		}
+	}
+}
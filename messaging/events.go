@@ -0,0 +1,196 @@
+package messaging
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Routing keys of the user lifecycle events published to the events topic
+// exchange. Consumers bind with patterns such as "user.*" or
+// "user.profile_updated".
+const (
+	EventUserRegistered      = "user.registered"
+	EventUserLoggedIn        = "user.logged_in"
+	EventUserProfileUpdated  = "user.profile_updated"
+	EventUserEmailChanged    = "user.email_changed"
+	EventUserDeleted         = "user.deleted"
+	EventUserSessionsRevoked = "user.sessions_revoked"
+	EventUserDeactivated     = "user.deactivated"
+	EventUserRestored        = "user.restored"
+	EventUserPurged          = "user.purged"
+	EventUserExportReady     = "user.export_ready"
+	EventUserErased          = "user.erased"
+	EventUserBlocked         = "user.blocked"
+	EventUserUnblocked       = "user.unblocked"
+)
+
+// Routing keys of the contact events. Each change is published once for
+// each of the two users, keyed on the user whose contacts changed.
+const (
+	EventContactAdded   = "contact.added"
+	EventContactRemoved = "contact.removed"
+)
+
+// Event is a domain event about a single user. The user ID is used to keep
+// each user's events in order.
+type Event interface {
+	EventType() string
+	EventUserID() uuid.UUID
+}
+
+type UserRegistered struct {
+	UserID       uuid.UUID `json:"user_id"`
+	Username     string    `json:"username"`
+	DisplayName  string    `json:"display_name"`
+	Email        string    `json:"email"`
+	RegisteredAt time.Time `json:"registered_at"`
+}
+
+func (e UserRegistered) EventType() string      { return EventUserRegistered }
+func (e UserRegistered) EventUserID() uuid.UUID { return e.UserID }
+
+type UserLoggedIn struct {
+	UserID     uuid.UUID `json:"user_id"`
+	Method     string    `json:"method"`
+	SessionID  string    `json:"session_id"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	LoggedInAt time.Time `json:"logged_in_at"`
+}
+
+func (e UserLoggedIn) EventType() string      { return EventUserLoggedIn }
+func (e UserLoggedIn) EventUserID() uuid.UUID { return e.UserID }
+
+type UserProfileUpdated struct {
+	UserID        uuid.UUID `json:"user_id"`
+	Username      string    `json:"username"`
+	DisplayName   string    `json:"display_name"`
+	Bio           string    `json:"bio,omitempty"`
+	Avatar        string    `json:"avatar,omitempty"`
+	Locale        string    `json:"locale,omitempty"`
+	Timezone      string    `json:"timezone,omitempty"`
+	Pronouns      string    `json:"pronouns,omitempty"`
+	StatusMessage string    `json:"status_message,omitempty"`
+	ChangedFields []string  `json:"changed_fields"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+func (e UserProfileUpdated) EventType() string      { return EventUserProfileUpdated }
+func (e UserProfileUpdated) EventUserID() uuid.UUID { return e.UserID }
+
+type UserEmailChanged struct {
+	UserID    uuid.UUID `json:"user_id"`
+	Email     string    `json:"email"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+func (e UserEmailChanged) EventType() string      { return EventUserEmailChanged }
+func (e UserEmailChanged) EventUserID() uuid.UUID { return e.UserID }
+
+type UserDeleted struct {
+	UserID    uuid.UUID `json:"user_id"`
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+func (e UserDeleted) EventType() string      { return EventUserDeleted }
+func (e UserDeleted) EventUserID() uuid.UUID { return e.UserID }
+
+type UserSessionsRevoked struct {
+	UserID    uuid.UUID `json:"user_id"`
+	Reason    string    `json:"reason"`
+	RevokedAt time.Time `json:"revoked_at"`
+}
+
+func (e UserSessionsRevoked) EventType() string      { return EventUserSessionsRevoked }
+func (e UserSessionsRevoked) EventUserID() uuid.UUID { return e.UserID }
+
+type UserDeactivated struct {
+	UserID        uuid.UUID `json:"user_id"`
+	Reason        string    `json:"reason"`
+	DeactivatedAt time.Time `json:"deactivated_at"`
+}
+
+func (e UserDeactivated) EventType() string      { return EventUserDeactivated }
+func (e UserDeactivated) EventUserID() uuid.UUID { return e.UserID }
+
+type UserRestored struct {
+	UserID     uuid.UUID `json:"user_id"`
+	Username   string    `json:"username"`
+	RestoredAt time.Time `json:"restored_at"`
+}
+
+func (e UserRestored) EventType() string      { return EventUserRestored }
+func (e UserRestored) EventUserID() uuid.UUID { return e.UserID }
+
+// UserPurged is emitted once a deleted user's grace period is over and
+// their data has been removed for good.
+type UserPurged struct {
+	UserID   uuid.UUID `json:"user_id"`
+	PurgedAt time.Time `json:"purged_at"`
+}
+
+func (e UserPurged) EventType() string      { return EventUserPurged }
+func (e UserPurged) EventUserID() uuid.UUID { return e.UserID }
+
+// UserExportReady tells the user that the data export they asked for can be
+// downloaded from DownloadPath until ExpiresAt.
+type UserExportReady struct {
+	UserID       uuid.UUID `json:"user_id"`
+	ExportID     uuid.UUID `json:"export_id"`
+	DownloadPath string    `json:"download_path"`
+	Size         int64     `json:"size"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+func (e UserExportReady) EventType() string      { return EventUserExportReady }
+func (e UserExportReady) EventUserID() uuid.UUID { return e.UserID }
+
+// UserErased asks every service holding data about the user to erase it
+// and then send CommandAcknowledgeErasure with ErasureID.
+type UserErased struct {
+	UserID    uuid.UUID `json:"user_id"`
+	ErasureID uuid.UUID `json:"erasure_id"`
+	ErasedAt  time.Time `json:"erased_at"`
+}
+
+func (e UserErased) EventType() string      { return EventUserErased }
+func (e UserErased) EventUserID() uuid.UUID { return e.UserID }
+
+// UserBlocked tells other services, such as chat, to stop delivering
+// anything from the blocked user to the blocker.
+type UserBlocked struct {
+	UserID        uuid.UUID `json:"user_id"`
+	BlockedUserID uuid.UUID `json:"blocked_user_id"`
+	BlockedAt     time.Time `json:"blocked_at"`
+}
+
+func (e UserBlocked) EventType() string      { return EventUserBlocked }
+func (e UserBlocked) EventUserID() uuid.UUID { return e.UserID }
+
+type UserUnblocked struct {
+	UserID          uuid.UUID `json:"user_id"`
+	UnblockedUserID uuid.UUID `json:"unblocked_user_id"`
+	UnblockedAt     time.Time `json:"unblocked_at"`
+}
+
+func (e UserUnblocked) EventType() string      { return EventUserUnblocked }
+func (e UserUnblocked) EventUserID() uuid.UUID { return e.UserID }
+
+type ContactAdded struct {
+	UserID    uuid.UUID `json:"user_id"`
+	ContactID uuid.UUID `json:"contact_id"`
+	RequestID uuid.UUID `json:"request_id"`
+	AddedAt   time.Time `json:"added_at"`
+}
+
+func (e ContactAdded) EventType() string      { return EventContactAdded }
+func (e ContactAdded) EventUserID() uuid.UUID { return e.UserID }
+
+type ContactRemoved struct {
+	UserID    uuid.UUID `json:"user_id"`
+	ContactID uuid.UUID `json:"contact_id"`
+	RemovedAt time.Time `json:"removed_at"`
+}
+
+func (e ContactRemoved) EventType() string      { return EventContactRemoved }
+func (e ContactRemoved) EventUserID() uuid.UUID { return e.UserID }
@@ -0,0 +1,248 @@
+package messaging
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rabbitmq/amqp091-go"
+	"github.com/uptrace/bun"
+
+	"github.com/CatalinPlesu/user-service/model"
+	"github.com/CatalinPlesu/user-service/repository/jwts"
+)
+
+// MemoryBroker is an in-process Publisher and Subscriber for tests. Named
+// exchanges route like RabbitMQ topic exchanges and the default exchange
+// delivers to subscribers of the queue named by the routing key. Handlers
+// run synchronously within Publish, and every published message is recorded.
+type MemoryBroker struct {
+	mu            sync.Mutex
+	subscriptions []*memorySubscription
+	published     []Message
+}
+
+type memorySubscription struct {
+	ctx        context.Context
+	exchange   string
+	bindingKey string
+	handler    MessageHandler
+}
+
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{}
+}
+
+func (s *memorySubscription) matches(exchange, routingKey string) bool {
+	if s.ctx.Err() != nil || s.exchange != exchange {
+		return false
+	}
+	if exchange == "" {
+		return s.bindingKey == routingKey
+	}
+	return topicMatches(s.bindingKey, routingKey)
+}
+
+func (b *MemoryBroker) Publish(ctx context.Context, exchange, routingKey string, msg amqp091.Publishing) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m := Message{
+		Exchange:   exchange,
+		RoutingKey: routingKey,
+		Publishing: msg,
+	}
+
+	b.mu.Lock()
+	b.published = append(b.published, m)
+	var matched []*memorySubscription
+	for _, s := range b.subscriptions {
+		if s.matches(exchange, routingKey) {
+			matched = append(matched, s)
+		}
+	}
+	b.mu.Unlock()
+
+	// As with RabbitMQ, a failing subscriber does not fail the publish.
+	for _, s := range matched {
+		if err := s.handler(s.ctx, m); err != nil {
+			fmt.Println("failed to handle", routingKey, "message:", err)
+		}
+	}
+	return nil
+}
+
+func (b *MemoryBroker) Subscribe(ctx context.Context, exchange, bindingKey string, handler MessageHandler) error {
+	s := &memorySubscription{
+		ctx:        ctx,
+		exchange:   exchange,
+		bindingKey: bindingKey,
+		handler:    handler,
+	}
+
+	b.mu.Lock()
+	b.subscriptions = append(b.subscriptions, s)
+	b.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		for i, other := range b.subscriptions {
+			if other == s {
+				b.subscriptions = append(b.subscriptions[:i], b.subscriptions[i+1:]...)
+				break
+			}
+		}
+	}()
+	return nil
+}
+
+// Published returns every message published so far, in order.
+func (b *MemoryBroker) Published() []Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]Message(nil), b.published...)
+}
+
+// Reset forgets the recorded messages. Subscriptions are kept.
+func (b *MemoryBroker) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.published = nil
+}
+
+// MemoryOutbox is an Enqueuer for tests. It records the messages the Outbox
+// would have written, as they would be published, instead of storing them
+// in Postgres. Messages enqueued within a transaction are recorded straight
+// away, whether or not the transaction commits. Relay publishes them the
+// way Relay publishes outbox rows.
+type MemoryOutbox struct {
+	Exchange   string
+	Source     string
+	SchemaBase string
+
+	mu       sync.Mutex
+	enqueued []Message
+	relayed  int // How many of enqueued were relayed
+}
+
+func NewMemoryOutbox(exchange string) *MemoryOutbox {
+	return &MemoryOutbox{Exchange: exchange, Source: "/test"}
+}
+
+// WithTx returns o itself; there is nothing to roll back.
+func (o *MemoryOutbox) WithTx(bun.Tx) Enqueuer {
+	return o
+}
+
+func (o *MemoryOutbox) Enqueue(ctx context.Context, events ...Event) error {
+	now := time.Now()
+
+	messages := make([]model.OutboxMessage, len(events))
+	for i, event := range events {
+		_, msg, err := eventMessage(event, o.Exchange, o.Source, o.SchemaBase, now)
+		if err != nil {
+			return err
+		}
+		messages[i] = msg
+	}
+
+	o.record(messages...)
+	return nil
+}
+
+func (o *MemoryOutbox) EnqueueLoginRegister(ctx context.Context, userID uuid.UUID, session jwts.Session) error {
+	msg, err := loginRegisterMessage(userID, session)
+	if err != nil {
+		return err
+	}
+
+	o.record(msg)
+	return nil
+}
+
+// Forget drops the recorded messages about the user. Only event subjects
+// and LoginRegisterMessage user IDs are looked at.
+func (o *MemoryOutbox) Forget(ctx context.Context, userID uuid.UUID) ([]string, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	kept := o.enqueued[:0]
+	relayed := 0
+	for i, msg := range o.enqueued {
+		if aboutUser(msg, userID) {
+			continue
+		}
+		kept = append(kept, msg)
+		if i < o.relayed {
+			relayed++
+		}
+	}
+	o.enqueued = kept
+	o.relayed = relayed
+	return []string{"outbox"}, nil
+}
+
+func aboutUser(msg Message, userID uuid.UUID) bool {
+	if e, err := CloudEventFromMessage(msg); err == nil {
+		return e.Subject == userID.String()
+	}
+
+	var login LoginRegisterMessage
+	return json.Unmarshal(msg.Body, &login) == nil && login.UserID == userID
+}
+
+func (o *MemoryOutbox) record(messages ...model.OutboxMessage) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, msg := range messages {
+		o.enqueued = append(o.enqueued, Message{
+			Exchange:   msg.Exchange,
+			RoutingKey: msg.RoutingKey,
+			Publishing: amqp091.Publishing{
+				ContentType: msg.ContentType,
+				Body:        msg.Payload,
+			},
+		})
+	}
+}
+
+// Enqueued returns every message enqueued so far, in order.
+func (o *MemoryOutbox) Enqueued() []Message {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append([]Message(nil), o.enqueued...)
+}
+
+// Relay publishes the messages enqueued since the last call to publisher,
+// with events in the given content mode, and returns how many were sent.
+func (o *MemoryOutbox) Relay(ctx context.Context, publisher Publisher, mode ContentMode) (int, error) {
+	o.mu.Lock()
+	pending := append([]Message(nil), o.enqueued[o.relayed:]...)
+	o.relayed = len(o.enqueued)
+	o.mu.Unlock()
+
+	for i, msg := range pending {
+		publishing, err := outboxPublishing(msg.ContentType, msg.Body, mode)
+		if err != nil {
+			return i, err
+		}
+		if err := publisher.Publish(ctx, msg.Exchange, msg.RoutingKey, publishing); err != nil {
+			return i, err
+		}
+	}
+	return len(pending), nil
+}
+
+// Reset forgets the recorded messages.
+func (o *MemoryOutbox) Reset() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.enqueued = nil
+	o.relayed = 0
+}
@@ -0,0 +1,95 @@
+// Package messagingtest provides helpers for asserting which events a piece
+// of code published, using a messaging.MemoryBroker in place of RabbitMQ or
+// a messaging.MemoryOutbox in place of the outbox.
+package messagingtest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/CatalinPlesu/user-service/messaging"
+)
+
+// Capture runs fn, typically serving a single request, then relays what it
+// enqueued on outbox into broker, so subscribers see it as they would in
+// production, and returns the events that were published.
+func Capture(t testing.TB, outbox *messaging.MemoryOutbox, broker *messaging.MemoryBroker, fn func()) []messaging.CloudEvent {
+	t.Helper()
+
+	before := len(broker.Published())
+	fn()
+
+	if _, err := outbox.Relay(context.Background(), broker, messaging.ContentModeStructured); err != nil {
+		t.Fatalf("failed to relay outbox: %v", err)
+	}
+	return Events(t, broker.Published()[before:])
+}
+
+// Enqueued runs fn, typically serving a single request, and returns the
+// events it enqueued on outbox.
+func Enqueued(t testing.TB, outbox *messaging.MemoryOutbox, fn func()) []messaging.CloudEvent {
+	t.Helper()
+
+	before := len(outbox.Enqueued())
+	fn()
+	return Events(t, outbox.Enqueued()[before:])
+}
+
+// Events returns the CloudEvents among msgs in publish order, skipping
+// messages that are not events.
+func Events(t testing.TB, msgs []messaging.Message) []messaging.CloudEvent {
+	t.Helper()
+
+	var events []messaging.CloudEvent
+	for _, msg := range msgs {
+		e, err := messaging.CloudEventFromMessage(msg)
+		if errors.Is(err, messaging.ErrNotCloudEvent) {
+			continue
+		} else if err != nil {
+			t.Fatalf("failed to read %s message: %v", msg.RoutingKey, err)
+		}
+		events = append(events, e)
+	}
+	return events
+}
+
+// AssertPublished fails the test unless events are exactly of the given
+// types, in order.
+func AssertPublished(t testing.TB, events []messaging.CloudEvent, types ...string) {
+	t.Helper()
+
+	got := make([]string, len(events))
+	for i, e := range events {
+		got[i] = e.Type
+	}
+
+	if len(got) != len(types) {
+		t.Fatalf("published events %v, want %v", got, types)
+	}
+	for i := range types {
+		if got[i] != types[i] {
+			t.Fatalf("published events %v, want %v", got, types)
+		}
+	}
+}
+
+// Data decodes the data of the only event of the given type into v.
+func Data(t testing.TB, events []messaging.CloudEvent, eventType string, v any) {
+	t.Helper()
+
+	var found []messaging.CloudEvent
+	for _, e := range events {
+		if e.Type == eventType {
+			found = append(found, e)
+		}
+	}
+	if len(found) != 1 {
+		t.Fatalf("found %d %s events, want 1", len(found), eventType)
+	}
+
+	if err := json.Unmarshal(found[0].Data, v); err != nil {
+		t.Fatalf("failed to decode %s event: %v", eventType, err)
+	}
+}
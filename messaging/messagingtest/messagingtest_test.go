@@ -0,0 +1,109 @@
+package messagingtest
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+
+	"github.com/CatalinPlesu/user-service/messaging"
+	"github.com/CatalinPlesu/user-service/repository/jwts"
+)
+
+func TestEnqueued(t *testing.T) {
+	outbox := messaging.NewMemoryOutbox("users")
+	userID := uuid.New()
+	ctx := context.Background()
+
+	events := Enqueued(t, outbox, func() {
+		var enqueuer messaging.Enqueuer = outbox
+		if err := enqueuer.EnqueueLoginRegister(ctx, userID, jwts.Session{ID: "session"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := enqueuer.WithTx(bun.Tx{}).Enqueue(ctx, messaging.UserRegistered{UserID: userID, Username: "alice"}); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	AssertPublished(t, events, messaging.EventUserRegistered)
+
+	var registered messaging.UserRegistered
+	Data(t, events, messaging.EventUserRegistered, &registered)
+	if registered.Username != "alice" {
+		t.Errorf("username = %q, want alice", registered.Username)
+	}
+
+	if got := len(outbox.Enqueued()); got != 2 {
+		t.Errorf("enqueued %d messages, want 2", got)
+	}
+}
+
+func TestMemoryOutboxForget(t *testing.T) {
+	outbox := messaging.NewMemoryOutbox("users")
+	erased, kept := uuid.New(), uuid.New()
+	ctx := context.Background()
+
+	for _, userID := range []uuid.UUID{erased, kept} {
+		if err := outbox.EnqueueLoginRegister(ctx, userID, jwts.Session{ID: "session"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := outbox.Enqueue(ctx, messaging.UserDeleted{UserID: userID, DeletedAt: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := outbox.Forget(ctx, erased); err != nil {
+		t.Fatal(err)
+	}
+
+	events := Events(t, outbox.Enqueued())
+	AssertPublished(t, events, messaging.EventUserDeleted)
+	if events[0].Subject != kept.String() {
+		t.Errorf("kept the event about %s, want %s", events[0].Subject, kept)
+	}
+	if got := len(outbox.Enqueued()); got != 2 {
+		t.Errorf("%d messages left, want 2", got)
+	}
+}
+
+func TestCapture(t *testing.T) {
+	outbox := messaging.NewMemoryOutbox("users")
+	broker := messaging.NewMemoryBroker()
+	userID := uuid.New()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var delivered []string
+	err := broker.Subscribe(ctx, "users", "user.#", func(ctx context.Context, msg messaging.Message) error {
+		delivered = append(delivered, msg.RoutingKey)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := outbox.Enqueue(ctx, messaging.UserRegistered{UserID: userID}); err != nil {
+		t.Fatal(err)
+	}
+	_ = Capture(t, outbox, broker, func() {})
+
+	events := Capture(t, outbox, broker, func() {
+		if err := outbox.EnqueueLoginRegister(ctx, userID, jwts.Session{ID: "session"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := outbox.Enqueue(ctx, messaging.UserDeleted{UserID: userID, DeletedAt: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	AssertPublished(t, events, messaging.EventUserDeleted)
+	if want := []string{messaging.EventUserRegistered, messaging.EventUserDeleted}; !slices.Equal(delivered, want) {
+		t.Errorf("subscriber got %v, want %v", delivered, want)
+	}
+	if got := len(broker.Published()); got != 3 {
+		t.Errorf("published %d messages, want 3", got)
+	}
+}
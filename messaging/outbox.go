@@ -0,0 +1,146 @@
+package messaging
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+
+	"github.com/CatalinPlesu/user-service/model"
+	"github.com/CatalinPlesu/user-service/repository/jwts"
+	"github.com/CatalinPlesu/user-service/repository/outbox"
+	"github.com/CatalinPlesu/user-service/repository/webhook"
+)
+
+// Enqueuer queues messages to be published once the surrounding work has
+// been committed. Handlers depend on it rather than on Outbox, so they can be
+// tested with a MemoryOutbox.
+type Enqueuer interface {
+	Enqueue(ctx context.Context, events ...Event) error
+	EnqueueLoginRegister(ctx context.Context, userID uuid.UUID, session jwts.Session) error
+	// Forget drops every message still queued about the user and returns
+	// the erasure steps that took.
+	Forget(ctx context.Context, userID uuid.UUID) ([]string, error)
+	WithTx(tx bun.Tx) Enqueuer
+}
+
+var (
+	_ Enqueuer = (*Outbox)(nil)
+	_ Enqueuer = (*MemoryOutbox)(nil)
+)
+
+// Outbox enqueues messages for the relay to publish. Events are wrapped in a
+// CloudEvents envelope and go to Exchange with their type as routing key.
+// When Webhooks is set, every event is also queued for the webhook endpoints
+// subscribed to it.
+type Outbox struct {
+	Repo       *outbox.PostgresRepo
+	Webhooks   *webhook.PostgresRepo
+	Exchange   string
+	Source     string
+	SchemaBase string
+}
+
+// WithTx returns an Outbox that writes within tx.
+func (o *Outbox) WithTx(tx bun.Tx) Enqueuer {
+	withTx := *o
+	withTx.Repo = o.Repo.WithTx(tx)
+	if o.Webhooks != nil {
+		withTx.Webhooks = o.Webhooks.WithTx(tx)
+	}
+	return &withTx
+}
+
+func (o *Outbox) Enqueue(ctx context.Context, events ...Event) error {
+	now := time.Now()
+
+	messages := make([]model.OutboxMessage, len(events))
+	for i, event := range events {
+		envelope, msg, err := eventMessage(event, o.Exchange, o.Source, o.SchemaBase, now)
+		if err != nil {
+			return err
+		}
+		messages[i] = msg
+
+		if o.Webhooks != nil {
+			if err := o.Webhooks.EnqueueEvent(ctx, envelope.ID, envelope.Type, msg.Payload); err != nil {
+				return err
+			}
+		}
+	}
+
+	return o.Repo.Insert(ctx, messages...)
+}
+
+// EnqueueLoginRegister enqueues the LoginRegisterMessage on the user_id_jwt
+// queue, kept for consumers that have not moved to events.
+func (o *Outbox) EnqueueLoginRegister(ctx context.Context, userID uuid.UUID, session jwts.Session) error {
+	msg, err := loginRegisterMessage(userID, session)
+	if err != nil {
+		return err
+	}
+	return o.Repo.Insert(ctx, msg)
+}
+
+func (o *Outbox) Forget(ctx context.Context, userID uuid.UUID) ([]string, error) {
+	if _, err := o.Repo.DeleteByAggregate(ctx, userID); err != nil {
+		return nil, err
+	}
+	steps := []string{"outbox"}
+
+	if o.Webhooks != nil {
+		if _, err := o.Webhooks.DeleteBySubject(ctx, userID.String()); err != nil {
+			return nil, err
+		}
+		steps = append(steps, "webhook_deliveries")
+	}
+	return steps, nil
+}
+
+// eventMessage wraps event in a CloudEvents envelope and returns it with the
+// outbox row that publishes it.
+func eventMessage(event Event, exchange, source, schemaBase string, now time.Time) (CloudEvent, model.OutboxMessage, error) {
+	envelope, err := NewCloudEvent(event, source, schemaBase, now)
+	if err != nil {
+		return CloudEvent{}, model.OutboxMessage{}, err
+	}
+
+	body, err := json.Marshal(envelope)
+	if err != nil {
+		return CloudEvent{}, model.OutboxMessage{}, fmt.Errorf("failed to marshal %s event: %w", event.EventType(), err)
+	}
+
+	return envelope, model.OutboxMessage{
+		AggregateID: event.EventUserID(),
+		Exchange:    exchange,
+		RoutingKey:  event.EventType(),
+		ContentType: cloudEventsContentType,
+		Payload:     body,
+	}, nil
+}
+
+// loginRegisterMessage describes session without its token, which must
+// never be stored in the outbox.
+func loginRegisterMessage(userID uuid.UUID, session jwts.Session) (model.OutboxMessage, error) {
+	message := LoginRegisterMessage{
+		UserID:    userID,
+		SessionID: session.ID,
+		IssuedAt:  session.IssuedAt,
+		ExpiresAt: session.ExpiresAt,
+	}
+
+	body, err := json.Marshal(message)
+	if err != nil {
+		return model.OutboxMessage{}, fmt.Errorf("failed to marshal message: %w", err)
+	}
+
+	return model.OutboxMessage{
+		AggregateID: userID,
+		RoutingKey:  LoginRegisterQueue,
+		ContentType: "application/json",
+		Payload:     body,
+	}, nil
+}
@@ -1,92 +1,517 @@
 package messaging
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"sync"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/rabbitmq/amqp091-go"
 )
 
+var (
+	ErrBufferFull = errors.New("RabbitMQ publish buffer is full")
+	ErrNacked     = errors.New("message was nacked by RabbitMQ")
+	ErrClosed     = errors.New("RabbitMQ connection is closed")
+)
+
+type ConnectionState int
+
+const (
+	StateDisconnected ConnectionState = iota
+	StateConnecting
+	StateConnected
+	StateClosed
+)
+
+func (s ConnectionState) String() string {
+	switch s {
+	case StateConnecting:
+		return "connecting"
+	case StateConnected:
+		return "connected"
+	case StateClosed:
+		return "closed"
+	default:
+		return "disconnected"
+	}
+}
+
+// RabbitMQ manages a single connection to the broker. Run keeps it open,
+// reconnecting with exponential back-off and re-declaring every exchange
+// and queue used so far. Publishes are confirmed by the broker; while the
+// connection is down they wait in a bounded buffer.
 type RabbitMQ struct {
-	Connection *amqp091.Connection
-	Channel    *amqp091.Channel
+	URL            string
+	ConfirmTimeout time.Duration
+	MinBackoff     time.Duration
+	MaxBackoff     time.Duration
+
+	pending chan *pendingPublish
+
+	mu          sync.Mutex
+	state       ConnectionState
+	lastError   error
+	connectedAt time.Time
+	conn        *amqp091.Connection
+	channel     *amqp091.Channel
+	exchanges   map[string]bool // Topology to re-declare after reconnecting
+	queues      map[string]bool
+	consumers   []amqpConsumer
+	stop        context.CancelFunc
+}
+
+// amqpConsumer is started on every new connection.
+type amqpConsumer interface {
+	start(conn *amqp091.Connection) error
+}
+
+type pendingPublish struct {
+	ctx        context.Context
+	exchange   string
+	routingKey string
+	msg        amqp091.Publishing
+	done       chan error
+}
+
+// Status is a snapshot of the connection for health checks.
+type Status struct {
+	State       string    `json:"state"`
+	LastError   string    `json:"last_error,omitempty"`
+	ConnectedAt time.Time `json:"connected_at,omitempty"`
+	Buffered    int       `json:"buffered"`
 }
 
+// LoginRegisterMessage announces a new session. It identifies the session by
+// ID rather than carrying the bearer token; JWT is only set while the
+// deployment runs in compatibility mode for consumers that still need it,
+// and is attached by the relay when publishing, never stored in the outbox.
 type LoginRegisterMessage struct {
-	UserID uuid.UUID `json:"user_id"`
-	JWT    string    `json:"jwt"`
+	UserID    uuid.UUID `json:"user_id"`
+	SessionID string    `json:"session_id"`
+	IssuedAt  time.Time `json:"issued_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	JWT       string    `json:"jwt,omitempty"`
 }
 
-func NewRabbitMQ(rabbitMQURL string) (*RabbitMQ, error) {
-	conn, err := amqp091.Dial(rabbitMQURL)
+// LoginRegisterQueue is the queue login and register messages are routed to
+// through the default exchange.
+const LoginRegisterQueue = "user_id_jwt"
+
+// NewRabbitMQ creates a disconnected manager; call Run to connect.
+// bufferSize bounds how many publishes may wait during an outage.
+func NewRabbitMQ(rabbitMQURL string, bufferSize int) *RabbitMQ {
+	return &RabbitMQ{
+		URL:            rabbitMQURL,
+		ConfirmTimeout: 5 * time.Second,
+		MinBackoff:     500 * time.Millisecond,
+		MaxBackoff:     30 * time.Second,
+		pending:        make(chan *pendingPublish, bufferSize),
+		exchanges:      map[string]bool{},
+		queues:         map[string]bool{},
+	}
+}
+
+func (r *RabbitMQ) State() ConnectionState {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.state
+}
+
+func (r *RabbitMQ) Status() Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	status := Status{
+		State:    r.state.String(),
+		Buffered: len(r.pending),
+	}
+	if r.lastError != nil {
+		status.LastError = r.lastError.Error()
+	}
+	if r.state == StateConnected {
+		status.ConnectedAt = r.connectedAt
+	}
+	return status
+}
+
+func (r *RabbitMQ) setState(state ConnectionState, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.state == StateClosed {
+		return
+	}
+	r.state = state
+	if err != nil {
+		r.lastError = err
+	}
+	if state == StateConnected {
+		r.connectedAt = time.Now()
+	}
+}
+
+// Run connects and keeps the connection alive until ctx is done or Close is
+// called. It also drives the publishing of buffered messages.
+func (r *RabbitMQ) Run(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	r.mu.Lock()
+	r.stop = cancel
+	r.mu.Unlock()
+	defer cancel()
+
+	backoff := r.MinBackoff
+	for ctx.Err() == nil {
+		r.setState(StateConnecting, nil)
+
+		closed, err := r.connect()
+		if err != nil {
+			r.setState(StateDisconnected, err)
+			fmt.Println("failed to connect to RabbitMQ, retrying in", backoff, ":", err)
+
+			select {
+			case <-ctx.Done():
+			case <-time.After(backoff):
+			}
+			backoff = min(backoff*2, r.MaxBackoff)
+			continue
+		}
+
+		backoff = r.MinBackoff
+		r.setState(StateConnected, nil)
+		log.Printf("Connected to RabbitMQ")
+
+		err = r.publishLoop(ctx, closed)
+		r.teardown()
+		if ctx.Err() == nil {
+			r.setState(StateDisconnected, err)
+			fmt.Println("lost RabbitMQ connection:", err)
+		}
+	}
+
+	r.setState(StateClosed, nil)
+	r.failPending(ErrClosed)
+}
+
+// connect dials the broker, opens a channel in confirm mode and declares the
+// known topology. The returned channel reports the connection or channel
+// closing.
+func (r *RabbitMQ) connect() (chan *amqp091.Error, error) {
+	conn, err := amqp091.Dial(r.URL)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to RabbitMQ: %w", err)
 	}
 
 	ch, err := conn.Channel()
 	if err != nil {
+		conn.Close()
 		return nil, fmt.Errorf("failed to open a channel: %w", err)
 	}
 
-	return &RabbitMQ{
-		Connection: conn,
-		Channel:    ch,
-	}, nil
+	if err := ch.Confirm(false); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to enable publisher confirms: %w", err)
+	}
+
+	r.mu.Lock()
+	r.conn = conn
+	r.channel = ch
+	exchanges := make([]string, 0, len(r.exchanges))
+	for name := range r.exchanges {
+		exchanges = append(exchanges, name)
+	}
+	queues := make([]string, 0, len(r.queues))
+	for name := range r.queues {
+		queues = append(queues, name)
+	}
+	consumers := append([]amqpConsumer(nil), r.consumers...)
+	r.mu.Unlock()
+
+	for _, name := range exchanges {
+		if err := declareExchange(ch, name); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	for _, name := range queues {
+		if err := declareQueue(ch, name, nil); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	for _, c := range consumers {
+		if err := c.start(conn); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	closed := make(chan *amqp091.Error, 2)
+	conn.NotifyClose(closed)
+	ch.NotifyClose(closed)
+	return closed, nil
 }
 
-func (r *RabbitMQ) PublishLoginRegisterMessage(queueName string, userID uuid.UUID, jwt string) error {
-	// Construct the message
-	message := LoginRegisterMessage{
-		UserID: userID,
-		JWT:    jwt,
+func (r *RabbitMQ) teardown() {
+	r.mu.Lock()
+	conn := r.conn
+	r.conn = nil
+	r.channel = nil
+	r.mu.Unlock()
+
+	if conn != nil && !conn.IsClosed() {
+		conn.Close()
 	}
+}
+
+func (r *RabbitMQ) publishLoop(ctx context.Context, closed chan *amqp091.Error) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case amqpErr := <-closed:
+			if amqpErr == nil {
+				return errors.New("connection closed")
+			}
+			return amqpErr
+		case p := <-r.pending:
+			if p.ctx.Err() != nil {
+				p.done <- p.ctx.Err()
+				continue
+			}
+
+			err := r.publishConfirmed(p)
+			var amqpErr *amqp091.Error
+			if errors.As(err, &amqpErr) || errors.Is(err, amqp091.ErrClosed) {
+				// The channel is gone; keep the message for the next
+				// connection rather than failing it.
+				r.requeue(p)
+				return err
+			}
+			p.done <- err
+		}
+	}
+}
 
-	// Marshal the message into JSON
-	body, err := json.Marshal(message)
+func (r *RabbitMQ) publishConfirmed(p *pendingPublish) error {
+	r.mu.Lock()
+	ch := r.channel
+	r.mu.Unlock()
+
+	if err := r.declareFor(ch, p.exchange, p.routingKey); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(p.ctx, r.ConfirmTimeout)
+	defer cancel()
+
+	confirm, err := ch.PublishWithDeferredConfirmWithContext(ctx,
+		p.exchange,   // exchange
+		p.routingKey, // routing key (queue name)
+		false,        // mandatory
+		false,        // immediate
+		p.msg,
+	)
 	if err != nil {
-		return fmt.Errorf("failed to marshal message: %w", err)
+		return fmt.Errorf("failed to publish message: %w", err)
+	}
+
+	acked, err := confirm.WaitContext(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to confirm message: %w", err)
+	}
+	if !acked {
+		return ErrNacked
+	}
+
+	log.Printf("Published message to %s/%s (%d bytes)", p.exchange, p.routingKey, len(p.msg.Body))
+	return nil
+}
+
+// requeue puts a message back after a failed attempt. If the buffer filled
+// up in the meantime the message is failed instead.
+func (r *RabbitMQ) requeue(p *pendingPublish) {
+	select {
+	case r.pending <- p:
+	default:
+		p.done <- ErrBufferFull
+	}
+}
+
+func (r *RabbitMQ) failPending(err error) {
+	for {
+		select {
+		case p := <-r.pending:
+			p.done <- err
+		default:
+			return
+		}
+	}
+}
+
+// declareFor declares the exchange, or for the default exchange the queue
+// named by the routing key, the first time it is used.
+func (r *RabbitMQ) declareFor(ch *amqp091.Channel, exchange, routingKey string) error {
+	r.mu.Lock()
+	known := r.exchanges[exchange]
+	if exchange == "" {
+		known = r.queues[routingKey]
+	}
+	r.mu.Unlock()
+
+	if known {
+		return nil
 	}
 
-	// Declare the queue (optional but recommended)
-	_, err = r.Channel.QueueDeclare(
-		queueName, // queue name
-		true,      // durable
-		false,     // delete when unused
-		false,     // exclusive
-		false,     // no-wait
-		nil,       // arguments
+	if exchange != "" {
+		if err := declareExchange(ch, exchange); err != nil {
+			return err
+		}
+	} else if err := declareQueue(ch, routingKey, nil); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	if exchange != "" {
+		r.exchanges[exchange] = true
+	} else {
+		r.queues[routingKey] = true
+	}
+	r.mu.Unlock()
+	return nil
+}
+
+// declareExchange declares a durable topic exchange.
+func declareExchange(ch *amqp091.Channel, name string) error {
+	err := ch.ExchangeDeclare(
+		name,    // name
+		"topic", // kind
+		true,    // durable
+		false,   // auto-deleted
+		false,   // internal
+		false,   // no-wait
+		nil,     // arguments
 	)
 	if err != nil {
-		return fmt.Errorf("failed to declare queue: %w", err)
+		return fmt.Errorf("failed to declare exchange: %w", err)
 	}
+	return nil
+}
 
-	// Publish the message to the queue
-	err = r.Channel.Publish(
-		"",        // exchange
-		queueName, // routing key (queue name)
-		false,     // mandatory
-		false,     // immediate
-		amqp091.Publishing{
-			ContentType: "application/json",
-			Body:        body,
-		},
+func declareQueue(ch *amqp091.Channel, name string, args amqp091.Table) error {
+	_, err := ch.QueueDeclare(
+		name,  // queue name
+		true,  // durable
+		false, // delete when unused
+		false, // exclusive
+		false, // no-wait
+		args,  // arguments
 	)
 	if err != nil {
-		return fmt.Errorf("failed to publish message: %w", err)
+		return fmt.Errorf("failed to declare queue: %w", err)
+	}
+	return nil
+}
+
+// Publish sends msg to exchange with routingKey and waits for the broker to
+// confirm it. Named exchanges are declared as topic exchanges. An empty
+// exchange means the default exchange, where the routing key names a queue,
+// so that queue is declared instead. While disconnected the message waits in
+// the buffer until the connection is back or ctx is done.
+func (r *RabbitMQ) Publish(ctx context.Context, exchange, routingKey string, msg amqp091.Publishing) error {
+	if r.State() == StateClosed {
+		return ErrClosed
+	}
+
+	p := &pendingPublish{
+		ctx:        ctx,
+		exchange:   exchange,
+		routingKey: routingKey,
+		msg:        msg,
+		done:       make(chan error, 1),
+	}
+
+	select {
+	case r.pending <- p:
+	default:
+		return ErrBufferFull
+	}
+
+	select {
+	case err := <-p.done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Consume starts c on the current connection, if there is one, and again
+// after every reconnect.
+func (r *RabbitMQ) Consume(c *Consumer) error {
+	return r.addConsumer(c)
+}
+
+// Subscribe implements Subscriber. The subscription survives reconnects
+// until ctx is done.
+func (r *RabbitMQ) Subscribe(ctx context.Context, exchange, bindingKey string, handler MessageHandler) error {
+	s := &subscription{
+		ctx:        ctx,
+		exchange:   exchange,
+		bindingKey: bindingKey,
+		handler:    handler,
+	}
+	if err := r.addConsumer(s); err != nil {
+		return err
 	}
 
-	log.Printf("Published message to queue %s: %s", queueName, body)
+	go func() {
+		<-ctx.Done()
+		r.removeConsumer(s)
+	}()
 	return nil
 }
 
+func (r *RabbitMQ) addConsumer(c amqpConsumer) error {
+	r.mu.Lock()
+	r.consumers = append(r.consumers, c)
+	conn := r.conn
+	r.mu.Unlock()
+
+	if conn != nil && !conn.IsClosed() {
+		return c.start(conn)
+	}
+	return nil
+}
+
+func (r *RabbitMQ) removeConsumer(c amqpConsumer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, other := range r.consumers {
+		if other == c {
+			r.consumers = append(r.consumers[:i], r.consumers[i+1:]...)
+			return
+		}
+	}
+}
+
 func (r *RabbitMQ) Close() error {
-	if err := r.Channel.Close(); err != nil {
-		return fmt.Errorf("failed to close channel: %w", err)
+	r.mu.Lock()
+	stop := r.stop
+	conn := r.conn
+	r.state = StateClosed
+	r.mu.Unlock()
+
+	if stop != nil {
+		stop()
 	}
-	if err := r.Connection.Close(); err != nil {
-		return fmt.Errorf("failed to close connection: %w", err)
+	if conn != nil && !conn.IsClosed() {
+		if err := conn.Close(); err != nil {
+			return fmt.Errorf("failed to close connection: %w", err)
+		}
 	}
 	return nil
 }
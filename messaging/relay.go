@@ -0,0 +1,215 @@
+package messaging
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rabbitmq/amqp091-go"
+
+	"github.com/CatalinPlesu/user-service/model"
+	"github.com/CatalinPlesu/user-service/repository/eventstream"
+	"github.com/CatalinPlesu/user-service/repository/jwts"
+	"github.com/CatalinPlesu/user-service/repository/outbox"
+)
+
+// Relay publishes outbox rows through Publisher, normally RabbitMQ. A failed
+// message is retried with exponential back-off and blocks later messages for
+// the same aggregate, so consumers see each user's messages in the order they
+// were written. After MaxAttempts it is dead-lettered instead. Published
+// events are also appended to Stream, if set.
+//
+// When Sessions is set, login/register messages get the session's bearer
+// token attached as they are published. This is the compatibility mode for
+// consumers that still need it; the token is never stored in the outbox.
+type Relay struct {
+	Repo        *outbox.PostgresRepo
+	Publisher   Publisher
+	ContentMode ContentMode
+	Interval    time.Duration
+	BatchSize   int
+	MaxAttempts int
+	MaxDelay    time.Duration
+	Retention   time.Duration
+	Timeout     time.Duration // How long to wait for one message to be confirmed
+	Stream      *eventstream.RedisRepo
+	Sessions    SessionStore
+	Now         func() time.Time
+}
+
+// SessionStore looks up active sessions, see jwts.RedisRepo.
+type SessionStore interface {
+	Session(ctx context.Context, userID uuid.UUID, sessionID string) (jwts.Session, error)
+}
+
+func NewRelay(repo *outbox.PostgresRepo, publisher Publisher, mode ContentMode) *Relay {
+	return &Relay{
+		Repo:        repo,
+		Publisher:   publisher,
+		ContentMode: mode,
+		Interval:    time.Second,
+		BatchSize:   100,
+		MaxAttempts: 20,
+		MaxDelay:    5 * time.Minute,
+		Retention:   7 * 24 * time.Hour,
+		Timeout:     10 * time.Second,
+		Now:         time.Now,
+	}
+}
+
+func (r *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+
+	lastCleanup := time.Time{}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if _, err := r.RelayOnce(ctx); err != nil && !errors.Is(err, context.Canceled) {
+			fmt.Println("failed to relay outbox:", err)
+		}
+
+		if now := r.Now(); now.Sub(lastCleanup) > time.Hour {
+			lastCleanup = now
+			if _, err := r.Repo.DeleteSentBefore(ctx, now.Add(-r.Retention)); err != nil {
+				fmt.Println("failed to clean up outbox:", err)
+			}
+		}
+	}
+}
+
+// RelayOnce publishes one batch of due messages and returns how many were
+// sent. Nothing is attempted while the publisher is disconnected, so an
+// outage does not use up the messages' retries.
+func (r *Relay) RelayOnce(ctx context.Context) (int, error) {
+	if r.Publisher == nil {
+		return 0, nil
+	}
+	if c, ok := r.Publisher.(interface{ State() ConnectionState }); ok && c.State() != StateConnected {
+		return 0, nil
+	}
+
+	// Messages are claimed for long enough to publish the whole batch, and
+	// published after the claim is committed, so no transaction stays open
+	// while waiting for the broker.
+	lease := r.Timeout * time.Duration(r.BatchSize)
+	messages, err := r.Repo.ClaimDue(ctx, r.Now().UTC(), lease, r.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	var sent int
+	for _, msg := range messages {
+		err := r.publish(ctx, msg)
+		if errors.Is(err, context.Canceled) {
+			return sent, err
+		}
+
+		now := r.Now().UTC()
+		switch {
+		case err == nil:
+			if err := r.Repo.MarkSent(ctx, msg.ID, now); err != nil {
+				return sent, err
+			}
+			sent++
+			r.appendToStream(ctx, msg)
+		case msg.Attempts+1 >= r.MaxAttempts:
+			fmt.Println("dead-lettering outbox message", msg.ID, "after", msg.Attempts+1, "attempts:", jwts.Redact(err.Error()))
+			if err := r.Repo.MarkDead(ctx, msg.ID, err, now); err != nil {
+				return sent, err
+			}
+		default:
+			if err := r.Repo.MarkFailed(ctx, msg.ID, err, now.Add(r.backoff(msg.Attempts))); err != nil {
+				return sent, err
+			}
+		}
+	}
+	return sent, nil
+}
+
+func (r *Relay) publish(ctx context.Context, msg model.OutboxMessage) error {
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	body := msg.Payload
+	if r.Sessions != nil && msg.RoutingKey == LoginRegisterQueue {
+		var err error
+		if body, err = r.attachToken(ctx, body); err != nil {
+			return err
+		}
+	}
+
+	publishing, err := outboxPublishing(msg.ContentType, body, r.ContentMode)
+	if err != nil {
+		return err
+	}
+	return r.Publisher.Publish(ctx, msg.Exchange, msg.RoutingKey, publishing)
+}
+
+// outboxPublishing maps a stored outbox payload onto the message that is
+// published, with events in the given content mode.
+func outboxPublishing(contentType string, payload []byte, mode ContentMode) (amqp091.Publishing, error) {
+	if contentType != cloudEventsContentType {
+		return amqp091.Publishing{
+			ContentType:  contentType,
+			DeliveryMode: amqp091.Persistent,
+			Body:         payload,
+		}, nil
+	}
+
+	var envelope CloudEvent
+	if err := json.Unmarshal(payload, &envelope); err != nil {
+		return amqp091.Publishing{}, fmt.Errorf("failed to decode cloud event: %w", err)
+	}
+	return envelope.Publishing(mode)
+}
+
+// attachToken adds the session's bearer token to a LoginRegisterMessage. A
+// session that was revoked or has expired by the time the message is
+// published is announced without its token.
+func (r *Relay) attachToken(ctx context.Context, payload []byte) ([]byte, error) {
+	var message LoginRegisterMessage
+	if err := json.Unmarshal(payload, &message); err != nil {
+		return nil, fmt.Errorf("failed to decode login register message: %w", err)
+	}
+
+	session, err := r.Sessions.Session(ctx, message.UserID, message.SessionID)
+	if errors.Is(err, jwts.ErrJWTNotFound) {
+		return payload, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to look up session: %w", err)
+	}
+
+	message.JWT = session.Token
+	return json.Marshal(message)
+}
+
+// appendToStream feeds a published event to the live stream. The stream is
+// best effort, so failures are only logged.
+func (r *Relay) appendToStream(ctx context.Context, msg model.OutboxMessage) {
+	if r.Stream == nil || msg.ContentType != cloudEventsContentType {
+		return
+	}
+
+	if err := r.Stream.Append(ctx, msg.RoutingKey, msg.AggregateID, msg.Payload); err != nil {
+		fmt.Println("failed to append event to stream:", err)
+	}
+}
+
+func (r *Relay) backoff(attempts int) time.Duration {
+	delay := r.Interval
+	for i := 0; i < attempts; i++ {
+		delay *= 2
+		if delay >= r.MaxDelay {
+			return r.MaxDelay
+		}
+	}
+	return delay
+}
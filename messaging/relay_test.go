@@ -0,0 +1,62 @@
+package messaging
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/CatalinPlesu/user-service/repository/jwts"
+)
+
+type fakeSessions map[string]jwts.Session
+
+func (f fakeSessions) Session(ctx context.Context, userID uuid.UUID, sessionID string) (jwts.Session, error) {
+	session, ok := f[sessionID]
+	if !ok {
+		return jwts.Session{}, jwts.ErrJWTNotFound
+	}
+	return session, nil
+}
+
+func TestRelayAttachToken(t *testing.T) {
+	userID := uuid.New()
+	stored, err := loginRegisterMessage(userID, jwts.Session{Token: "token", ID: "active"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	revoked, err := loginRegisterMessage(userID, jwts.Session{Token: "token", ID: "revoked"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var message LoginRegisterMessage
+	if err := json.Unmarshal(stored.Payload, &message); err != nil {
+		t.Fatal(err)
+	}
+	if message.JWT != "" {
+		t.Fatalf("outbox payload carries the token: %s", stored.Payload)
+	}
+
+	r := &Relay{Sessions: fakeSessions{"active": {Token: "token", ID: "active"}}}
+
+	body, err := r.attachToken(context.Background(), stored.Payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(body, &message); err != nil {
+		t.Fatal(err)
+	}
+	if message.JWT != "token" || message.SessionID != "active" || message.UserID != userID {
+		t.Errorf("published message = %+v, want the active session with its token", message)
+	}
+
+	body, err = r.attachToken(context.Background(), revoked.Payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != string(revoked.Payload) {
+		t.Errorf("revoked session published as %s, want %s", body, revoked.Payload)
+	}
+}
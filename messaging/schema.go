@@ -0,0 +1,191 @@
+package messaging
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// EventSchema pairs an event type with the version of its data schema. The
+// version must be bumped whenever the event struct changes in a way
+// CompareSchemas reports as incompatible.
+type EventSchema struct {
+	Type    string
+	Version int
+	Example Event
+}
+
+var EventSchemas = []EventSchema{
+	{Type: EventUserRegistered, Version: 1, Example: UserRegistered{}},
+	{Type: EventUserLoggedIn, Version: 1, Example: UserLoggedIn{}},
+	{Type: EventUserProfileUpdated, Version: 1, Example: UserProfileUpdated{}},
+	{Type: EventUserEmailChanged, Version: 1, Example: UserEmailChanged{}},
+	{Type: EventUserDeleted, Version: 1, Example: UserDeleted{}},
+	{Type: EventUserSessionsRevoked, Version: 1, Example: UserSessionsRevoked{}},
+	{Type: EventUserDeactivated, Version: 1, Example: UserDeactivated{}},
+	{Type: EventUserRestored, Version: 1, Example: UserRestored{}},
+	{Type: EventUserPurged, Version: 1, Example: UserPurged{}},
+	{Type: EventUserExportReady, Version: 1, Example: UserExportReady{}},
+	{Type: EventUserErased, Version: 1, Example: UserErased{}},
+	{Type: EventUserBlocked, Version: 1, Example: UserBlocked{}},
+	{Type: EventUserUnblocked, Version: 1, Example: UserUnblocked{}},
+	{Type: EventContactAdded, Version: 1, Example: ContactAdded{}},
+	{Type: EventContactRemoved, Version: 1, Example: ContactRemoved{}},
+}
+
+func SchemaVersion(eventType string) int {
+	for _, s := range EventSchemas {
+		if s.Type == eventType {
+			return s.Version
+		}
+	}
+	return 0
+}
+
+func SchemaURL(base, eventType string, version int) string {
+	return fmt.Sprintf("%s/events/schemas/%s/v%d.json", strings.TrimRight(base, "/"), eventType, version)
+}
+
+// JSONSchema is the subset of JSON Schema (draft 2020-12) produced for event
+// payloads.
+type JSONSchema struct {
+	Schema               string                 `json:"$schema,omitempty"`
+	ID                   string                 `json:"$id,omitempty"`
+	Title                string                 `json:"title,omitempty"`
+	Type                 string                 `json:"type"`
+	Format               string                 `json:"format,omitempty"`
+	Properties           map[string]*JSONSchema `json:"properties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	Items                *JSONSchema            `json:"items,omitempty"`
+	AdditionalProperties *bool                  `json:"additionalProperties,omitempty"`
+}
+
+var (
+	uuidType = reflect.TypeOf(uuid.UUID{})
+	timeType = reflect.TypeOf(time.Time{})
+)
+
+// GenerateSchema derives the JSON Schema of an event's payload from its Go
+// type and json tags.
+func GenerateSchema(s EventSchema) *JSONSchema {
+	schema := schemaFor(reflect.TypeOf(s.Example))
+	schema.Schema = "https://json-schema.org/draft/2020-12/schema"
+	schema.Title = fmt.Sprintf("%s v%d", s.Type, s.Version)
+	return schema
+}
+
+func schemaFor(t reflect.Type) *JSONSchema {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t {
+	case uuidType:
+		return &JSONSchema{Type: "string", Format: "uuid"}
+	case timeType:
+		return &JSONSchema{Type: "string", Format: "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return &JSONSchema{Type: "string"}
+	case reflect.Bool:
+		return &JSONSchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &JSONSchema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &JSONSchema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		return &JSONSchema{Type: "array", Items: schemaFor(t.Elem())}
+	case reflect.Map:
+		return &JSONSchema{Type: "object"}
+	case reflect.Struct:
+		schema := &JSONSchema{Type: "object", Properties: map[string]*JSONSchema{}}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+
+			name, opts, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+
+			schema.Properties[name] = schemaFor(field.Type)
+			if !strings.Contains(opts, "omitempty") {
+				schema.Required = append(schema.Required, name)
+			}
+		}
+		sort.Strings(schema.Required)
+		return schema
+	default:
+		return &JSONSchema{Type: "string"}
+	}
+}
+
+// CompareSchemas lists the changes from old to current that would break a
+// consumer written against old: removed or retyped properties, and
+// properties that are no longer required, since consumers may rely on
+// them being present. Adding properties, required or not, is allowed.
+func CompareSchemas(old, current *JSONSchema) []string {
+	return compareSchemas("", old, current)
+}
+
+func compareSchemas(path string, old, current *JSONSchema) []string {
+	if path == "" {
+		path = "$"
+	}
+
+	if old.Type != current.Type || old.Format != current.Format {
+		return []string{fmt.Sprintf("%s changed type from %s to %s", path, describe(old), describe(current))}
+	}
+
+	var problems []string
+	if old.Items != nil && current.Items != nil {
+		problems = append(problems, compareSchemas(path+"[]", old.Items, current.Items)...)
+	}
+
+	currentRequired := map[string]bool{}
+	for _, name := range current.Required {
+		currentRequired[name] = true
+	}
+
+	names := make([]string, 0, len(old.Properties))
+	for name := range old.Properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		currentProp, ok := current.Properties[name]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%s.%s was removed", path, name))
+			continue
+		}
+		problems = append(problems, compareSchemas(path+"."+name, old.Properties[name], currentProp)...)
+	}
+
+	for _, name := range old.Required {
+		if _, ok := current.Properties[name]; ok && !currentRequired[name] {
+			problems = append(problems, fmt.Sprintf("%s.%s is no longer required", path, name))
+		}
+	}
+
+	return problems
+}
+
+func describe(s *JSONSchema) string {
+	if s.Format != "" {
+		return s.Type + "/" + s.Format
+	}
+	return s.Type
+}
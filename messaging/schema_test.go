@@ -0,0 +1,102 @@
+package messaging
+
+import (
+	"errors"
+	"io/fs"
+	"slices"
+	"testing"
+)
+
+// TestPublishedSchemasCompatible fails when an event struct changed in a way
+// that needs a new schema version, or when the schema of a version was
+// never published; run go run ./cmd/event-schemas to write it.
+func TestPublishedSchemasCompatible(t *testing.T) {
+	published, err := fs.Sub(publishedSchemas, "schemas")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, s := range EventSchemas {
+		if _, err := PublishedSchema(s.Type, s.Version); errors.Is(err, ErrSchemaNotExist) {
+			t.Errorf("%s v%d has no published schema", s.Type, s.Version)
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	problems, err := CheckSchemas(published)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, problem := range problems {
+		t.Errorf("incompatible change, bump the schema version: %s", problem)
+	}
+}
+
+func TestCompareSchemas(t *testing.T) {
+	str := func() *JSONSchema { return &JSONSchema{Type: "string"} }
+	object := func(required []string, names ...string) *JSONSchema {
+		schema := &JSONSchema{Type: "object", Properties: map[string]*JSONSchema{}, Required: required}
+		for _, name := range names {
+			schema.Properties[name] = str()
+		}
+		return schema
+	}
+
+	tests := []struct {
+		name     string
+		old      *JSONSchema
+		current  *JSONSchema
+		problems []string
+	}{
+		{
+			name:    "unchanged",
+			old:     object([]string{"a"}, "a", "b"),
+			current: object([]string{"a"}, "a", "b"),
+		},
+		{
+			name:    "optional property added",
+			old:     object([]string{"a"}, "a"),
+			current: object([]string{"a"}, "a", "b"),
+		},
+		{
+			name:    "required property added",
+			old:     object([]string{"a"}, "a"),
+			current: object([]string{"a", "b"}, "a", "b"),
+		},
+		{
+			name:    "optional property became required",
+			old:     object([]string{"a"}, "a", "b"),
+			current: object([]string{"a", "b"}, "a", "b"),
+		},
+		{
+			name:     "required property became optional",
+			old:      object([]string{"a", "b"}, "a", "b"),
+			current:  object([]string{"a"}, "a", "b"),
+			problems: []string{"$.b is no longer required"},
+		},
+		{
+			name:     "property removed",
+			old:      object([]string{"a", "b"}, "a", "b"),
+			current:  object([]string{"a"}, "a"),
+			problems: []string{"$.b was removed"},
+		},
+		{
+			name: "property retyped",
+			old:  object(nil, "a"),
+			current: &JSONSchema{Type: "object", Properties: map[string]*JSONSchema{
+				"a": {Type: "string", Format: "uuid"},
+			}},
+			problems: []string{"$.a changed type from string to string/uuid"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			problems := CompareSchemas(tt.old, tt.current)
+			if !slices.Equal(problems, tt.problems) {
+				t.Errorf("CompareSchemas() = %q, want %q", problems, tt.problems)
+			}
+		})
+	}
+}
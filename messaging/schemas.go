@@ -0,0 +1,58 @@
+package messaging
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+)
+
+// Published schemas, one file per event type and version, written by
+// cmd/event-schemas. Old versions stay here so consumers can still fetch
+// them and so changes can be checked against what was released.
+//
+//go:embed schemas/*.json
+var publishedSchemas embed.FS
+
+var ErrSchemaNotExist = errors.New("event schema does not exist")
+
+func SchemaFileName(eventType string, version int) string {
+	return fmt.Sprintf("%s.v%d.json", eventType, version)
+}
+
+// PublishedSchema returns a released schema document as stored.
+func PublishedSchema(eventType string, version int) ([]byte, error) {
+	data, err := fs.ReadFile(publishedSchemas, "schemas/"+SchemaFileName(eventType, version))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrSchemaNotExist
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read event schema: %w", err)
+	}
+	return data, nil
+}
+
+// CheckSchemas compares every current event struct against the published
+// schema of the same version, returning the incompatible changes found.
+// Event types whose version has no published schema yet are skipped.
+func CheckSchemas(published fs.FS) ([]string, error) {
+	var problems []string
+	for _, s := range EventSchemas {
+		data, err := fs.ReadFile(published, SchemaFileName(s.Type, s.Version))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to read event schema: %w", err)
+		}
+
+		var old JSONSchema
+		if err := json.Unmarshal(data, &old); err != nil {
+			return nil, fmt.Errorf("failed to decode %s schema: %w", s.Type, err)
+		}
+
+		for _, problem := range CompareSchemas(&old, GenerateSchema(s)) {
+			problems = append(problems, fmt.Sprintf("%s v%d: %s", s.Type, s.Version, problem))
+		}
+	}
+	return problems, nil
+}
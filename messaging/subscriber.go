@@ -0,0 +1,128 @@
+package messaging
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// subscription is a RabbitMQ Subscribe call. On a named exchange it
+// consumes from its own exclusive queue bound with the binding key, so
+// every subscriber sees every matching message; on the default exchange it
+// consumes from the named queue.
+type subscription struct {
+	ctx        context.Context
+	exchange   string
+	bindingKey string
+	handler    MessageHandler
+}
+
+func (s *subscription) start(conn *amqp091.Connection) error {
+	if s.ctx.Err() != nil {
+		return nil
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return fmt.Errorf("failed to open a channel: %w", err)
+	}
+
+	queue := s.bindingKey
+	if s.exchange == "" {
+		err = declareQueue(ch, queue, nil)
+	} else {
+		queue, err = s.declareBound(ch)
+	}
+	if err != nil {
+		ch.Close()
+		return err
+	}
+
+	deliveries, err := ch.Consume(
+		queue, // queue
+		"",    // consumer
+		false, // auto-ack
+		false, // exclusive
+		false, // no-local
+		false, // no-wait
+		nil,   // args
+	)
+	if err != nil {
+		ch.Close()
+		return fmt.Errorf("failed to consume %s: %w", queue, err)
+	}
+
+	go func() {
+		defer ch.Close()
+		for {
+			select {
+			case <-s.ctx.Done():
+				return
+			case d, ok := <-deliveries:
+				if !ok {
+					return
+				}
+				s.process(d)
+			}
+		}
+	}()
+	return nil
+}
+
+func (s *subscription) declareBound(ch *amqp091.Channel) (string, error) {
+	if err := declareExchange(ch, s.exchange); err != nil {
+		return "", err
+	}
+
+	q, err := ch.QueueDeclare(
+		"",    // server-named
+		false, // durable
+		true,  // delete when unused
+		true,  // exclusive
+		false, // no-wait
+		nil,   // arguments
+	)
+	if err != nil {
+		return "", fmt.Errorf("failed to declare queue: %w", err)
+	}
+
+	if err := ch.QueueBind(q.Name, s.bindingKey, s.exchange, false, nil); err != nil {
+		return "", fmt.Errorf("failed to bind queue: %w", err)
+	}
+	return q.Name, nil
+}
+
+func (s *subscription) process(d amqp091.Delivery) {
+	err := s.handler(s.ctx, Message{
+		Exchange:   d.Exchange,
+		RoutingKey: d.RoutingKey,
+		Publishing: amqp091.Publishing{
+			Headers:         d.Headers,
+			ContentType:     d.ContentType,
+			ContentEncoding: d.ContentEncoding,
+			DeliveryMode:    d.DeliveryMode,
+			Priority:        d.Priority,
+			CorrelationId:   d.CorrelationId,
+			ReplyTo:         d.ReplyTo,
+			Expiration:      d.Expiration,
+			MessageId:       d.MessageId,
+			Timestamp:       d.Timestamp,
+			Type:            d.Type,
+			UserId:          d.UserId,
+			AppId:           d.AppId,
+			Body:            d.Body,
+		},
+	})
+	if err != nil {
+		fmt.Println("failed to handle", d.RoutingKey, "message:", err)
+		if err := d.Nack(false, false); err != nil {
+			fmt.Println("failed to reject message:", err)
+		}
+		return
+	}
+
+	if err := d.Ack(false); err != nil {
+		fmt.Println("failed to ack message:", err)
+	}
+}
@@ -0,0 +1,51 @@
+package messaging
+
+import "strings"
+
+// topicMatches reports whether routingKey matches bindingKey the way a
+// RabbitMQ topic exchange does: keys are dot-separated words, "*" matches
+// exactly one word and "#" matches zero or more words.
+func topicMatches(bindingKey, routingKey string) bool {
+	return matchWords(topicWords(bindingKey), topicWords(routingKey))
+}
+
+// topicWords splits a key into words. The empty key has no words at all,
+// as in RabbitMQ, so it is matched by "#" but not by "*".
+func topicWords(key string) []string {
+	if key == "" {
+		return nil
+	}
+	return strings.Split(key, ".")
+}
+
+func matchWords(pattern, words []string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case "#":
+			rest := pattern[1:]
+			for len(rest) > 0 && rest[0] == "#" {
+				rest = rest[1:]
+			}
+			if len(rest) == 0 {
+				return true
+			}
+			for i := 0; i <= len(words); i++ {
+				if matchWords(rest, words[i:]) {
+					return true
+				}
+			}
+			return false
+		case "*":
+			if len(words) == 0 {
+				return false
+			}
+		default:
+			if len(words) == 0 || words[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern = pattern[1:]
+		words = words[1:]
+	}
+	return len(words) == 0
+}
@@ -0,0 +1,54 @@
+package messaging
+
+import "testing"
+
+func TestTopicMatches(t *testing.T) {
+	tests := []struct {
+		bindingKey string
+		routingKey string
+		want       bool
+	}{
+		{"user.registered", "user.registered", true},
+		{"user.registered", "user.deleted", false},
+		{"user.registered", "user", false},
+		{"user", "user.registered", false},
+
+		{"user.*", "user.registered", true},
+		{"user.*", "user", false},
+		{"user.*", "user.session.revoked", false},
+		{"*.registered", "user.registered", true},
+		{"*", "user", true},
+		{"*", "", false},
+
+		{"user.#", "user.registered", true},
+		{"user.#", "user.session.revoked", true},
+		{"user.#", "user", true}, // "#" matches zero words
+		{"user.#", "contact.requested", false},
+		{"#.revoked", "user.session.revoked", true},
+		{"#.revoked", "revoked", true},
+		{"user.#.revoked", "user.revoked", true},
+		{"user.#.revoked", "user.session.token.revoked", true},
+		{"user.#.revoked", "user.session.deleted", false},
+		{"#", "user.session.revoked", true},
+		{"#", "", true},
+		{"#.#", "user", true},
+		{"#.*", "", false},
+		{"#.*", "user.registered", true},
+
+		// Empty words are words like any other.
+		{"user..registered", "user..registered", true},
+		{"user.*.registered", "user..registered", true},
+		{"user.registered", "user..registered", false},
+		{"*", ".", false},
+		{"*.*", ".", true},
+		{"#", ".", true},
+		{"", "", true},
+		{"", "user", false},
+	}
+
+	for _, tt := range tests {
+		if got := topicMatches(tt.bindingKey, tt.routingKey); got != tt.want {
+			t.Errorf("topicMatches(%q, %q) = %v, want %v", tt.bindingKey, tt.routingKey, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package messaging
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/CatalinPlesu/user-service/model"
+	"github.com/CatalinPlesu/user-service/repository/webhook"
+)
+
+// Headers sent with every webhook delivery. The signature is an HMAC-SHA256
+// over the timestamp, a dot and the body, keyed with the endpoint's secret.
+const (
+	WebhookIDHeader        = "X-Webhook-ID"
+	WebhookEventHeader     = "X-Webhook-Event"
+	WebhookTimestampHeader = "X-Webhook-Timestamp"
+	WebhookSignatureHeader = "X-Webhook-Signature"
+
+	webhookSignaturePrefix = "sha256="
+)
+
+var ErrInvalidSignature = errors.New("invalid webhook signature")
+
+func SignWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+	return webhookSignaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhook checks a delivery's signature and rejects timestamps more
+// than tolerance away from now, which limits replays.
+func VerifyWebhook(secret, signature string, timestamp int64, body []byte, tolerance time.Duration, now time.Time) error {
+	sent := time.Unix(timestamp, 0)
+	if now.Sub(sent) > tolerance || sent.Sub(now) > tolerance {
+		return fmt.Errorf("%w: timestamp outside tolerance", ErrInvalidSignature)
+	}
+	if !hmac.Equal([]byte(signature), []byte(SignWebhook(secret, timestamp, body))) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// WebhookDispatcher sends pending webhook deliveries. A failed delivery is
+// retried with exponential back-off up to MaxAttempts, and an endpoint that
+// fails DisableAfter times in a row is disabled until an administrator
+// enables it again.
+type WebhookDispatcher struct {
+	Repo         *webhook.PostgresRepo
+	Client       *http.Client
+	Interval     time.Duration
+	BatchSize    int
+	Lease        time.Duration // How long a claimed delivery is hidden from other dispatchers
+	MaxAttempts  int
+	MaxDelay     time.Duration
+	DisableAfter int
+	Retention    time.Duration
+	Now          func() time.Time
+}
+
+func NewWebhookDispatcher(repo *webhook.PostgresRepo) *WebhookDispatcher {
+	return &WebhookDispatcher{
+		Repo: repo,
+		Client: &http.Client{
+			Timeout: 10 * time.Second,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		Interval:     time.Second,
+		BatchSize:    50,
+		Lease:        time.Minute,
+		MaxAttempts:  10,
+		MaxDelay:     time.Hour,
+		DisableAfter: 50,
+		Retention:    30 * 24 * time.Hour,
+		Now:          time.Now,
+	}
+}
+
+func (d *WebhookDispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.Interval)
+	defer ticker.Stop()
+
+	lastCleanup := time.Time{}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if _, err := d.DispatchOnce(ctx); err != nil && !errors.Is(err, context.Canceled) {
+			fmt.Println("failed to dispatch webhooks:", err)
+		}
+
+		if now := d.Now(); now.Sub(lastCleanup) > time.Hour {
+			lastCleanup = now
+			if _, err := d.Repo.DeleteFinishedBefore(ctx, now.Add(-d.Retention)); err != nil {
+				fmt.Println("failed to clean up webhook deliveries:", err)
+			}
+		}
+	}
+}
+
+// DispatchOnce sends one batch of due deliveries concurrently and returns
+// how many were attempted.
+func (d *WebhookDispatcher) DispatchOnce(ctx context.Context) (int, error) {
+	deliveries, err := d.Repo.ClaimDue(ctx, d.Now().UTC(), d.Lease, d.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	var wg sync.WaitGroup
+	for i := range deliveries {
+		wg.Add(1)
+		go func(delivery *model.WebhookDelivery) {
+			defer wg.Done()
+			if err := d.deliver(ctx, delivery); err != nil {
+				fmt.Println("failed to record webhook delivery:", err)
+			}
+		}(&deliveries[i])
+	}
+	wg.Wait()
+
+	return len(deliveries), nil
+}
+
+func (d *WebhookDispatcher) deliver(ctx context.Context, delivery *model.WebhookDelivery) error {
+	start := d.Now()
+	code, sendErr := d.send(ctx, delivery)
+	now := d.Now().UTC()
+
+	attempt := model.WebhookAttempt{
+		DeliveryID:   delivery.ID,
+		ResponseCode: code,
+		DurationMS:   now.Sub(start).Milliseconds(),
+		AttemptedAt:  now,
+	}
+
+	delivery.Attempts++
+	delivery.ResponseCode = code
+	switch {
+	case sendErr == nil:
+		delivery.Status = model.WebhookDeliverySucceeded
+		delivery.LastError = ""
+		delivery.DeliveredAt = &now
+	case delivery.Attempts >= d.MaxAttempts:
+		delivery.Status = model.WebhookDeliveryFailed
+		delivery.LastError = sendErr.Error()
+	default:
+		delivery.LastError = sendErr.Error()
+		delivery.NextAttemptAt = now.Add(d.backoff(delivery.Attempts))
+	}
+	if sendErr != nil {
+		attempt.Error = sendErr.Error()
+	}
+
+	failures, err := d.Repo.RecordAttempt(ctx, delivery, attempt)
+	if err != nil {
+		return err
+	}
+
+	if d.DisableAfter > 0 && failures >= d.DisableAfter {
+		reason := fmt.Sprintf("disabled after %d consecutive failed deliveries", failures)
+		fmt.Println("webhook endpoint", delivery.EndpointID, reason)
+		return d.Repo.DisableEndpoint(ctx, delivery.EndpointID, reason, now)
+	}
+	return nil
+}
+
+// send posts the delivery and returns the response status. Anything but a
+// 2xx response is an error.
+func (d *WebhookDispatcher) send(ctx context.Context, delivery *model.WebhookDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.Endpoint.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	timestamp := d.Now().Unix()
+	req.Header.Set("Content-Type", cloudEventsContentType)
+	req.Header.Set("User-Agent", "user-service-webhooks")
+	req.Header.Set(WebhookIDHeader, delivery.ID.String())
+	req.Header.Set(WebhookEventHeader, delivery.EventType)
+	req.Header.Set(WebhookTimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(delivery.Endpoint.Secret, timestamp, delivery.Payload))
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("endpoint responded %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+func (d *WebhookDispatcher) backoff(attempts int) time.Duration {
+	delay := d.Interval
+	for i := 0; i < attempts; i++ {
+		delay *= 2
+		if delay >= d.MaxDelay {
+			return d.MaxDelay
+		}
+	}
+	return delay
+}
@@ -0,0 +1,77 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+const (
+	AuditActorUser      = "user"
+	AuditActorAdmin     = "admin"
+	AuditActorService   = "service"
+	AuditActorAnonymous = "anonymous"
+)
+
+// Actions recorded in the audit log.
+const (
+	AuditLogin               = "login"
+	AuditLoginFailed         = "login_failed"
+	AuditUserUpdated         = "user_updated"
+	AuditUserDeleted         = "user_deleted"
+	AuditUserRestored        = "user_restored"
+	AuditUserErased          = "user_erased"
+	AuditSessionsRevoked     = "sessions_revoked"
+	AuditMFAReset            = "mfa_reset"
+	AuditPrivacyUpdated      = "privacy_updated"
+	AuditPasswordChanged     = "password_changed"
+	AuditUserDeactivated     = "user_deactivated"
+	AuditErasureAcknowledged = "erasure_acknowledged"
+)
+
+// AuditEntry records one security-relevant action. Entries are only ever
+// appended, and each one's Hash covers the previous entry's, so editing or
+// removing a row breaks the chain from there on.
+//
+// The personal details are stored in SealedDetails, encrypted with the
+// target user's key when there is one, so erasing the user shreds them
+// without breaking the chain. Failed logins are the exception, so that
+// recording them does not reveal whether the account exists; when they have
+// a target, their IP and user agent are not stored at all.
+type AuditEntry struct {
+	bun.BaseModel `bun:"table:audit_log"`
+
+	ID            int64      `bun:"id,pk,autoincrement" json:"id"`
+	OccurredAt    time.Time  `bun:"occurred_at,notnull" json:"occurred_at"`
+	ActorType     string     `bun:"actor_type,notnull" json:"actor_type"`
+	ActorID       *uuid.UUID `bun:"actor_id,type:uuid" json:"actor_id,omitempty"`
+	TargetUserID  *uuid.UUID `bun:"target_user_id,type:uuid" json:"target_user_id,omitempty"`
+	Action        string     `bun:"action,notnull" json:"action"`
+	RequestID     string     `bun:"request_id,notnull,default:''" json:"request_id,omitempty"`
+	SealedDetails []byte     `bun:"details,type:bytea" json:"-"`
+	Encrypted     bool       `bun:"encrypted,notnull,default:false" json:"-"`
+	PrevHash      string     `bun:"prev_hash,notnull" json:"prev_hash"`
+	Hash          string     `bun:"hash,notnull" json:"hash"`
+
+	// Details is SealedDetails decoded. It is nil when there were none or
+	// they were shredded.
+	Details *AuditDetails `bun:"-" json:"details,omitempty"`
+}
+
+type AuditDetails struct {
+	IP        string                 `json:"ip,omitempty"`
+	UserAgent string                 `json:"user_agent,omitempty"`
+	Method    string                 `json:"method,omitempty"`
+	Reason    string                 `json:"reason,omitempty"`
+	Service   string                 `json:"service,omitempty"`
+	ErasureID *uuid.UUID             `json:"erasure_id,omitempty"`
+	Changes   map[string]AuditChange `json:"changes,omitempty"`
+}
+
+// AuditChange is a field's value before and after an update. Secret fields
+// only show that they changed.
+type AuditChange struct {
+	From any `json:"from"`
+	To   any `json:"to"`
+}
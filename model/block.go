@@ -0,0 +1,17 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+// Block records that BlockerID blocked BlockedID. Blocking is one-way.
+type Block struct {
+	bun.BaseModel `bun:"table:blocks"`
+
+	BlockerID uuid.UUID `bun:"blocker_id,type:uuid,pk" json:"-"`
+	BlockedID uuid.UUID `bun:"blocked_id,type:uuid,pk" json:"blocked_id"`
+	CreatedAt time.Time `bun:"created_at,notnull,default:current_timestamp" json:"created_at"`
+}
@@ -0,0 +1,41 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+const (
+	FriendRequestPending   = "pending"
+	FriendRequestAccepted  = "accepted"
+	FriendRequestDeclined  = "declined"
+	FriendRequestCancelled = "cancelled"
+)
+
+// FriendRequest asks ToUserID to become a contact of FromUserID. Only a
+// pending request can be answered; the others are kept as history.
+type FriendRequest struct {
+	bun.BaseModel `bun:"table:friend_requests"`
+
+	ID          uuid.UUID  `bun:"id,type:uuid,default:gen_random_uuid(),pk" json:"id"`
+	FromUserID  uuid.UUID  `bun:"from_user_id,type:uuid,notnull" json:"from_user_id"`
+	ToUserID    uuid.UUID  `bun:"to_user_id,type:uuid,notnull" json:"to_user_id"`
+	Status      string     `bun:"status,notnull" json:"status"`
+	CreatedAt   time.Time  `bun:"created_at,notnull,default:current_timestamp" json:"created_at"`
+	RespondedAt *time.Time `bun:"responded_at" json:"responded_at,omitempty"`
+}
+
+// Contact is one side of a mutual contact relationship: every pair of
+// contacts is stored twice, once for each user, so either side's list is a
+// simple range scan.
+type Contact struct {
+	bun.BaseModel `bun:"table:contacts"`
+
+	UserID    uuid.UUID `bun:"user_id,type:uuid,pk" json:"-"`
+	ContactID uuid.UUID `bun:"contact_id,type:uuid,pk" json:"contact_id"`
+	CreatedAt time.Time `bun:"created_at,notnull,default:current_timestamp" json:"created_at"`
+
+	User *User `bun:"rel:belongs-to,join:contact_id=user_id" json:"-"`
+}
@@ -0,0 +1,51 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+// UserKey is a user's data encryption key, wrapped with the service's key.
+// Deleting it makes everything encrypted with it unreadable.
+type UserKey struct {
+	bun.BaseModel `bun:"table:user_keys"`
+
+	UserID    uuid.UUID `bun:"user_id,type:uuid,pk"`
+	Key       []byte    `bun:"key,type:bytea,notnull"`
+	CreatedAt time.Time `bun:"created_at,notnull,default:current_timestamp"`
+}
+
+const (
+	ErasurePendingAcknowledgement = "pending_acknowledgement"
+	ErasureCompleted              = "completed"
+)
+
+// ErasureCertificate proves a user was erased without naming them.
+// SubjectHash is a keyed hash of the user ID, so the certificate can be
+// found again given the ID but reveals nothing on its own.
+type ErasureCertificate struct {
+	bun.BaseModel `bun:"table:erasure_certificates"`
+
+	ID           uuid.UUID  `bun:"id,type:uuid,default:gen_random_uuid(),pk" json:"id"`
+	SubjectHash  string     `bun:"subject_hash,notnull" json:"subject_hash"`
+	Reason       string     `bun:"reason,notnull,default:''" json:"reason,omitempty"`
+	Steps        []string   `bun:"steps,array,notnull" json:"steps"`
+	RequiredAcks []string   `bun:"required_acks,array,notnull" json:"required_acks"`
+	Status       string     `bun:"status,notnull" json:"status"`
+	ErasedAt     time.Time  `bun:"erased_at,notnull" json:"erased_at"`
+	CompletedAt  *time.Time `bun:"completed_at" json:"completed_at,omitempty"`
+
+	Acknowledgements []ErasureAcknowledgement `bun:"rel:has-many,join:id=certificate_id" json:"acknowledgements"`
+}
+
+// ErasureAcknowledgement records that a downstream service erased its copy
+// of the user's data.
+type ErasureAcknowledgement struct {
+	bun.BaseModel `bun:"table:erasure_acknowledgements"`
+
+	CertificateID  uuid.UUID `bun:"certificate_id,type:uuid,pk" json:"-"`
+	Service        string    `bun:"service,pk" json:"service"`
+	AcknowledgedAt time.Time `bun:"acknowledged_at,notnull" json:"acknowledged_at"`
+}
@@ -0,0 +1,33 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+const (
+	DataExportPending    = "pending"
+	DataExportProcessing = "processing"
+	DataExportReady      = "ready"
+	DataExportFailed     = "failed"
+	DataExportExpired    = "expired"
+)
+
+// DataExport is a user's request for a copy of their data. Once ready, the
+// archive is stored under FileKey and can be downloaded until ExpiresAt.
+type DataExport struct {
+	bun.BaseModel `bun:"table:data_exports"`
+
+	ID          uuid.UUID  `bun:"id,type:uuid,default:gen_random_uuid(),pk" json:"id"`
+	UserID      uuid.UUID  `bun:"user_id,type:uuid,notnull" json:"user_id"`
+	Status      string     `bun:"status,notnull" json:"status"`
+	FileKey     string     `bun:"file_key,notnull,default:''" json:"-"`
+	Size        int64      `bun:"size,notnull,default:0" json:"size,omitempty"`
+	Error       string     `bun:"error,notnull,default:''" json:"-"`
+	RequestedAt time.Time  `bun:"requested_at,notnull,default:current_timestamp" json:"requested_at"`
+	StartedAt   *time.Time `bun:"started_at" json:"started_at,omitempty"`
+	CompletedAt *time.Time `bun:"completed_at" json:"completed_at,omitempty"`
+	ExpiresAt   *time.Time `bun:"expires_at" json:"expires_at,omitempty"`
+}
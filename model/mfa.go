@@ -0,0 +1,29 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+type UserMFA struct {
+	bun.BaseModel `bun:"table:user_mfa"`
+
+	UserID       uuid.UUID  `bun:"user_id,type:uuid,pk" json:"user_id"`
+	Secret       string     `bun:"secret,notnull" json:"-"`
+	Confirmed    bool       `bun:"confirmed,notnull,default:false" json:"confirmed"`
+	LastUsedStep int64      `bun:"last_used_step,notnull,default:0" json:"-"` // Rejects replay of an already accepted code
+	CreatedAt    *time.Time `bun:"created_at,notnull,default:current_timestamp" json:"created_at"`
+	ConfirmedAt  *time.Time `bun:"confirmed_at" json:"confirmed_at,omitempty"`
+}
+
+type RecoveryCode struct {
+	bun.BaseModel `bun:"table:user_recovery_codes"`
+
+	ID        uuid.UUID  `bun:"id,type:uuid,default:gen_random_uuid(),pk" json:"id"`
+	UserID    uuid.UUID  `bun:"user_id,type:uuid,notnull" json:"user_id"`
+	CodeHash  string     `bun:"code_hash,unique,notnull" json:"-"`
+	UsedAt    *time.Time `bun:"used_at" json:"used_at,omitempty"`
+	CreatedAt *time.Time `bun:"created_at,notnull,default:current_timestamp" json:"created_at"`
+}
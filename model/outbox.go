@@ -0,0 +1,29 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+// OutboxMessage is a message waiting to be published to the broker. It is
+// written in the same transaction as the change it describes and relayed
+// afterwards, in ID order per AggregateID. A message that keeps failing is
+// eventually dead-lettered: DeadAt is set and it is not retried.
+type OutboxMessage struct {
+	bun.BaseModel `bun:"table:outbox"`
+
+	ID            int64      `bun:"id,pk,autoincrement" json:"id"`
+	AggregateID   uuid.UUID  `bun:"aggregate_id,type:uuid,notnull" json:"aggregate_id"`
+	Exchange      string     `bun:"exchange,notnull,default:''" json:"exchange"`
+	RoutingKey    string     `bun:"routing_key,notnull" json:"routing_key"`
+	ContentType   string     `bun:"content_type,notnull" json:"content_type"`
+	Payload       []byte     `bun:"payload,type:bytea,notnull" json:"-"`
+	Attempts      int        `bun:"attempts,notnull,default:0" json:"attempts"`
+	LastError     string     `bun:"last_error,notnull,default:''" json:"last_error,omitempty"`
+	CreatedAt     time.Time  `bun:"created_at,notnull,default:current_timestamp" json:"created_at"`
+	NextAttemptAt time.Time  `bun:"next_attempt_at,notnull,default:current_timestamp" json:"next_attempt_at"`
+	SentAt        *time.Time `bun:"sent_at" json:"sent_at,omitempty"`
+	DeadAt        *time.Time `bun:"dead_at" json:"dead_at,omitempty"`
+}
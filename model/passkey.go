@@ -0,0 +1,25 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+type PasskeyCredential struct {
+	bun.BaseModel `bun:"table:user_passkeys"`
+
+	CredentialID    []byte     `bun:"credential_id,type:bytea,pk" json:"credential_id"`
+	UserID          uuid.UUID  `bun:"user_id,type:uuid,notnull" json:"user_id"`
+	Name            string     `bun:"name,notnull,default:''" json:"name"`
+	PublicKey       []byte     `bun:"public_key,type:bytea,notnull" json:"-"`
+	AttestationType string     `bun:"attestation_type,notnull" json:"attestation_type"`
+	Transports      []string   `bun:"transports,array" json:"transports"`
+	AAGUID          []byte     `bun:"aaguid,type:bytea" json:"aaguid"`
+	SignCount       int64      `bun:"sign_count,notnull,default:0" json:"sign_count"`
+	BackupEligible  bool       `bun:"backup_eligible,notnull,default:false" json:"backup_eligible"`
+	BackupState     bool       `bun:"backup_state,notnull,default:false" json:"backup_state"`
+	CreatedAt       *time.Time `bun:"created_at,notnull,default:current_timestamp" json:"created_at"`
+	LastUsedAt      *time.Time `bun:"last_used_at" json:"last_used_at,omitempty"`
+}
@@ -0,0 +1,28 @@
+package model
+
+// Visibility decides who besides the user may see part of their account.
+const (
+	VisibilityPublic   = "public"
+	VisibilityContacts = "contacts"
+	VisibilityPrivate  = "private"
+)
+
+func ValidVisibility(v string) bool {
+	return v == VisibilityPublic || v == VisibilityContacts || v == VisibilityPrivate
+}
+
+// PrivacySettings are stored as columns of the users table. Searchable
+// controls whether the user turns up in display name searches.
+type PrivacySettings struct {
+	ProfileVisibility string `bun:"profile_visibility,notnull,default:'public'" json:"profile_visibility"`
+	Searchable        bool   `bun:"searchable,notnull,default:true" json:"searchable"`
+	EmailVisibility   string `bun:"email_visibility,notnull,default:'private'" json:"email_visibility"`
+}
+
+func DefaultPrivacySettings() PrivacySettings {
+	return PrivacySettings{
+		ProfileVisibility: VisibilityPublic,
+		Searchable:        true,
+		EmailVisibility:   VisibilityPrivate,
+	}
+}
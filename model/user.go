@@ -14,9 +14,61 @@ type User struct {
 	Username    string     `bun:"username,unique,notnull" json:"username"`
 	DisplayName string     `bun:"display_name,notnull" json:"display_name"`
 	Email       string     `bun:"email,unique,notnull" json:"email"`
-	Password    string     `bun:"password,notnull" json:"password"`
+	Password    string     `bun:"password,notnull" json:"-"`
 	CreatedAt   *time.Time `bun:"created_at,notnull,default:current_timestamp" json:"created_at"`
 	UpdatedAt   *time.Time `bun:"updated_at,notnull,default:current_timestamp" json:"updated_at"`
+
+	// Optional profile attributes, empty when not set.
+	Bio           string `bun:"bio,nullzero" json:"bio,omitempty"`
+	Avatar        string `bun:"avatar,nullzero" json:"avatar,omitempty"`
+	Locale        string `bun:"locale,nullzero" json:"locale,omitempty"`
+	Timezone      string `bun:"timezone,nullzero" json:"timezone,omitempty"`
+	Pronouns      string `bun:"pronouns,nullzero" json:"pronouns,omitempty"`
+	StatusMessage string `bun:"status_message,nullzero" json:"status_message,omitempty"`
+
+	Privacy PrivacySettings `bun:"embed:" json:"privacy"`
+
+	DeactivatedAt *time.Time `bun:"deactivated_at" json:"deactivated_at,omitempty"`
+
+	// Version is incremented by every write, so a writer can tell whether
+	// the row changed since it was read. Upgrading the password hash on
+	// login is the exception, as it changes nothing the user can see.
+	Version int64 `bun:"version,notnull,default:1" json:"version"`
+
+	// Soft-deleted users are hidden from every query unless asked for. When
+	// usernames are released on deletion, the original one is kept in
+	// DeletedUsername so the account can still be restored.
+	DeletedAt       *time.Time `bun:"deleted_at,soft_delete,nullzero" json:"deleted_at,omitempty"`
+	DeletedUsername string     `bun:"deleted_username,nullzero" json:"-"`
+}
+
+// PublicProfile is what other users may see of a user. The email is only
+// filled in for viewers the user's privacy settings allow.
+type PublicProfile struct {
+	UserID        uuid.UUID `json:"user_id"`
+	Username      string    `json:"username"`
+	DisplayName   string    `json:"display_name"`
+	Email         string    `json:"email,omitempty"`
+	Bio           string    `json:"bio,omitempty"`
+	Avatar        string    `json:"avatar,omitempty"`
+	Locale        string    `json:"locale,omitempty"`
+	Timezone      string    `json:"timezone,omitempty"`
+	Pronouns      string    `json:"pronouns,omitempty"`
+	StatusMessage string    `json:"status_message,omitempty"`
+}
+
+func (u *User) PublicProfile() PublicProfile {
+	return PublicProfile{
+		UserID:        u.UserID,
+		Username:      u.Username,
+		DisplayName:   u.DisplayName,
+		Bio:           u.Bio,
+		Avatar:        u.Avatar,
+		Locale:        u.Locale,
+		Timezone:      u.Timezone,
+		Pronouns:      u.Pronouns,
+		StatusMessage: u.StatusMessage,
+	}
 }
 
 type UserJWTs struct {
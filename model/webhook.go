@@ -0,0 +1,63 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+// WebhookEndpoint is a partner URL that receives the user events listed in
+// EventTypes ("*" for all of them). Secret signs every delivery.
+type WebhookEndpoint struct {
+	bun.BaseModel `bun:"table:webhook_endpoints"`
+
+	ID                  uuid.UUID `bun:"id,type:uuid,default:gen_random_uuid(),pk" json:"id"`
+	URL                 string    `bun:"url,notnull" json:"url"`
+	Secret              string    `bun:"secret,notnull" json:"-"`
+	EventTypes          []string  `bun:"event_types,array,notnull" json:"event_types"`
+	Enabled             bool      `bun:"enabled,notnull" json:"enabled"`
+	ConsecutiveFailures int       `bun:"consecutive_failures,notnull,default:0" json:"consecutive_failures"`
+	DisabledReason      string    `bun:"disabled_reason,notnull,default:''" json:"disabled_reason,omitempty"`
+	CreatedAt           time.Time `bun:"created_at,notnull,default:current_timestamp" json:"created_at"`
+	UpdatedAt           time.Time `bun:"updated_at,notnull,default:current_timestamp" json:"updated_at"`
+}
+
+const (
+	WebhookDeliveryPending   = "pending"
+	WebhookDeliverySucceeded = "succeeded"
+	WebhookDeliveryFailed    = "failed"
+)
+
+// WebhookDelivery is one event to be sent to one endpoint. Payload is the
+// CloudEvent in its JSON format.
+type WebhookDelivery struct {
+	bun.BaseModel `bun:"table:webhook_deliveries"`
+
+	ID            uuid.UUID  `bun:"id,type:uuid,default:gen_random_uuid(),pk" json:"id"`
+	EndpointID    uuid.UUID  `bun:"endpoint_id,type:uuid,notnull" json:"endpoint_id"`
+	EventID       string     `bun:"event_id,notnull" json:"event_id"`
+	EventType     string     `bun:"event_type,notnull" json:"event_type"`
+	Payload       []byte     `bun:"payload,type:bytea,notnull" json:"-"`
+	Status        string     `bun:"status,notnull" json:"status"`
+	Attempts      int        `bun:"attempts,notnull,default:0" json:"attempts"`
+	ResponseCode  int        `bun:"response_code,notnull,default:0" json:"response_code,omitempty"`
+	LastError     string     `bun:"last_error,notnull,default:''" json:"last_error,omitempty"`
+	CreatedAt     time.Time  `bun:"created_at,notnull,default:current_timestamp" json:"created_at"`
+	NextAttemptAt time.Time  `bun:"next_attempt_at,notnull,default:current_timestamp" json:"next_attempt_at"`
+	DeliveredAt   *time.Time `bun:"delivered_at" json:"delivered_at,omitempty"`
+
+	Endpoint *WebhookEndpoint `bun:"rel:belongs-to,join:endpoint_id=id" json:"-"`
+}
+
+// WebhookAttempt records a single HTTP request made for a delivery.
+type WebhookAttempt struct {
+	bun.BaseModel `bun:"table:webhook_attempts"`
+
+	ID           int64     `bun:"id,pk,autoincrement" json:"id"`
+	DeliveryID   uuid.UUID `bun:"delivery_id,type:uuid,notnull" json:"delivery_id"`
+	ResponseCode int       `bun:"response_code,notnull,default:0" json:"response_code,omitempty"`
+	Error        string    `bun:"error,notnull,default:''" json:"error,omitempty"`
+	DurationMS   int64     `bun:"duration_ms,notnull" json:"duration_ms"`
+	AttemptedAt  time.Time `bun:"attempted_at,notnull" json:"attempted_at"`
+}
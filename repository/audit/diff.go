@@ -0,0 +1,59 @@
+package audit
+
+import (
+	"encoding/json"
+	"reflect"
+
+	"github.com/CatalinPlesu/user-service/model"
+)
+
+const redacted = "[REDACTED]"
+
+// secretFields are JSON fields whose values never go into the log.
+var secretFields = map[string]bool{
+	"password":      true,
+	"secret":        true,
+	"token":         true,
+	"jwt":           true,
+	"code":          true,
+	"recovery_code": true,
+}
+
+// Diff compares the top-level JSON fields of before and after and returns
+// the ones that changed, with secret values redacted.
+func Diff(before, after any) map[string]model.AuditChange {
+	from, to := fields(before), fields(after)
+
+	changes := map[string]model.AuditChange{}
+	for name := range union(from, to) {
+		if reflect.DeepEqual(from[name], to[name]) {
+			continue
+		}
+
+		change := model.AuditChange{From: from[name], To: to[name]}
+		if secretFields[name] {
+			change = model.AuditChange{From: redacted, To: redacted}
+		}
+		changes[name] = change
+	}
+	return changes
+}
+
+func fields(v any) map[string]any {
+	m := map[string]any{}
+	if data, err := json.Marshal(v); err == nil {
+		json.Unmarshal(data, &m)
+	}
+	return m
+}
+
+func union(a, b map[string]any) map[string]bool {
+	names := make(map[string]bool, len(a)+len(b))
+	for name := range a {
+		names[name] = true
+	}
+	for name := range b {
+		names[name] = true
+	}
+	return names
+}
@@ -0,0 +1,378 @@
+package audit
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+
+	"github.com/CatalinPlesu/user-service/model"
+	"github.com/CatalinPlesu/user-service/repository/keys"
+)
+
+// chainLockID is the advisory lock key that serialises Chain, so every
+// entry is chained to the one written just before it.
+const chainLockID = 0x61756469746c6f67
+
+// pendingTable holds appended entries until Chain links them into the log.
+const pendingTable = "audit_pending"
+
+type PostgresRepo struct {
+	DB   bun.IDB
+	Keys *keys.PostgresRepo
+}
+
+func NewPostgresRepo(db bun.IDB, keys *keys.PostgresRepo) *PostgresRepo {
+	return &PostgresRepo{DB: db, Keys: keys}
+}
+
+// WithTx returns a repository bound to tx, so an action and its audit entry
+// are committed together.
+func (p *PostgresRepo) WithTx(tx bun.Tx) *PostgresRepo {
+	return &PostgresRepo{DB: tx, Keys: p.Keys.WithTx(tx)}
+}
+
+func (p *PostgresRepo) Migrate(ctx context.Context) error {
+	_, err := p.DB.NewCreateTable().
+		Model((*model.AuditEntry)(nil)).
+		IfNotExists().
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create audit log table: %w", err)
+	}
+
+	_, err = p.DB.NewCreateTable().
+		Model((*model.AuditEntry)(nil)).
+		ModelTableExpr(pendingTable).
+		IfNotExists().
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create pending audit entries table: %w", err)
+	}
+
+	indexes := map[string][]string{
+		"audit_log_target_idx": {"target_user_id", "id"},
+		"audit_log_actor_idx":  {"actor_id", "id"},
+		"audit_log_action_idx": {"action", "id"},
+	}
+	for name, columns := range indexes {
+		_, err := p.DB.NewCreateIndex().
+			Model((*model.AuditEntry)(nil)).
+			Index(name).
+			Column(columns...).
+			IfNotExists().
+			Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to create audit log index: %w", err)
+		}
+	}
+
+	// The hash chain shows tampering after the fact; the trigger stops the
+	// application itself from rewriting history.
+	_, err = p.DB.NewRaw(`
+		CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+		BEGIN
+			RAISE EXCEPTION 'audit_log is append-only';
+		END
+		$$ LANGUAGE plpgsql`).Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create audit log trigger function: %w", err)
+	}
+
+	_, err = p.DB.NewRaw(`
+		CREATE OR REPLACE TRIGGER audit_log_append_only
+		BEFORE UPDATE OR DELETE OR TRUNCATE ON audit_log
+		FOR EACH STATEMENT EXECUTE FUNCTION audit_log_append_only()`).Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create audit log trigger: %w", err)
+	}
+	return nil
+}
+
+// Append seals the entry's details and queues it for Chain. Appends take
+// no lock, so actions being audited do not wait for each other; entries
+// show up in Query once they are chained.
+func (p *PostgresRepo) Append(ctx context.Context, entry *model.AuditEntry) error {
+	if entry.OccurredAt.IsZero() {
+		entry.OccurredAt = time.Now()
+	}
+	// Postgres keeps microseconds, so hash what will be read back.
+	entry.OccurredAt = entry.OccurredAt.UTC().Truncate(time.Microsecond)
+
+	if err := p.seal(ctx, entry); err != nil {
+		return err
+	}
+
+	_, err := p.DB.NewInsert().
+		Model(entry).
+		ModelTableExpr(pendingTable).
+		Returning("id").
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to insert audit entry: %w", err)
+	}
+	return nil
+}
+
+// Chain links up to limit pending entries into the log, oldest first, and
+// returns how many it chained.
+func (p *PostgresRepo) Chain(ctx context.Context, limit int) (int, error) {
+	var chained int
+	err := p.DB.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		_, err := tx.NewRaw("SELECT pg_advisory_xact_lock(?)", chainLockID).Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to lock audit log: %w", err)
+		}
+
+		var pending []model.AuditEntry
+		err = tx.NewSelect().
+			Model(&pending).
+			ModelTableExpr(pendingTable + " AS audit_entry").
+			Order("id").
+			Limit(limit).
+			Scan(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to find pending audit entries: %w", err)
+		}
+		if len(pending) == 0 {
+			return nil
+		}
+
+		var prevHash string
+		err = tx.NewSelect().
+			Model((*model.AuditEntry)(nil)).
+			Column("hash").
+			Order("id DESC").
+			Limit(1).
+			Scan(ctx, &prevHash)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("failed to find last audit entry: %w", err)
+		}
+
+		ids := make([]int64, len(pending))
+		for i := range pending {
+			entry := &pending[i]
+			ids[i] = entry.ID
+
+			entry.ID = 0
+			entry.PrevHash = prevHash
+			entry.Hash = Hash(entry)
+			prevHash = entry.Hash
+		}
+
+		if _, err := tx.NewInsert().Model(&pending).Exec(ctx); err != nil {
+			return fmt.Errorf("failed to insert audit entries: %w", err)
+		}
+
+		_, err = tx.NewDelete().
+			Model((*model.AuditEntry)(nil)).
+			ModelTableExpr(pendingTable).
+			Where("id IN (?)", bun.In(ids)).
+			Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to delete pending audit entries: %w", err)
+		}
+
+		chained = len(pending)
+		return nil
+	})
+	return chained, err
+}
+
+// seal stores the entry's details, encrypted with the target user's key
+// when there is a target. Failed logins are not: looking up the key only
+// for known users would make them slower to fail than unknown ones. Their
+// IP and user agent are left out instead, since erasing the user could not
+// shred them.
+func (p *PostgresRepo) seal(ctx context.Context, entry *model.AuditEntry) error {
+	if entry.Details == nil {
+		return nil
+	}
+
+	if entry.TargetUserID != nil && entry.Action == model.AuditLoginFailed {
+		details := *entry.Details
+		details.IP = ""
+		details.UserAgent = ""
+		entry.Details = &details
+	}
+
+	data, err := json.Marshal(entry.Details)
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit details: %w", err)
+	}
+
+	if entry.TargetUserID == nil || entry.Action == model.AuditLoginFailed {
+		entry.SealedDetails = data
+		return nil
+	}
+
+	dataKey, err := p.Keys.DataKey(ctx, *entry.TargetUserID)
+	if err != nil {
+		return err
+	}
+	entry.SealedDetails, err = keys.Seal(dataKey, data)
+	if err != nil {
+		return err
+	}
+	entry.Encrypted = true
+	return nil
+}
+
+// Hash computes an entry's hash from its stored fields and the previous
+// entry's hash.
+func Hash(entry *model.AuditEntry) string {
+	fields, _ := json.Marshal([]any{
+		entry.PrevHash,
+		entry.OccurredAt.UTC().Format(time.RFC3339Nano),
+		entry.ActorType,
+		entry.ActorID,
+		entry.TargetUserID,
+		entry.Action,
+		entry.RequestID,
+		entry.Encrypted,
+		entry.SealedDetails,
+	})
+
+	sum := sha256.Sum256(fields)
+	return hex.EncodeToString(sum[:])
+}
+
+// Filter selects audit entries. UserID matches both the actor and the
+// target. Entries are returned newest first, starting before BeforeID when
+// it is set.
+type Filter struct {
+	UserID   *uuid.UUID
+	Actions  []string
+	From     time.Time
+	To       time.Time
+	BeforeID int64
+	Limit    int
+}
+
+func (p *PostgresRepo) Query(ctx context.Context, filter Filter) ([]model.AuditEntry, error) {
+	entries := []model.AuditEntry{}
+	query := p.DB.NewSelect().
+		Model(&entries).
+		Order("id DESC").
+		Limit(filter.Limit)
+
+	if filter.UserID != nil {
+		query.WhereGroup(" AND ", func(q *bun.SelectQuery) *bun.SelectQuery {
+			return q.Where("target_user_id = ?", *filter.UserID).
+				WhereOr("actor_id = ?", *filter.UserID)
+		})
+	}
+	if len(filter.Actions) > 0 {
+		query.Where("action IN (?)", bun.In(filter.Actions))
+	}
+	if !filter.From.IsZero() {
+		query.Where("occurred_at >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		query.Where("occurred_at < ?", filter.To)
+	}
+	if filter.BeforeID > 0 {
+		query.Where("id < ?", filter.BeforeID)
+	}
+
+	if err := query.Scan(ctx); err != nil {
+		return nil, fmt.Errorf("failed to query audit log: %w", err)
+	}
+
+	if err := p.open(ctx, entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// open decodes the entries' details. Details encrypted with a shredded key
+// are left out.
+func (p *PostgresRepo) open(ctx context.Context, entries []model.AuditEntry) error {
+	dataKeys := map[uuid.UUID][]byte{}
+	for i := range entries {
+		entry := &entries[i]
+		if len(entry.SealedDetails) == 0 {
+			continue
+		}
+
+		data := entry.SealedDetails
+		if entry.Encrypted {
+			dataKey, ok := dataKeys[*entry.TargetUserID]
+			if !ok {
+				var err error
+				dataKey, err = p.Keys.Find(ctx, *entry.TargetUserID)
+				if err != nil && !errors.Is(err, keys.ErrShredded) {
+					return err
+				}
+				dataKeys[*entry.TargetUserID] = dataKey
+			}
+			if dataKey == nil {
+				continue
+			}
+
+			var err error
+			data, err = keys.Open(dataKey, entry.SealedDetails)
+			if err != nil {
+				return fmt.Errorf("failed to decrypt audit details: %w", err)
+			}
+		}
+
+		entry.Details = &model.AuditDetails{}
+		if err := json.Unmarshal(data, entry.Details); err != nil {
+			return fmt.Errorf("failed to decode audit details: %w", err)
+		}
+	}
+	return nil
+}
+
+// Verification is the outcome of checking the hash chain. BrokenAt is the
+// first entry whose hash does not match, if any.
+type Verification struct {
+	Valid    bool  `json:"valid"`
+	Checked  int   `json:"checked"`
+	BrokenAt int64 `json:"broken_at,omitempty"`
+}
+
+// Verify recomputes the hash chain over the whole log.
+func (p *PostgresRepo) Verify(ctx context.Context) (Verification, error) {
+	const batchSize = 1000
+
+	result := Verification{Valid: true}
+	prevHash := ""
+	var lastID int64
+	for {
+		var entries []model.AuditEntry
+		err := p.DB.NewSelect().
+			Model(&entries).
+			Where("id > ?", lastID).
+			Order("id").
+			Limit(batchSize).
+			Scan(ctx)
+		if err != nil {
+			return result, fmt.Errorf("failed to read audit log: %w", err)
+		}
+
+		for i := range entries {
+			entry := &entries[i]
+			if entry.PrevHash != prevHash || Hash(entry) != entry.Hash {
+				result.Valid = false
+				result.BrokenAt = entry.ID
+				return result, nil
+			}
+			prevHash = entry.Hash
+			lastID = entry.ID
+			result.Checked++
+		}
+
+		if len(entries) < batchSize {
+			return result, nil
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/CatalinPlesu/user-service/model"
+)
+
+func TestSealFailedLogin(t *testing.T) {
+	target := uuid.New()
+	tests := []struct {
+		name   string
+		target *uuid.UUID
+		want   model.AuditDetails
+	}{
+		{
+			name:   "known user",
+			target: &target,
+			want:   model.AuditDetails{Method: "password"},
+		},
+		{
+			name: "unknown user",
+			want: model.AuditDetails{IP: "192.0.2.1", UserAgent: "test", Method: "password"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := &model.AuditEntry{
+				TargetUserID: tt.target,
+				Action:       model.AuditLoginFailed,
+				Details:      &model.AuditDetails{IP: "192.0.2.1", UserAgent: "test", Method: "password"},
+			}
+			if err := (&PostgresRepo{}).seal(context.Background(), entry); err != nil {
+				t.Fatal(err)
+			}
+			if entry.Encrypted {
+				t.Error("failed login was encrypted")
+			}
+
+			var stored model.AuditDetails
+			if err := json.Unmarshal(entry.SealedDetails, &stored); err != nil {
+				t.Fatal(err)
+			}
+			if stored.IP != tt.want.IP || stored.UserAgent != tt.want.UserAgent || stored.Method != tt.want.Method {
+				t.Errorf("stored details = %+v, want %+v", stored, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package avatar
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+
+	"github.com/google/uuid"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/webp"
+
+	"github.com/CatalinPlesu/user-service/repository/storage"
+)
+
+var (
+	ErrUnsupportedFormat = errors.New("unsupported image format")
+	ErrInvalidDimensions = errors.New("image dimensions out of range")
+	ErrInvalidImage      = errors.New("invalid image")
+)
+
+// Sizes are the edge lengths, in pixels, of the square renditions kept for
+// every avatar.
+var Sizes = []int{512, 256, 128, 64}
+
+// DefaultSize is the rendition a user's avatar URL points to.
+const DefaultSize = 256
+
+// MinDimension is the smallest edge an upload may have.
+const MinDimension = 64
+
+const jpegQuality = 90
+
+type Rendition struct {
+	Size        int
+	ContentType string
+	Data        []byte
+}
+
+// Avatar is a processed upload. Hash identifies the upload's content and
+// is part of its URLs, so they can be cached forever.
+type Avatar struct {
+	Hash       string
+	Renditions []Rendition
+}
+
+type decoder struct {
+	Decode       func(io.Reader) (image.Image, error)
+	DecodeConfig func(io.Reader) (image.Config, error)
+}
+
+// decoders are keyed by the content type http.DetectContentType sniffs.
+var decoders = map[string]decoder{
+	"image/png":  {png.Decode, png.DecodeConfig},
+	"image/jpeg": {jpeg.Decode, jpeg.DecodeConfig},
+	"image/webp": {webp.Decode, webp.DecodeConfig},
+}
+
+// Process sniffs the upload's format, ignoring whatever the client claimed,
+// checks its dimensions before decoding it in full, and renders a centred
+// square crop at every size. Re-encoding drops any metadata the upload
+// carried. Opaque images become JPEG, others PNG.
+func Process(data []byte, maxDimension int) (*Avatar, error) {
+	dec, ok := decoders[http.DetectContentType(data)]
+	if !ok {
+		return nil, ErrUnsupportedFormat
+	}
+
+	config, err := dec.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidImage, err)
+	}
+	if config.Width < MinDimension || config.Height < MinDimension ||
+		config.Width > maxDimension || config.Height > maxDimension {
+		return nil, fmt.Errorf("%w: %dx%d, edges must be between %d and %d pixels",
+			ErrInvalidDimensions, config.Width, config.Height, MinDimension, maxDimension)
+	}
+
+	src, err := dec.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidImage, err)
+	}
+
+	sum := sha256.Sum256(data)
+	avatar := &Avatar{Hash: hex.EncodeToString(sum[:8])}
+
+	crop := centerSquare(src.Bounds())
+	for _, size := range Sizes {
+		dst := image.NewRGBA(image.Rect(0, 0, size, size))
+		draw.CatmullRom.Scale(dst, dst.Bounds(), src, crop, draw.Src, nil)
+
+		rendition, err := encode(dst)
+		if err != nil {
+			return nil, err
+		}
+		rendition.Size = size
+		avatar.Renditions = append(avatar.Renditions, rendition)
+	}
+	return avatar, nil
+}
+
+func centerSquare(r image.Rectangle) image.Rectangle {
+	side := min(r.Dx(), r.Dy())
+	x := r.Min.X + (r.Dx()-side)/2
+	y := r.Min.Y + (r.Dy()-side)/2
+	return image.Rect(x, y, x+side, y+side)
+}
+
+func encode(img *image.RGBA) (Rendition, error) {
+	var buf bytes.Buffer
+	if img.Opaque() {
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: jpegQuality}); err != nil {
+			return Rendition{}, fmt.Errorf("failed to encode avatar: %w", err)
+		}
+		return Rendition{ContentType: "image/jpeg", Data: buf.Bytes()}, nil
+	}
+
+	if err := png.Encode(&buf, img); err != nil {
+		return Rendition{}, fmt.Errorf("failed to encode avatar: %w", err)
+	}
+	return Rendition{ContentType: "image/png", Data: buf.Bytes()}, nil
+}
+
+// Key is where a rendition is stored.
+func Key(userID uuid.UUID, hash string, size int) string {
+	return fmt.Sprintf("%s/%s/%d", userID, hash, size)
+}
+
+// Save stores every rendition of the avatar.
+func Save(ctx context.Context, store storage.BlobStore, userID uuid.UUID, avatar *Avatar) error {
+	for _, rendition := range avatar.Renditions {
+		err := store.Put(ctx, Key(userID, avatar.Hash, rendition.Size), bytes.NewReader(rendition.Data))
+		if err != nil {
+			return fmt.Errorf("failed to store avatar: %w", err)
+		}
+	}
+	return nil
+}
+
+// Remove deletes every rendition of the user's avatar with the given hash.
+func Remove(ctx context.Context, store storage.BlobStore, userID uuid.UUID, hash string) error {
+	return store.DeletePrefix(ctx, userID.String()+"/"+hash)
+}
+
+// RemoveAll deletes every avatar the user ever uploaded.
+func RemoveAll(ctx context.Context, store storage.BlobStore, userID uuid.UUID) error {
+	return store.DeletePrefix(ctx, userID.String())
+}
@@ -0,0 +1,190 @@
+package avatar
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func testImage(width, height int, alpha uint8) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.NRGBA{R: uint8(x), G: uint8(y), B: 128, A: alpha})
+		}
+	}
+	return img
+}
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func encodeJPEG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestProcessSniffsFormat(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr error
+	}{
+		{name: "png", data: encodePNG(t, testImage(100, 100, 255))},
+		{name: "jpeg", data: encodeJPEG(t, testImage(100, 100, 255))},
+		{name: "gif", data: []byte("GIF89a\x64\x00\x64\x00\x00\x00\x00;"), wantErr: ErrUnsupportedFormat},
+		{name: "svg", data: []byte(`<svg xmlns="http://www.w3.org/2000/svg" width="100" height="100"></svg>`), wantErr: ErrUnsupportedFormat},
+		{name: "html", data: []byte("<html><body><img src=x onerror=alert(1)></body></html>"), wantErr: ErrUnsupportedFormat},
+		// A PNG signature followed by something else is sniffed as PNG and
+		// then fails to decode, instead of being trusted.
+		{name: "png signature only", data: []byte("\x89PNG\r\n\x1a\nnot really a png"), wantErr: ErrInvalidImage},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Process(tt.data, 1024)
+			if tt.wantErr == nil && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// pngHeader returns a PNG that is only a signature and an IHDR chunk
+// claiming the given dimensions. Its configuration reads fine, but decoding
+// it in full fails for lack of image data.
+func pngHeader(width, height uint32) []byte {
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:4], width)
+	binary.BigEndian.PutUint32(ihdr[4:8], height)
+	ihdr[8] = 8 // Bit depth
+	ihdr[9] = 2 // Truecolor
+
+	var buf bytes.Buffer
+	buf.WriteString("\x89PNG\r\n\x1a\n")
+	binary.Write(&buf, binary.BigEndian, uint32(len(ihdr)))
+	chunk := append([]byte("IHDR"), ihdr...)
+	buf.Write(chunk)
+	binary.Write(&buf, binary.BigEndian, crc32.ChecksumIEEE(chunk))
+	return buf.Bytes()
+}
+
+func TestProcessChecksDimensionsBeforeDecoding(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr error
+	}{
+		{name: "too large", data: pngHeader(50000, 50000), wantErr: ErrInvalidDimensions},
+		{name: "too wide", data: pngHeader(2048, 128), wantErr: ErrInvalidDimensions},
+		{name: "too small", data: pngHeader(32, 32), wantErr: ErrInvalidDimensions},
+		// Within the limits, the missing image data is only noticed when
+		// decoding, which shows the checks above happened before it.
+		{name: "within limits", data: pngHeader(128, 128), wantErr: ErrInvalidImage},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Process(tt.data, 1024); !errors.Is(err, tt.wantErr) {
+				t.Errorf("error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// withExif inserts an APP1 Exif segment holding payload after the JPEG's
+// start of image marker.
+func withExif(data []byte, payload string) []byte {
+	segment := append([]byte("Exif\x00\x00"), payload...)
+
+	var buf bytes.Buffer
+	buf.Write(data[:2])
+	buf.Write([]byte{0xff, 0xe1})
+	binary.Write(&buf, binary.BigEndian, uint16(len(segment)+2))
+	buf.Write(segment)
+	buf.Write(data[2:])
+	return buf.Bytes()
+}
+
+func TestProcessStripsMetadata(t *testing.T) {
+	const secret = "GPS 47.0105N 28.8638E serial 0123456789"
+	data := withExif(encodeJPEG(t, testImage(300, 200, 255)), secret)
+	if !bytes.Contains(data, []byte(secret)) {
+		t.Fatal("test upload does not carry the metadata")
+	}
+
+	avatar, err := Process(data, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, rendition := range avatar.Renditions {
+		if bytes.Contains(rendition.Data, []byte(secret)) || bytes.Contains(rendition.Data, []byte("Exif")) {
+			t.Errorf("%d pixel rendition kept the upload's metadata", rendition.Size)
+		}
+	}
+}
+
+func TestProcessRendersEverySize(t *testing.T) {
+	tests := []struct {
+		name            string
+		alpha           uint8
+		wantContentType string
+	}{
+		{name: "opaque", alpha: 255, wantContentType: "image/jpeg"},
+		{name: "transparent", alpha: 128, wantContentType: "image/png"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			avatar, err := Process(encodePNG(t, testImage(640, 480, tt.alpha)), 1024)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(avatar.Renditions) != len(Sizes) {
+				t.Fatalf("got %d renditions, want %d", len(avatar.Renditions), len(Sizes))
+			}
+			for i, rendition := range avatar.Renditions {
+				if rendition.Size != Sizes[i] {
+					t.Errorf("rendition %d is %d pixels, want %d", i, rendition.Size, Sizes[i])
+				}
+				if rendition.ContentType != tt.wantContentType {
+					t.Errorf("%d pixel rendition is %s, want %s", rendition.Size, rendition.ContentType, tt.wantContentType)
+				}
+
+				config, format, err := image.DecodeConfig(bytes.NewReader(rendition.Data))
+				if err != nil {
+					t.Fatalf("failed to decode %d pixel rendition: %v", rendition.Size, err)
+				}
+				if "image/"+format != rendition.ContentType {
+					t.Errorf("%d pixel rendition is encoded as %s but labelled %s", rendition.Size, format, rendition.ContentType)
+				}
+				if config.Width != rendition.Size || config.Height != rendition.Size {
+					t.Errorf("%d pixel rendition is %dx%d", rendition.Size, config.Width, config.Height)
+				}
+			}
+		})
+	}
+}
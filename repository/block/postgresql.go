@@ -0,0 +1,118 @@
+package block
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+
+	"github.com/CatalinPlesu/user-service/model"
+)
+
+// PostgresRepo holds the blocks. It is the source of truth that RedisRepo
+// mirrors.
+type PostgresRepo struct {
+	DB bun.IDB
+}
+
+func NewPostgresRepo(db bun.IDB) *PostgresRepo {
+	return &PostgresRepo{DB: db}
+}
+
+// WithTx returns a repository bound to tx, so a block can be committed
+// together with its events.
+func (p *PostgresRepo) WithTx(tx bun.Tx) *PostgresRepo {
+	return &PostgresRepo{DB: tx}
+}
+
+func (p *PostgresRepo) Migrate(ctx context.Context) error {
+	_, err := p.DB.NewCreateTable().
+		Model((*model.Block)(nil)).
+		IfNotExists().
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create blocks table: %w", err)
+	}
+
+	// The primary key covers a blocker's list; this one finds who blocked
+	// a user.
+	_, err = p.DB.NewCreateIndex().
+		Model((*model.Block)(nil)).
+		Index("blocks_blocked_idx").
+		Column("blocked_id").
+		IfNotExists().
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create blocks index: %w", err)
+	}
+	return nil
+}
+
+// Block records the block and reports whether it is new.
+func (p *PostgresRepo) Block(ctx context.Context, blockerID, blockedID uuid.UUID, now time.Time) (bool, error) {
+	res, err := p.DB.NewInsert().
+		Model(&model.Block{BlockerID: blockerID, BlockedID: blockedID, CreatedAt: now}).
+		On("CONFLICT DO NOTHING").
+		Exec(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to insert block: %w", err)
+	}
+
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// Unblock removes the block and reports whether there was one.
+func (p *PostgresRepo) Unblock(ctx context.Context, blockerID, blockedID uuid.UUID) (bool, error) {
+	res, err := p.DB.NewDelete().
+		Model((*model.Block)(nil)).
+		Where("blocker_id = ?", blockerID).
+		Where("blocked_id = ?", blockedID).
+		Exec(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete block: %w", err)
+	}
+
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// Page selects up to Limit blocks ordered by the blocked user's ID,
+// starting after After.
+type Page struct {
+	After uuid.UUID
+	Limit int
+}
+
+func (p *PostgresRepo) List(ctx context.Context, blockerID uuid.UUID, page Page) ([]model.Block, error) {
+	blocks := []model.Block{}
+	query := p.DB.NewSelect().
+		Model(&blocks).
+		Where("blocker_id = ?", blockerID).
+		Order("blocked_id").
+		Limit(page.Limit)
+	if page.After != uuid.Nil {
+		query.Where("blocked_id > ?", page.After)
+	}
+
+	if err := query.Scan(ctx); err != nil {
+		return nil, fmt.Errorf("failed to list blocks: %w", err)
+	}
+	return blocks, nil
+}
+
+// BlockedIDs returns every user the blocker blocked.
+func (p *PostgresRepo) BlockedIDs(ctx context.Context, blockerID uuid.UUID) ([]uuid.UUID, error) {
+	var ids []uuid.UUID
+	err := p.DB.NewSelect().
+		Model((*model.Block)(nil)).
+		Column("blocked_id").
+		Where("blocker_id = ?", blockerID).
+		Scan(ctx, &ids)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find blocked users: %w", err)
+	}
+	return ids, nil
+}
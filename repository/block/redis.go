@@ -0,0 +1,188 @@
+package block
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// loadedMarker is a member of every mirrored set, so an empty block
+	// list can be told apart from one that was never loaded.
+	loadedMarker = "*"
+
+	// mirrorTTL bounds how long a mirrored set can drift from Postgres
+	// should an update to it be lost.
+	mirrorTTL = time.Hour
+)
+
+// addIfLoaded only adds to sets that are mirrored already; a set that is
+// not will be loaded with the new block included. It bumps the blocker's
+// generation either way, so a load that read Postgres before the block was
+// committed does not mirror what it read.
+var addIfLoaded = redis.NewScript(`
+redis.call("INCR", KEYS[2])
+redis.call("EXPIRE", KEYS[2], ARGV[2])
+if redis.call("EXISTS", KEYS[1]) == 1 then
+	return redis.call("SADD", KEYS[1], ARGV[1])
+end
+return 0
+`)
+
+// Pair asks whether BlockerID blocked BlockedID.
+type Pair struct {
+	BlockerID uuid.UUID `json:"blocker_id"`
+	BlockedID uuid.UUID `json:"blocked_id"`
+}
+
+// RedisRepo mirrors each user's blocks into a Redis set so they can be
+// checked quickly and in bulk. Sets are loaded from Source the first time
+// they are needed.
+type RedisRepo struct {
+	Client *redis.Client
+	Source *PostgresRepo
+}
+
+func NewRedisRepo(client *redis.Client, source *PostgresRepo) *RedisRepo {
+	return &RedisRepo{Client: client, Source: source}
+}
+
+func blocksKey(blockerID uuid.UUID) string {
+	return fmt.Sprintf("blocks:%s", blockerID)
+}
+
+// generationKey counts the changes to the blocker's blocks.
+func generationKey(blockerID uuid.UUID) string {
+	return fmt.Sprintf("blocks_generation:%s", blockerID)
+}
+
+// Add mirrors a block that was just committed.
+func (r *RedisRepo) Add(ctx context.Context, blockerID, blockedID uuid.UUID) error {
+	keys := []string{blocksKey(blockerID), generationKey(blockerID)}
+	if err := addIfLoaded.Run(ctx, r.Client, keys, blockedID.String(), int(mirrorTTL.Seconds())).Err(); err != nil {
+		return fmt.Errorf("failed to mirror block: %w", err)
+	}
+	return nil
+}
+
+// Remove mirrors a block that was just lifted.
+func (r *RedisRepo) Remove(ctx context.Context, blockerID, blockedID uuid.UUID) error {
+	_, err := r.Client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Incr(ctx, generationKey(blockerID))
+		pipe.Expire(ctx, generationKey(blockerID), mirrorTTL)
+		pipe.SRem(ctx, blocksKey(blockerID), blockedID.String())
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to mirror unblock: %w", err)
+	}
+	return nil
+}
+
+// DeleteUser drops the mirror of the user's own blocks.
+func (r *RedisRepo) DeleteUser(ctx context.Context, blockerID uuid.UUID) error {
+	if err := r.Client.Del(ctx, blocksKey(blockerID), generationKey(blockerID)).Err(); err != nil {
+		return fmt.Errorf("failed to delete mirrored blocks: %w", err)
+	}
+	return nil
+}
+
+// IsBlocked reports whether blockerID blocked blockedID.
+func (r *RedisRepo) IsBlocked(ctx context.Context, blockerID, blockedID uuid.UUID) (bool, error) {
+	results, err := r.Check(ctx, []Pair{{BlockerID: blockerID, BlockedID: blockedID}})
+	if err != nil {
+		return false, err
+	}
+	return results[0], nil
+}
+
+// Check answers every pair in one round trip, plus a load from Postgres
+// for each blocker whose set is not mirrored yet.
+func (r *RedisRepo) Check(ctx context.Context, pairs []Pair) ([]bool, error) {
+	pipe := r.Client.Pipeline()
+	loaded := map[uuid.UUID]*redis.BoolCmd{}
+	members := make([]*redis.BoolCmd, len(pairs))
+	for i, pair := range pairs {
+		key := blocksKey(pair.BlockerID)
+		if _, ok := loaded[pair.BlockerID]; !ok {
+			loaded[pair.BlockerID] = pipe.SIsMember(ctx, key, loadedMarker)
+		}
+		members[i] = pipe.SIsMember(ctx, key, pair.BlockedID.String())
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, fmt.Errorf("failed to check blocks: %w", err)
+	}
+
+	missing := map[uuid.UUID]map[uuid.UUID]bool{}
+	for blockerID, cmd := range loaded {
+		if cmd.Val() {
+			continue
+		}
+
+		blocked, err := r.load(ctx, blockerID)
+		if err != nil {
+			return nil, err
+		}
+		missing[blockerID] = blocked
+	}
+
+	results := make([]bool, len(pairs))
+	for i, pair := range pairs {
+		if blocked, ok := missing[pair.BlockerID]; ok {
+			results[i] = blocked[pair.BlockedID]
+		} else {
+			results[i] = members[i].Val()
+		}
+	}
+	return results, nil
+}
+
+// load reads the blocker's blocks from Postgres and mirrors them. If they
+// changed while being read, as told by the generation, what was read may
+// be stale and is not mirrored; the next check loads them again.
+func (r *RedisRepo) load(ctx context.Context, blockerID uuid.UUID) (map[uuid.UUID]bool, error) {
+	genKey := generationKey(blockerID)
+	generation, err := r.Client.Get(ctx, genKey).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, fmt.Errorf("failed to get blocks generation: %w", err)
+	}
+
+	ids, err := r.Source.BlockedIDs(ctx, blockerID)
+	if err != nil {
+		return nil, err
+	}
+
+	blocked := make(map[uuid.UUID]bool, len(ids))
+	members := []interface{}{loadedMarker}
+	for _, id := range ids {
+		blocked[id] = true
+		members = append(members, id.String())
+	}
+
+	key := blocksKey(blockerID)
+	err = r.Client.Watch(ctx, func(tx *redis.Tx) error {
+		current, err := tx.Get(ctx, genKey).Result()
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return err
+		}
+		if current != generation {
+			return nil
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Del(ctx, key)
+			pipe.SAdd(ctx, key, members...)
+			pipe.Expire(ctx, key, mirrorTTL)
+			return nil
+		})
+		return err
+	}, genKey)
+	if err != nil && !errors.Is(err, redis.TxFailedErr) {
+		return nil, fmt.Errorf("failed to mirror blocks: %w", err)
+	}
+	return blocked, nil
+}
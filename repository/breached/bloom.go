@@ -0,0 +1,140 @@
+package breached
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+var bloomMagic = [4]byte{'P', 'W', 'B', 'F'}
+
+const bloomVersion = 1
+
+var ErrBadFilter = errors.New("not a password bloom filter")
+
+// BloomFilter is a compact probabilistic set of SHA-1 password hashes. It
+// never misses a password that was added, but reports a small fraction of
+// other passwords as present.
+type BloomFilter struct {
+	bits   []uint64
+	m      uint64 // Number of bits
+	k      uint32 // Number of hash functions
+	filled uint64
+}
+
+// NewBloomFilter sizes a filter for n entries at the given false positive
+// rate.
+func NewBloomFilter(n uint64, falsePositiveRate float64) *BloomFilter {
+	if n == 0 {
+		n = 1
+	}
+
+	m := uint64(math.Ceil(-float64(n) * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2)))
+	k := uint32(math.Max(1, math.Round(float64(m)/float64(n)*math.Ln2)))
+
+	return &BloomFilter{
+		bits: make([]uint64, (m+63)/64),
+		m:    m,
+		k:    k,
+	}
+}
+
+// locations derives the k bit positions from the SHA-1 digest using double
+// hashing, so no further hashing is needed per probe.
+func (b *BloomFilter) locations(sum [sha1.Size]byte, fn func(uint64) bool) bool {
+	h1 := binary.BigEndian.Uint64(sum[0:8])
+	h2 := binary.BigEndian.Uint64(sum[8:16]) | 1
+
+	for i := uint32(0); i < b.k; i++ {
+		if !fn((h1 + uint64(i)*h2) % b.m) {
+			return false
+		}
+	}
+	return true
+}
+
+func (b *BloomFilter) AddHash(sum [sha1.Size]byte) {
+	b.locations(sum, func(bit uint64) bool {
+		b.bits[bit/64] |= 1 << (bit % 64)
+		return true
+	})
+	b.filled++
+}
+
+func (b *BloomFilter) Add(password string) {
+	b.AddHash(sha1.Sum([]byte(password)))
+}
+
+func (b *BloomFilter) ContainsHash(sum [sha1.Size]byte) bool {
+	return b.locations(sum, func(bit uint64) bool {
+		return b.bits[bit/64]&(1<<(bit%64)) != 0
+	})
+}
+
+func (b *BloomFilter) Contains(password string) (bool, error) {
+	return b.ContainsHash(sha1.Sum([]byte(password))), nil
+}
+
+func (b *BloomFilter) Len() uint64 {
+	return b.filled
+}
+
+// WriteTo serializes the filter as a small header followed by the bit array
+// in little-endian words.
+func (b *BloomFilter) WriteTo(w io.Writer) (int64, error) {
+	bw := bufio.NewWriter(w)
+
+	header := struct {
+		Magic   [4]byte
+		Version uint32
+		M       uint64
+		K       uint32
+		Filled  uint64
+	}{bloomMagic, bloomVersion, b.m, b.k, b.filled}
+
+	if err := binary.Write(bw, binary.LittleEndian, header); err != nil {
+		return 0, fmt.Errorf("failed to write filter header: %w", err)
+	}
+	if err := binary.Write(bw, binary.LittleEndian, b.bits); err != nil {
+		return 0, fmt.Errorf("failed to write filter bits: %w", err)
+	}
+	if err := bw.Flush(); err != nil {
+		return 0, fmt.Errorf("failed to write filter: %w", err)
+	}
+
+	return int64(binary.Size(header) + 8*len(b.bits)), nil
+}
+
+func ReadBloomFilter(r io.Reader) (*BloomFilter, error) {
+	br := bufio.NewReader(r)
+
+	var header struct {
+		Magic   [4]byte
+		Version uint32
+		M       uint64
+		K       uint32
+		Filled  uint64
+	}
+	if err := binary.Read(br, binary.LittleEndian, &header); err != nil {
+		return nil, fmt.Errorf("failed to read filter header: %w", err)
+	}
+	if header.Magic != bloomMagic || header.Version != bloomVersion || header.M == 0 || header.K == 0 {
+		return nil, ErrBadFilter
+	}
+
+	bits := make([]uint64, (header.M+63)/64)
+	if err := binary.Read(br, binary.LittleEndian, bits); err != nil {
+		return nil, fmt.Errorf("failed to read filter bits: %w", err)
+	}
+
+	return &BloomFilter{
+		bits:   bits,
+		m:      header.M,
+		k:      header.K,
+		filled: header.Filled,
+	}, nil
+}
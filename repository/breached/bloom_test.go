@@ -0,0 +1,61 @@
+package breached
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestBloomFilterRoundTrip(t *testing.T) {
+	filter := NewBloomFilter(1000, 0.001)
+	for i := 0; i < 1000; i++ {
+		filter.Add(fmt.Sprintf("password%d", i))
+	}
+
+	var buf bytes.Buffer
+	size, err := filter.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != int64(buf.Len()) {
+		t.Errorf("WriteTo reported %d bytes, wrote %d", size, buf.Len())
+	}
+
+	loaded, err := ReadBloomFilter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Len() != 1000 {
+		t.Errorf("loaded filter has %d entries, want 1000", loaded.Len())
+	}
+
+	for i := 0; i < 1000; i++ {
+		found, err := loaded.Contains(fmt.Sprintf("password%d", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !found {
+			t.Fatalf("loaded filter misses password%d", i)
+		}
+	}
+
+	// At a 0.1% false positive rate, far fewer than 1% of other passwords
+	// should be reported.
+	var falsePositives int
+	for i := 0; i < 10000; i++ {
+		if found, _ := loaded.Contains(fmt.Sprintf("other%d", i)); found {
+			falsePositives++
+		}
+	}
+	if falsePositives > 100 {
+		t.Errorf("%d of 10000 other passwords reported as breached", falsePositives)
+	}
+}
+
+func TestReadBloomFilterRejectsOtherFiles(t *testing.T) {
+	_, err := ReadBloomFilter(bytes.NewReader(bytes.Repeat([]byte("not a filter"), 4)))
+	if !errors.Is(err, ErrBadFilter) {
+		t.Errorf("error = %v, want %v", err, ErrBadFilter)
+	}
+}
@@ -0,0 +1,74 @@
+package breached
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Monitor wraps the configured Screener and counts failed lookups, so the
+// health check shows when screening is not actually happening. A corpus
+// that failed to load fails every lookup with the load error.
+type Monitor struct {
+	screener Screener
+	loadErr  error
+
+	lookups  atomic.Uint64
+	failures atomic.Uint64
+
+	mu        sync.Mutex
+	lastError string
+}
+
+// NewMonitor monitors screener, as returned by Load along with err. A nil
+// screener without an error means screening is disabled.
+func NewMonitor(screener Screener, err error) *Monitor {
+	return &Monitor{screener: screener, loadErr: err}
+}
+
+func (m *Monitor) Contains(password string) (bool, error) {
+	if m.screener == nil && m.loadErr == nil {
+		return false, nil
+	}
+
+	m.lookups.Add(1)
+	err := m.loadErr
+	var found bool
+	if err == nil {
+		found, err = m.screener.Contains(password)
+	}
+
+	if err != nil {
+		m.failures.Add(1)
+		m.mu.Lock()
+		m.lastError = err.Error()
+		m.mu.Unlock()
+	}
+	return found, err
+}
+
+// Status is a snapshot of screening for health checks.
+type Status struct {
+	State     string `json:"state"`
+	Lookups   uint64 `json:"lookups"`
+	Failures  uint64 `json:"failures"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+func (m *Monitor) Status() Status {
+	status := Status{
+		State:    "up",
+		Lookups:  m.lookups.Load(),
+		Failures: m.failures.Load(),
+	}
+	switch {
+	case m.loadErr != nil:
+		status.State = "down"
+	case m.screener == nil:
+		status.State = "disabled"
+	}
+
+	m.mu.Lock()
+	status.LastError = m.lastError
+	m.mu.Unlock()
+	return status
+}
@@ -0,0 +1,103 @@
+package breached
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const prefixLength = 5
+
+// RangeDir looks passwords up in a directory laid out like the Pwned
+// Passwords range API: one file per 5 character SHA-1 prefix, each holding
+// "SUFFIX:COUNT" lines. Only the file for the password's prefix is read. As
+// the API has a range for every prefix, a missing file fails the lookup
+// rather than passing the password.
+type RangeDir struct {
+	Dir string
+}
+
+// OpenRangeDir returns the RangeDir at dir, after checking that it holds
+// range files at all, so a wrong path is not taken for a corpus.
+func OpenRangeDir(dir string) (*RangeDir, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open range directory: %w", err)
+	}
+	defer f.Close()
+
+	for {
+		entries, err := f.ReadDir(256)
+		for _, entry := range entries {
+			if entry.Type().IsRegular() && isRangeFile(entry.Name()) {
+				return &RangeDir{Dir: dir}, nil
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("no range files in %s", dir)
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to read range directory: %w", err)
+		}
+	}
+}
+
+// isRangeFile reports whether name is a hex SHA-1 prefix, with or without
+// the .txt extension.
+func isRangeFile(name string) bool {
+	prefix := strings.TrimSuffix(name, ".txt")
+	return len(prefix) == prefixLength && strings.Trim(prefix, "0123456789abcdefABCDEF") == ""
+}
+
+func (d *RangeDir) path(prefix string) (string, error) {
+	for _, name := range []string{prefix, prefix + ".txt", strings.ToLower(prefix), strings.ToLower(prefix) + ".txt"} {
+		path := filepath.Join(d.Dir, name)
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return "", err
+		}
+	}
+	return "", fs.ErrNotExist
+}
+
+func (d *RangeDir) Contains(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	digest := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := digest[:prefixLength], digest[prefixLength:]
+
+	path, err := d.path(prefix)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, fmt.Errorf("no range file for prefix %s", prefix)
+	} else if err != nil {
+		return false, fmt.Errorf("failed to find range file: %w", err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return false, fmt.Errorf("failed to open range file: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		candidate, count, _ := strings.Cut(line, ":")
+		if !strings.EqualFold(candidate, suffix) {
+			continue
+		}
+		// Padding entries in the range format carry a count of zero.
+		return strings.TrimSpace(count) != "0", nil
+	}
+	if err := scanner.Err(); err != nil {
+		return false, fmt.Errorf("failed to read range file: %w", err)
+	}
+
+	return false, nil
+}
@@ -0,0 +1,41 @@
+package breached
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadRangeDir(t *testing.T) {
+	// SHA-1("password") is 5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8.
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "5BAA6.txt"), []byte("1E4C9B93F3F0682250B6CF8331B7EE68FD8:10\r\n"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	screener, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if found, err := screener.Contains("password"); err != nil || !found {
+		t.Errorf("Contains(password) = %v, %v, want true", found, err)
+	}
+	// SHA-1("correct horse battery staple") starts with ABF7A, which has no
+	// range file.
+	if _, err := screener.Contains("correct horse battery staple"); err == nil {
+		t.Error("lookup in a missing range succeeded")
+	}
+}
+
+func TestLoadRangeDirWithoutRanges(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "README.md"), []byte("ranges go here"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if screener, err := Load(dir); err == nil {
+		t.Errorf("Load returned %v for a directory without range files", screener)
+	}
+}
@@ -0,0 +1,45 @@
+package breached
+
+import (
+	"fmt"
+	"os"
+)
+
+// Screener reports whether a password is known to have been breached.
+type Screener interface {
+	Contains(password string) (bool, error)
+}
+
+// Load opens the corpus at path: a directory is treated as a range file set
+// and a regular file as a bloom filter built by cmd/password-filter. An empty
+// path returns a nil Screener, meaning screening is disabled.
+func Load(path string) (Screener, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open breached password corpus: %w", err)
+	}
+
+	if info.IsDir() {
+		dir, err := OpenRangeDir(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load breached password ranges: %w", err)
+		}
+		return dir, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open breached password filter: %w", err)
+	}
+	defer f.Close()
+
+	filter, err := ReadBloomFilter(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load breached password filter: %w", err)
+	}
+	return filter, nil
+}
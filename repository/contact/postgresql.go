@@ -0,0 +1,395 @@
+package contact
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+
+	"github.com/CatalinPlesu/user-service/model"
+)
+
+var (
+	ErrNotExist        = errors.New("friend request does not exist")
+	ErrNotPending      = errors.New("friend request is no longer pending")
+	ErrSelf            = errors.New("users cannot befriend themselves")
+	ErrAlreadyContacts = errors.New("users are already contacts")
+	ErrRequestPending  = errors.New("a friend request between the users is already pending")
+	ErrNotContacts     = errors.New("users are not contacts")
+	// ErrBlockedBy means the recipient blocked the sender, ErrBlocking that
+	// the sender blocked the recipient.
+	ErrBlockedBy = errors.New("user is blocked by the recipient")
+	ErrBlocking  = errors.New("user blocked the recipient")
+)
+
+type PostgresRepo struct {
+	DB bun.IDB
+}
+
+func NewPostgresRepo(db bun.IDB) *PostgresRepo {
+	return &PostgresRepo{DB: db}
+}
+
+// WithTx returns a repository bound to tx, so contact changes can be
+// committed together with their events.
+func (p *PostgresRepo) WithTx(tx bun.Tx) *PostgresRepo {
+	return &PostgresRepo{DB: tx}
+}
+
+func (p *PostgresRepo) Migrate(ctx context.Context) error {
+	_, err := p.DB.NewCreateTable().
+		Model((*model.FriendRequest)(nil)).
+		IfNotExists().
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create friend requests table: %w", err)
+	}
+
+	_, err = p.DB.NewCreateTable().
+		Model((*model.Contact)(nil)).
+		IfNotExists().
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create contacts table: %w", err)
+	}
+
+	indexes := []*bun.CreateIndexQuery{
+		// At most one pending request per direction.
+		p.DB.NewCreateIndex().
+			Model((*model.FriendRequest)(nil)).
+			Index("friend_requests_pending_idx").
+			Unique().
+			Column("from_user_id", "to_user_id").
+			Where("status = ?", model.FriendRequestPending),
+		p.DB.NewCreateIndex().
+			Model((*model.FriendRequest)(nil)).
+			Index("friend_requests_to_idx").
+			Column("to_user_id", "status", "created_at"),
+		p.DB.NewCreateIndex().
+			Model((*model.FriendRequest)(nil)).
+			Index("friend_requests_from_idx").
+			Column("from_user_id", "status", "created_at"),
+		// The primary key covers listing a user's contacts; this one finds
+		// the rows that point at a user.
+		p.DB.NewCreateIndex().
+			Model((*model.Contact)(nil)).
+			Index("contacts_contact_idx").
+			Column("contact_id"),
+	}
+	for _, index := range indexes {
+		if _, err := index.IfNotExists().Exec(ctx); err != nil {
+			return fmt.Errorf("failed to create contacts index: %w", err)
+		}
+	}
+	return nil
+}
+
+// Send creates a pending friend request from one user to another, unless
+// they are already contacts, a request between them is pending or either
+// blocked the other.
+func (p *PostgresRepo) Send(ctx context.Context, fromUserID, toUserID uuid.UUID) (*model.FriendRequest, error) {
+	if fromUserID == toUserID {
+		return nil, ErrSelf
+	}
+
+	request := model.FriendRequest{
+		FromUserID: fromUserID,
+		ToUserID:   toUserID,
+		Status:     model.FriendRequestPending,
+		CreatedAt:  time.Now().UTC(),
+	}
+	err := p.DB.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		if err := lockPair(ctx, tx, fromUserID, toUserID); err != nil {
+			return err
+		}
+
+		// Blocking takes the same lock, so no request can be sent after a
+		// block cancelled the pending ones.
+		var blockers []uuid.UUID
+		err := tx.NewSelect().
+			Model((*model.Block)(nil)).
+			Column("blocker_id").
+			Where("blocker_id = ? AND blocked_id = ?", toUserID, fromUserID).
+			WhereOr("blocker_id = ? AND blocked_id = ?", fromUserID, toUserID).
+			Scan(ctx, &blockers)
+		if err != nil {
+			return fmt.Errorf("failed to check blocks: %w", err)
+		}
+		for _, blocker := range blockers {
+			if blocker == toUserID {
+				return ErrBlockedBy
+			}
+		}
+		if len(blockers) > 0 {
+			return ErrBlocking
+		}
+
+		contacts, err := tx.NewSelect().
+			Model((*model.Contact)(nil)).
+			Where("user_id = ?", fromUserID).
+			Where("contact_id = ?", toUserID).
+			Exists(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to check contacts: %w", err)
+		}
+		if contacts {
+			return ErrAlreadyContacts
+		}
+
+		pending, err := tx.NewSelect().
+			Model((*model.FriendRequest)(nil)).
+			Where("status = ?", model.FriendRequestPending).
+			WhereGroup(" AND ", func(q *bun.SelectQuery) *bun.SelectQuery {
+				return q.Where("from_user_id = ? AND to_user_id = ?", fromUserID, toUserID).
+					WhereOr("from_user_id = ? AND to_user_id = ?", toUserID, fromUserID)
+			}).
+			Exists(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to check friend requests: %w", err)
+		}
+		if pending {
+			return ErrRequestPending
+		}
+
+		_, err = tx.NewInsert().
+			Model(&request).
+			Returning("*").
+			Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to insert friend request: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &request, nil
+}
+
+// LockPair takes the lock that serialises changes between the two users,
+// whichever way they go, until the transaction p is bound to ends.
+func (p *PostgresRepo) LockPair(ctx context.Context, userID, otherID uuid.UUID) error {
+	return lockPair(ctx, p.DB, userID, otherID)
+}
+
+func lockPair(ctx context.Context, db bun.IDB, userID, otherID uuid.UUID) error {
+	_, err := db.NewRaw("SELECT pg_advisory_xact_lock(hashtext(?))", "contact:"+pairKey(userID, otherID)).Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to lock contacts: %w", err)
+	}
+	return nil
+}
+
+func pairKey(a, b uuid.UUID) string {
+	if a.String() > b.String() {
+		a, b = b, a
+	}
+	return a.String() + ":" + b.String()
+}
+
+// ListRequests returns the user's pending requests, the ones sent to them
+// when incoming is set and the ones they sent otherwise, newest first.
+func (p *PostgresRepo) ListRequests(ctx context.Context, userID uuid.UUID, incoming bool) ([]model.FriendRequest, error) {
+	column := "from_user_id"
+	if incoming {
+		column = "to_user_id"
+	}
+
+	var requests []model.FriendRequest
+	err := p.DB.NewSelect().
+		Model(&requests).
+		Where("? = ?", bun.Ident(column), userID).
+		Where("status = ?", model.FriendRequestPending).
+		Order("created_at DESC").
+		Scan(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list friend requests: %w", err)
+	}
+	return requests, nil
+}
+
+// Accept makes the two users of a request sent to userID contacts.
+func (p *PostgresRepo) Accept(ctx context.Context, id, userID uuid.UUID, now time.Time) (*model.FriendRequest, error) {
+	var request *model.FriendRequest
+	err := p.DB.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		// The pair is locked before the request's row, in the same order
+		// as blocking, which cancels the request.
+		var pending model.FriendRequest
+		err := tx.NewSelect().
+			Model(&pending).
+			Where("id = ?", id).
+			Scan(ctx)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotExist
+		} else if err != nil {
+			return fmt.Errorf("failed to find friend request: %w", err)
+		}
+		if err := lockPair(ctx, tx, pending.FromUserID, pending.ToUserID); err != nil {
+			return err
+		}
+
+		request, err = respond(ctx, tx, id, "to_user_id", userID, model.FriendRequestAccepted, now)
+		if err != nil {
+			return err
+		}
+
+		contacts := []model.Contact{
+			{UserID: request.FromUserID, ContactID: request.ToUserID, CreatedAt: now},
+			{UserID: request.ToUserID, ContactID: request.FromUserID, CreatedAt: now},
+		}
+		_, err = tx.NewInsert().
+			Model(&contacts).
+			On("CONFLICT DO NOTHING").
+			Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to insert contacts: %w", err)
+		}
+		return nil
+	})
+	return request, err
+}
+
+// Decline turns down a request sent to userID.
+func (p *PostgresRepo) Decline(ctx context.Context, id, userID uuid.UUID, now time.Time) (*model.FriendRequest, error) {
+	return respond(ctx, p.DB, id, "to_user_id", userID, model.FriendRequestDeclined, now)
+}
+
+// Cancel withdraws a request userID sent.
+func (p *PostgresRepo) Cancel(ctx context.Context, id, userID uuid.UUID, now time.Time) (*model.FriendRequest, error) {
+	return respond(ctx, p.DB, id, "from_user_id", userID, model.FriendRequestCancelled, now)
+}
+
+// respond moves a pending request that userID is the party of in column to
+// status. Requests of other users do not exist as far as userID is
+// concerned.
+func respond(ctx context.Context, db bun.IDB, id uuid.UUID, column string, userID uuid.UUID, status string, now time.Time) (*model.FriendRequest, error) {
+	var request model.FriendRequest
+	res, err := db.NewUpdate().
+		Model(&request).
+		Set("status = ?", status).
+		Set("responded_at = ?", now).
+		Where("id = ?", id).
+		Where("? = ?", bun.Ident(column), userID).
+		Where("status = ?", model.FriendRequestPending).
+		Returning("*").
+		Exec(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update friend request: %w", err)
+	}
+
+	if n, _ := res.RowsAffected(); n > 0 {
+		return &request, nil
+	}
+
+	exists, err := db.NewSelect().
+		Model((*model.FriendRequest)(nil)).
+		Where("id = ?", id).
+		Where("? = ?", bun.Ident(column), userID).
+		Exists(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find friend request: %w", err)
+	}
+	if exists {
+		return nil, ErrNotPending
+	}
+	return nil, ErrNotExist
+}
+
+// CancelBetween cancels the pending friend requests between the two users,
+// whichever way they go.
+func (p *PostgresRepo) CancelBetween(ctx context.Context, userID, otherID uuid.UUID, now time.Time) error {
+	_, err := p.DB.NewUpdate().
+		Model((*model.FriendRequest)(nil)).
+		Set("status = ?", model.FriendRequestCancelled).
+		Set("responded_at = ?", now).
+		Where("status = ?", model.FriendRequestPending).
+		WhereGroup(" AND ", func(q *bun.UpdateQuery) *bun.UpdateQuery {
+			return q.Where("from_user_id = ? AND to_user_id = ?", userID, otherID).
+				WhereOr("from_user_id = ? AND to_user_id = ?", otherID, userID)
+		}).
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to cancel friend requests: %w", err)
+	}
+	return nil
+}
+
+// Remove ends the contact relationship between the two users on both sides.
+func (p *PostgresRepo) Remove(ctx context.Context, userID, contactID uuid.UUID) error {
+	res, err := p.DB.NewDelete().
+		Model((*model.Contact)(nil)).
+		WhereGroup(" AND ", func(q *bun.DeleteQuery) *bun.DeleteQuery {
+			return q.Where("user_id = ? AND contact_id = ?", userID, contactID).
+				WhereOr("user_id = ? AND contact_id = ?", contactID, userID)
+		}).
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to delete contact: %w", err)
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotContacts
+	}
+	return nil
+}
+
+func (p *PostgresRepo) AreContacts(ctx context.Context, userID, otherID uuid.UUID) (bool, error) {
+	exists, err := p.DB.NewSelect().
+		Model((*model.Contact)(nil)).
+		Where("user_id = ?", userID).
+		Where("contact_id = ?", otherID).
+		Exists(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to check contacts: %w", err)
+	}
+	return exists, nil
+}
+
+// Page selects up to Limit contacts ordered by ID, starting after After.
+type Page struct {
+	After uuid.UUID
+	Limit int
+}
+
+// List returns a page of the user's contacts along with their users.
+// Contacts whose account is deleted have a nil User.
+func (p *PostgresRepo) List(ctx context.Context, userID uuid.UUID, page Page) ([]model.Contact, error) {
+	return p.list(ctx, page, func(q *bun.SelectQuery) *bun.SelectQuery {
+		return q.Where("contact.user_id = ?", userID)
+	})
+}
+
+// Mutual returns a page of the contacts the two users have in common.
+func (p *PostgresRepo) Mutual(ctx context.Context, userID, otherID uuid.UUID, page Page) ([]model.Contact, error) {
+	others := p.DB.NewSelect().
+		Model((*model.Contact)(nil)).
+		Column("contact_id").
+		Where("user_id = ?", otherID)
+
+	return p.list(ctx, page, func(q *bun.SelectQuery) *bun.SelectQuery {
+		return q.Where("contact.user_id = ?", userID).
+			Where("contact.contact_id IN (?)", others)
+	})
+}
+
+func (p *PostgresRepo) list(ctx context.Context, page Page, filter func(*bun.SelectQuery) *bun.SelectQuery) ([]model.Contact, error) {
+	contacts := []model.Contact{}
+	query := p.DB.NewSelect().
+		Model(&contacts).
+		Relation("User").
+		Apply(filter).
+		Order("contact.contact_id").
+		Limit(page.Limit)
+	if page.After != uuid.Nil {
+		query.Where("contact.contact_id > ?", page.After)
+	}
+
+	if err := query.Scan(ctx); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("failed to list contacts: %w", err)
+	}
+	return contacts, nil
+}
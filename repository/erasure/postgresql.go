@@ -0,0 +1,186 @@
+package erasure
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/pgdialect"
+
+	"github.com/CatalinPlesu/user-service/model"
+)
+
+var ErrNotExist = errors.New("erasure certificate does not exist")
+
+type PostgresRepo struct {
+	DB bun.IDB
+}
+
+func NewPostgresRepo(db bun.IDB) *PostgresRepo {
+	return &PostgresRepo{DB: db}
+}
+
+func (p *PostgresRepo) WithTx(tx bun.Tx) *PostgresRepo {
+	return &PostgresRepo{DB: tx}
+}
+
+func (p *PostgresRepo) Migrate(ctx context.Context) error {
+	models := []interface{}{
+		(*model.ErasureCertificate)(nil),
+		(*model.ErasureAcknowledgement)(nil),
+	}
+	for _, m := range models {
+		_, err := p.DB.NewCreateTable().
+			Model(m).
+			IfNotExists().
+			Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to create erasure tables: %w", err)
+		}
+	}
+
+	_, err := p.DB.NewCreateIndex().
+		Model((*model.ErasureCertificate)(nil)).
+		Index("erasure_certificates_subject_idx").
+		Column("subject_hash").
+		IfNotExists().
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create erasure certificates index: %w", err)
+	}
+	return nil
+}
+
+// SubjectHash identifies a user on a certificate. It is keyed, so the hash
+// cannot be reversed by hashing every possible user ID without the key.
+func SubjectHash(key string, userID uuid.UUID) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(userID.String()))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (p *PostgresRepo) Insert(ctx context.Context, certificate *model.ErasureCertificate) error {
+	_, err := p.DB.NewInsert().
+		Model(certificate).
+		Returning("*").
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to insert erasure certificate: %w", err)
+	}
+	return nil
+}
+
+func (p *PostgresRepo) FindByID(ctx context.Context, id uuid.UUID) (*model.ErasureCertificate, error) {
+	var certificate model.ErasureCertificate
+	err := p.DB.NewSelect().
+		Model(&certificate).
+		Relation("Acknowledgements").
+		Where("id = ?", id).
+		Scan(ctx)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotExist
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to find erasure certificate: %w", err)
+	}
+	return &certificate, nil
+}
+
+func (p *PostgresRepo) FindBySubject(ctx context.Context, subjectHash string) ([]model.ErasureCertificate, error) {
+	certificates := []model.ErasureCertificate{}
+	err := p.DB.NewSelect().
+		Model(&certificates).
+		Relation("Acknowledgements").
+		Where("subject_hash = ?", subjectHash).
+		Order("erased_at").
+		Scan(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find erasure certificates: %w", err)
+	}
+	return certificates, nil
+}
+
+// AddSteps records steps that completed after the certificate was issued.
+func (p *PostgresRepo) AddSteps(ctx context.Context, id uuid.UUID, steps ...string) error {
+	_, err := p.DB.NewUpdate().
+		Model((*model.ErasureCertificate)(nil)).
+		Set("steps = steps || ?", pgdialect.Array(steps)).
+		Where("id = ?", id).
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to add erasure steps: %w", err)
+	}
+	return nil
+}
+
+// Acknowledge records that service erased the user, completing the
+// certificate once every required service has. Repeated acknowledgements
+// are ignored.
+func (p *PostgresRepo) Acknowledge(ctx context.Context, id uuid.UUID, service string, now time.Time) error {
+	return p.DB.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		var certificate model.ErasureCertificate
+		err := tx.NewSelect().
+			Model(&certificate).
+			Where("id = ?", id).
+			For("UPDATE").
+			Scan(ctx)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotExist
+		} else if err != nil {
+			return fmt.Errorf("failed to find erasure certificate: %w", err)
+		}
+
+		_, err = tx.NewInsert().
+			Model(&model.ErasureAcknowledgement{
+				CertificateID:  id,
+				Service:        service,
+				AcknowledgedAt: now,
+			}).
+			On("CONFLICT DO NOTHING").
+			Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to insert erasure acknowledgement: %w", err)
+		}
+
+		if certificate.Status == model.ErasureCompleted {
+			return nil
+		}
+
+		var acknowledged []string
+		err = tx.NewSelect().
+			Model((*model.ErasureAcknowledgement)(nil)).
+			Column("service").
+			Where("certificate_id = ?", id).
+			Scan(ctx, &acknowledged)
+		if err != nil {
+			return fmt.Errorf("failed to list erasure acknowledgements: %w", err)
+		}
+
+		done := make(map[string]bool, len(acknowledged))
+		for _, s := range acknowledged {
+			done[s] = true
+		}
+		for _, s := range certificate.RequiredAcks {
+			if !done[s] {
+				return nil
+			}
+		}
+
+		_, err = tx.NewUpdate().
+			Model((*model.ErasureCertificate)(nil)).
+			Set("status = ?", model.ErasureCompleted).
+			Set("completed_at = ?", now).
+			Where("id = ?", id).
+			Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to complete erasure certificate: %w", err)
+		}
+		return nil
+	})
+}
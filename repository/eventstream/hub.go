@@ -0,0 +1,90 @@
+package eventstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const subscriberBuffer = 64
+
+// Hub tails the stream with a single blocking read per replica and fans new
+// entries out to the local subscribers. A subscriber that falls behind is
+// dropped; it can reconnect and resume from its last event ID.
+type Hub struct {
+	Repo *RedisRepo
+
+	mu          sync.Mutex
+	subscribers map[chan Entry]struct{}
+}
+
+func NewHub(repo *RedisRepo) *Hub {
+	return &Hub{
+		Repo:        repo,
+		subscribers: map[chan Entry]struct{}{},
+	}
+}
+
+func (h *Hub) Run(ctx context.Context) {
+	last := "$"
+	for ctx.Err() == nil {
+		entries, err := h.Repo.Read(ctx, last, 5*time.Second)
+		if err != nil {
+			if !errors.Is(err, context.Canceled) {
+				fmt.Println("failed to tail event stream:", err)
+			}
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+
+		for _, entry := range entries {
+			last = entry.ID
+			h.broadcast(entry)
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subscribers {
+		close(ch)
+		delete(h.subscribers, ch)
+	}
+}
+
+// Subscribe returns a channel of new entries and a function to stop
+// receiving them. The channel is closed if the subscriber is dropped.
+func (h *Hub) Subscribe() (<-chan Entry, func()) {
+	ch := make(chan Entry, subscriberBuffer)
+
+	h.mu.Lock()
+	h.subscribers[ch] = struct{}{}
+	h.mu.Unlock()
+
+	return ch, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if _, ok := h.subscribers[ch]; ok {
+			close(ch)
+			delete(h.subscribers, ch)
+		}
+	}
+}
+
+func (h *Hub) broadcast(entry Entry) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for ch := range h.subscribers {
+		select {
+		case ch <- entry:
+		default:
+			close(ch)
+			delete(h.subscribers, ch)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package eventstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+const streamKey = "user_events"
+
+var (
+	ErrInvalidID = errors.New("invalid event stream ID")
+	// ErrTrimmed means events following the requested ID may already have
+	// been dropped from the replay buffer.
+	ErrTrimmed = errors.New("events were trimmed from the stream")
+)
+
+type Entry struct {
+	ID     string
+	Type   string
+	UserID string
+	Data   []byte // The CloudEvent in its JSON format
+}
+
+// RedisRepo keeps a bounded replay buffer of recent user events in a Redis
+// stream, shared by every replica. Only events of the given Types are kept.
+type RedisRepo struct {
+	Client *redis.Client
+	MaxLen int64
+	Types  map[string]bool
+}
+
+func NewRedisRepo(client *redis.Client, eventTypes ...string) *RedisRepo {
+	types := make(map[string]bool, len(eventTypes))
+	for _, t := range eventTypes {
+		types[t] = true
+	}
+
+	return &RedisRepo{
+		Client: client,
+		MaxLen: 10000,
+		Types:  types,
+	}
+}
+
+// Append adds an event to the stream, trimming it to roughly MaxLen
+// entries. Events of other types are ignored.
+func (r *RedisRepo) Append(ctx context.Context, eventType string, userID uuid.UUID, data []byte) error {
+	if !r.Types[eventType] {
+		return nil
+	}
+
+	err := r.Client.XAdd(ctx, &redis.XAddArgs{
+		Stream: streamKey,
+		MaxLen: r.MaxLen,
+		Approx: true,
+		Values: map[string]interface{}{
+			"type":    eventType,
+			"user_id": userID.String(),
+			"data":    data,
+		},
+	}).Err()
+	if err != nil {
+		return fmt.Errorf("failed to append to event stream: %w", err)
+	}
+	return nil
+}
+
+// After returns up to count entries following id, oldest first. Together
+// with the entries it returns ErrTrimmed when the oldest entry still
+// buffered is newer than id.
+func (r *RedisRepo) After(ctx context.Context, id string, count int64) ([]Entry, error) {
+	if _, _, err := parseID(id); err != nil {
+		return nil, err
+	}
+
+	messages, err := r.Client.XRangeN(ctx, streamKey, "("+id, "+", count).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read event stream: %w", err)
+	}
+
+	oldest, err := r.Client.XRangeN(ctx, streamKey, "-", "+", 1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read event stream: %w", err)
+	}
+
+	entries := toEntries(messages)
+	if len(oldest) > 0 && Less(id, oldest[0].ID) {
+		return entries, ErrTrimmed
+	}
+	return entries, nil
+}
+
+// Read blocks for up to block waiting for entries following id, which may
+// be "$" to only wait for new ones.
+func (r *RedisRepo) Read(ctx context.Context, id string, block time.Duration) ([]Entry, error) {
+	streams, err := r.Client.XRead(ctx, &redis.XReadArgs{
+		Streams: []string{streamKey, id},
+		Block:   block,
+	}).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read event stream: %w", err)
+	}
+
+	var entries []Entry
+	for _, stream := range streams {
+		entries = append(entries, toEntries(stream.Messages)...)
+	}
+	return entries, nil
+}
+
+// DeleteUser removes every buffered entry about the user and returns how
+// many there were.
+func (r *RedisRepo) DeleteUser(ctx context.Context, userID uuid.UUID) (int64, error) {
+	const page = 1000
+
+	var deleted int64
+	start := "-"
+	for {
+		messages, err := r.Client.XRangeN(ctx, streamKey, start, "+", page).Result()
+		if err != nil {
+			return deleted, fmt.Errorf("failed to read event stream: %w", err)
+		}
+
+		var ids []string
+		for _, entry := range toEntries(messages) {
+			if entry.UserID == userID.String() {
+				ids = append(ids, entry.ID)
+			}
+		}
+		if len(ids) > 0 {
+			n, err := r.Client.XDel(ctx, streamKey, ids...).Result()
+			if err != nil {
+				return deleted, fmt.Errorf("failed to delete from event stream: %w", err)
+			}
+			deleted += n
+		}
+
+		if len(messages) < page {
+			return deleted, nil
+		}
+		start = "(" + messages[len(messages)-1].ID
+	}
+}
+
+func toEntries(messages []redis.XMessage) []Entry {
+	entries := make([]Entry, len(messages))
+	for i, m := range messages {
+		eventType, _ := m.Values["type"].(string)
+		userID, _ := m.Values["user_id"].(string)
+		data, _ := m.Values["data"].(string)
+
+		entries[i] = Entry{
+			ID:     m.ID,
+			Type:   eventType,
+			UserID: userID,
+			Data:   []byte(data),
+		}
+	}
+	return entries
+}
+
+// Less reports whether stream ID a comes before b. Invalid IDs sort first.
+func Less(a, b string) bool {
+	aMS, aSeq, _ := parseID(a)
+	bMS, bSeq, _ := parseID(b)
+	if aMS != bMS {
+		return aMS < bMS
+	}
+	return aSeq < bSeq
+}
+
+func parseID(id string) (uint64, uint64, error) {
+	msPart, seqPart, ok := strings.Cut(id, "-")
+	if !ok {
+		return 0, 0, ErrInvalidID
+	}
+
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return 0, 0, ErrInvalidID
+	}
+	seq, err := strconv.ParseUint(seqPart, 10, 64)
+	if err != nil {
+		return 0, 0, ErrInvalidID
+	}
+	return ms, seq, nil
+}
+
+// ValidID reports whether id has the form of a stream entry ID.
+func ValidID(id string) bool {
+	_, _, err := parseID(id)
+	return err == nil
+}
@@ -0,0 +1,77 @@
+package export
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Section is one part of an export, written to the archive as
+// "<Name>.json". Collect returns the data to encode for the user.
+type Section struct {
+	Name    string
+	Collect func(ctx context.Context, userID uuid.UUID) (any, error)
+}
+
+type manifest struct {
+	UserID      uuid.UUID `json:"user_id"`
+	GeneratedAt time.Time `json:"generated_at"`
+	Sections    []string  `json:"sections"`
+}
+
+// WriteArchive writes a ZIP archive with a manifest and one JSON file per
+// section to w. Any failing section fails the whole export, since a partial
+// copy of someone's data is not what they asked for.
+func WriteArchive(ctx context.Context, w io.Writer, userID uuid.UUID, sections []Section, now time.Time) error {
+	archive := zip.NewWriter(w)
+
+	m := manifest{
+		UserID:      userID,
+		GeneratedAt: now.UTC(),
+		Sections:    make([]string, len(sections)),
+	}
+	for i, section := range sections {
+		m.Sections[i] = section.Name
+	}
+	if err := writeJSON(archive, "manifest.json", m, now); err != nil {
+		return err
+	}
+
+	for _, section := range sections {
+		data, err := section.Collect(ctx, userID)
+		if err != nil {
+			return fmt.Errorf("failed to collect %s: %w", section.Name, err)
+		}
+		if err := writeJSON(archive, section.Name+".json", data, now); err != nil {
+			return err
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		return fmt.Errorf("failed to write export archive: %w", err)
+	}
+	return nil
+}
+
+func writeJSON(archive *zip.Writer, name string, v any, now time.Time) error {
+	f, err := archive.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: now,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to add %s to export archive: %w", name, err)
+	}
+
+	encoder := json.NewEncoder(f)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		return fmt.Errorf("failed to encode %s: %w", name, err)
+	}
+	return nil
+}
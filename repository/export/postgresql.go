@@ -0,0 +1,195 @@
+package export
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+
+	"github.com/CatalinPlesu/user-service/model"
+)
+
+var ErrNotExist = errors.New("export does not exist")
+
+type PostgresRepo struct {
+	DB bun.IDB
+}
+
+func NewPostgresRepo(db bun.IDB) *PostgresRepo {
+	return &PostgresRepo{DB: db}
+}
+
+// WithTx returns a repository bound to tx, so an export can be marked ready
+// in the same transaction as the event announcing it.
+func (p *PostgresRepo) WithTx(tx bun.Tx) *PostgresRepo {
+	return &PostgresRepo{DB: tx}
+}
+
+func (p *PostgresRepo) Migrate(ctx context.Context) error {
+	_, err := p.DB.NewCreateTable().
+		Model((*model.DataExport)(nil)).
+		IfNotExists().
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create data exports table: %w", err)
+	}
+
+	_, err = p.DB.NewCreateIndex().
+		Model((*model.DataExport)(nil)).
+		Index("data_exports_user_idx").
+		Column("user_id", "requested_at").
+		IfNotExists().
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create data exports index: %w", err)
+	}
+	return nil
+}
+
+// Request returns the user's export that is still being prepared, or
+// inserts a new pending one, so repeated requests do not queue up work.
+func (p *PostgresRepo) Request(ctx context.Context, userID uuid.UUID) (*model.DataExport, error) {
+	var export model.DataExport
+	err := p.DB.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		// Serialises concurrent requests of the same user.
+		_, err := tx.NewRaw("SELECT pg_advisory_xact_lock(hashtext(?))", "data_export:"+userID.String()).Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to lock data exports: %w", err)
+		}
+
+		err = tx.NewSelect().
+			Model(&export).
+			Where("user_id = ?", userID).
+			Where("status IN (?)", bun.In([]string{model.DataExportPending, model.DataExportProcessing})).
+			Limit(1).
+			Scan(ctx)
+		if err == nil {
+			return nil
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("failed to find data export: %w", err)
+		}
+
+		export = model.DataExport{
+			UserID: userID,
+			Status: model.DataExportPending,
+		}
+		_, err = tx.NewInsert().
+			Model(&export).
+			Returning("*").
+			Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to insert data export: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &export, nil
+}
+
+func (p *PostgresRepo) FindByID(ctx context.Context, userID, id uuid.UUID) (*model.DataExport, error) {
+	var export model.DataExport
+	err := p.DB.NewSelect().
+		Model(&export).
+		Where("id = ?", id).
+		Where("user_id = ?", userID).
+		Scan(ctx)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotExist
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to find data export: %w", err)
+	}
+	return &export, nil
+}
+
+func (p *PostgresRepo) ListByUserID(ctx context.Context, userID uuid.UUID) ([]model.DataExport, error) {
+	exports := []model.DataExport{}
+	err := p.DB.NewSelect().
+		Model(&exports).
+		Where("user_id = ?", userID).
+		Order("requested_at DESC").
+		Scan(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list data exports: %w", err)
+	}
+	return exports, nil
+}
+
+// Claim marks the oldest pending export as processing and returns it, or
+// ErrNotExist when there is nothing to do. Exports whose worker stopped
+// before lease ran out are claimed again.
+func (p *PostgresRepo) Claim(ctx context.Context, now time.Time, lease time.Duration) (*model.DataExport, error) {
+	var export model.DataExport
+	err := p.DB.NewRaw(`
+		UPDATE data_exports
+		SET status = ?, started_at = ?
+		WHERE id = (
+			SELECT id FROM data_exports
+			WHERE status = ? OR (status = ? AND started_at < ?)
+			ORDER BY requested_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING *`,
+		model.DataExportProcessing, now,
+		model.DataExportPending, model.DataExportProcessing, now.Add(-lease),
+	).Scan(ctx, &export)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotExist
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to claim data export: %w", err)
+	}
+	return &export, nil
+}
+
+func (p *PostgresRepo) MarkReady(ctx context.Context, id uuid.UUID, fileKey string, size int64, now, expiresAt time.Time) error {
+	_, err := p.DB.NewUpdate().
+		Model((*model.DataExport)(nil)).
+		Set("status = ?", model.DataExportReady).
+		Set("file_key = ?", fileKey).
+		Set("size = ?", size).
+		Set("completed_at = ?", now).
+		Set("expires_at = ?", expiresAt).
+		Where("id = ?", id).
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to mark data export ready: %w", err)
+	}
+	return nil
+}
+
+func (p *PostgresRepo) MarkFailed(ctx context.Context, id uuid.UUID, cause error, now time.Time) error {
+	_, err := p.DB.NewUpdate().
+		Model((*model.DataExport)(nil)).
+		Set("status = ?", model.DataExportFailed).
+		Set("error = ?", cause.Error()).
+		Set("completed_at = ?", now).
+		Where("id = ?", id).
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to mark data export failed: %w", err)
+	}
+	return nil
+}
+
+// ExpireBefore marks ready exports that expired before cutoff as expired
+// and returns them, so their archives can be deleted.
+func (p *PostgresRepo) ExpireBefore(ctx context.Context, cutoff time.Time) ([]model.DataExport, error) {
+	exports := []model.DataExport{}
+	_, err := p.DB.NewUpdate().
+		Model((*model.DataExport)(nil)).
+		Set("status = ?", model.DataExportExpired).
+		Where("status = ?", model.DataExportReady).
+		Where("expires_at < ?", cutoff).
+		Returning("*").
+		Exec(ctx, &exports)
+	if err != nil {
+		return nil, fmt.Errorf("failed to expire data exports: %w", err)
+	}
+	return exports, nil
+}
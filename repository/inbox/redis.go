@@ -0,0 +1,83 @@
+package inbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var ErrInProgress = errors.New("message is being processed")
+
+const (
+	stateProcessing = "processing"
+	stateDone       = "done"
+)
+
+// RedisRepo records which inbound messages have been processed so a
+// redelivered message is not handled twice. A claim holds a message for
+// Lease while it is processed; completed messages are remembered for
+// Retention.
+type RedisRepo struct {
+	Client    *redis.Client
+	Lease     time.Duration
+	Retention time.Duration
+}
+
+func NewRedisRepo(client *redis.Client) *RedisRepo {
+	return &RedisRepo{
+		Client:    client,
+		Lease:     time.Minute,
+		Retention: 7 * 24 * time.Hour,
+	}
+}
+
+func processedKey(id string) string {
+	return fmt.Sprintf("processed_messages:%s", id)
+}
+
+// Claim marks the message as being processed. It returns false if the
+// message was already processed, and ErrInProgress if another consumer holds
+// it right now.
+func (r *RedisRepo) Claim(ctx context.Context, id string) (bool, error) {
+	claimed, err := r.Client.SetNX(ctx, processedKey(id), stateProcessing, r.Lease).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to claim message: %w", err)
+	}
+	if claimed {
+		return true, nil
+	}
+
+	state, err := r.Client.Get(ctx, processedKey(id)).Result()
+	if errors.Is(err, redis.Nil) {
+		// The other claim expired in between; try again.
+		return r.Claim(ctx, id)
+	} else if err != nil {
+		return false, fmt.Errorf("failed to get message state: %w", err)
+	}
+
+	if state == stateProcessing {
+		return false, ErrInProgress
+	}
+	return false, nil
+}
+
+func (r *RedisRepo) Complete(ctx context.Context, id string) error {
+	err := r.Client.Set(ctx, processedKey(id), stateDone, r.Retention).Err()
+	if err != nil {
+		return fmt.Errorf("failed to complete message: %w", err)
+	}
+	return nil
+}
+
+// Release drops a claim after processing failed so the message can be
+// retried.
+func (r *RedisRepo) Release(ctx context.Context, id string) error {
+	err := r.Client.Del(ctx, processedKey(id)).Err()
+	if err != nil {
+		return fmt.Errorf("failed to release message: %w", err)
+	}
+	return nil
+}
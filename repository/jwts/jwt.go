@@ -1,6 +1,7 @@
 package jwts
 
 import (
+	"errors"
 	"time"
 	"github.com/golang-jwt/jwt"
 	"github.com/google/uuid"
@@ -9,26 +10,52 @@ import (
 var jwtKey = []byte("your-secret-key") 
 var appName = "chatApp"
 
+// Challenge tokens are issued after a correct password when the user has MFA
+// enabled. They carry their own audience so they are never accepted as a
+// session token.
+const mfaAudience = "mfa_challenge"
+const mfaChallengeTTL = 5 * time.Minute
+
+var ErrInvalidToken = errors.New("invalid token")
+
 type Claims struct {
 	UserID uuid.UUID `json:"user_id"` 
 	jwt.StandardClaims
 }
 
-func GenerateJWT(userID uuid.UUID) (string, error) {
-	expirationTime := time.Now().Add(24 * time.Hour) 
+// Session describes an issued session token. ID is the token's jti claim and
+// can be shared with other services in place of the token itself.
+type Session struct {
+	Token     string
+	ID        string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+func GenerateSession(userID uuid.UUID) (Session, error) {
+	issuedAt := time.Now().UTC().Truncate(time.Second)
+	expirationTime := issuedAt.Add(24 * time.Hour)
+	sessionID := uuid.NewString()
 	claims := &Claims{
 		UserID: userID,
 		StandardClaims: jwt.StandardClaims{
+			Id:        sessionID,
+			IssuedAt:  issuedAt.Unix(),
 			ExpiresAt: expirationTime.Unix(),
-			Issuer:    appName, 
+			Issuer:    appName,
 		},
 	}
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	tokenString, err := token.SignedString(jwtKey)
 	if err != nil {
-		return "", err
+		return Session{}, err
 	}
-	return tokenString, nil
+	return Session{
+		Token:     tokenString,
+		ID:        sessionID,
+		IssuedAt:  issuedAt,
+		ExpiresAt: expirationTime,
+	}, nil
 }
 
 func ValidateJWT(tokenString string) (*Claims, error) {
@@ -38,8 +65,34 @@ func ValidateJWT(tokenString string) (*Claims, error) {
 	if err != nil {
 		return nil, err
 	}
-	if claims, ok := token.Claims.(*Claims); ok && token.Valid {
+	if claims, ok := token.Claims.(*Claims); ok && token.Valid && claims.Audience != mfaAudience {
+		return claims, nil
+	}
+	return nil, ErrInvalidToken
+}
+
+func GenerateMFAChallenge(userID uuid.UUID) (string, error) {
+	claims := &Claims{
+		UserID: userID,
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: time.Now().Add(mfaChallengeTTL).Unix(),
+			Issuer:    appName,
+			Audience:  mfaAudience,
+		},
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(jwtKey)
+}
+
+func ValidateMFAChallenge(tokenString string) (*Claims, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
+		return jwtKey, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if claims, ok := token.Claims.(*Claims); ok && token.Valid && claims.Audience == mfaAudience {
 		return claims, nil
 	}
-	return nil, err
+	return nil, ErrInvalidToken
 }
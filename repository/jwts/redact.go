@@ -0,0 +1,15 @@
+package jwts
+
+import "regexp"
+
+// A JWS compact serialization: base64url header (always starting with
+// "eyJ", the encoding of `{"`), payload and signature.
+var tokenPattern = regexp.MustCompile(`eyJ[A-Za-z0-9_-]*\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+`)
+
+const redacted = "[REDACTED]"
+
+// Redact replaces anything that looks like a JWT in s, for use before a
+// string reaches a log.
+func Redact(s string) string {
+	return tokenPattern.ReplaceAllString(s, redacted)
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/redis/go-redis/v9"
@@ -85,3 +86,104 @@ func (r *RedisRepo) Update(ctx context.Context, userID uuid.UUID, jwts []string)
 
 	return nil
 }
+
+func (r *RedisRepo) Contains(ctx context.Context, userID uuid.UUID, jwt string) (bool, error) {
+	value, err := r.Client.Get(ctx, userJWTsKey(userID)).Result()
+	if errors.Is(err, redis.Nil) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("failed to get user JWTs: %w", err)
+	}
+
+	var userJWTs model.UserJWTs
+	if err := json.Unmarshal([]byte(value), &userJWTs); err != nil {
+		return false, fmt.Errorf("failed to decode user JWTs json: %w", err)
+	}
+
+	for _, existingJWT := range userJWTs.JWTs {
+		if existingJWT == jwt {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Remove revokes one session of the user.
+func (r *RedisRepo) Remove(ctx context.Context, userID uuid.UUID, jwt string) error {
+	value, err := r.Client.Get(ctx, userJWTsKey(userID)).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to get user JWTs: %w", err)
+	}
+
+	var userJWTs model.UserJWTs
+	if err := json.Unmarshal([]byte(value), &userJWTs); err != nil {
+		return fmt.Errorf("failed to decode user JWTs json: %w", err)
+	}
+
+	kept := []string{}
+	for _, existingJWT := range userJWTs.JWTs {
+		if existingJWT != jwt {
+			kept = append(kept, existingJWT)
+		}
+	}
+	return r.Update(ctx, userID, kept)
+}
+
+// DeleteAll revokes every session of the user.
+func (r *RedisRepo) DeleteAll(ctx context.Context, userID uuid.UUID) error {
+	err := r.Client.Del(ctx, userJWTsKey(userID)).Err()
+	if err != nil {
+		return fmt.Errorf("failed to delete user JWTs: %w", err)
+	}
+	return nil
+}
+
+// Sessions returns the user's active sessions, skipping tokens that have
+// expired but were not removed yet.
+func (r *RedisRepo) Sessions(ctx context.Context, userID uuid.UUID) ([]Session, error) {
+	value, err := r.Client.Get(ctx, userJWTsKey(userID)).Result()
+	if errors.Is(err, redis.Nil) {
+		return []Session{}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get user JWTs: %w", err)
+	}
+
+	var userJWTs model.UserJWTs
+	if err := json.Unmarshal([]byte(value), &userJWTs); err != nil {
+		return nil, fmt.Errorf("failed to decode user JWTs json: %w", err)
+	}
+
+	sessions := []Session{}
+	for _, token := range userJWTs.JWTs {
+		claims, err := ValidateJWT(token)
+		if err != nil {
+			continue
+		}
+
+		sessions = append(sessions, Session{
+			Token:     token,
+			ID:        claims.Id,
+			IssuedAt:  time.Unix(claims.IssuedAt, 0).UTC(),
+			ExpiresAt: time.Unix(claims.ExpiresAt, 0).UTC(),
+		})
+	}
+	return sessions, nil
+}
+
+// Session returns the user's active session with the given ID, or
+// ErrJWTNotFound if it was revoked or has expired.
+func (r *RedisRepo) Session(ctx context.Context, userID uuid.UUID, sessionID string) (Session, error) {
+	sessions, err := r.Sessions(ctx, userID)
+	if err != nil {
+		return Session{}, err
+	}
+
+	for _, session := range sessions {
+		if session.ID == sessionID {
+			return session, nil
+		}
+	}
+	return Session{}, ErrJWTNotFound
+}
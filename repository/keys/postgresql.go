@@ -0,0 +1,136 @@
+package keys
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+
+	"github.com/CatalinPlesu/user-service/model"
+)
+
+// ErrShredded means the user's key was deleted, so data encrypted with it
+// can no longer be read.
+var ErrShredded = errors.New("user key was shredded")
+
+var errCiphertext = errors.New("ciphertext too short")
+
+// PostgresRepo keeps one data key per user for crypto-shredding: data about
+// a user that outlives its row, such as export archives, is encrypted with
+// their key, and erasing the user deletes the key.
+type PostgresRepo struct {
+	DB  bun.IDB
+	KEK []byte // Wraps the stored user keys
+}
+
+// NewPostgresRepo derives the key encryption key from secret.
+func NewPostgresRepo(db bun.IDB, secret string) *PostgresRepo {
+	kek := sha256.Sum256([]byte(secret))
+	return &PostgresRepo{DB: db, KEK: kek[:]}
+}
+
+func (p *PostgresRepo) WithTx(tx bun.Tx) *PostgresRepo {
+	return &PostgresRepo{DB: tx, KEK: p.KEK}
+}
+
+func (p *PostgresRepo) Migrate(ctx context.Context) error {
+	_, err := p.DB.NewCreateTable().
+		Model((*model.UserKey)(nil)).
+		IfNotExists().
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create user keys table: %w", err)
+	}
+	return nil
+}
+
+// DataKey returns the user's key, creating it on first use.
+func (p *PostgresRepo) DataKey(ctx context.Context, userID uuid.UUID) ([]byte, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate user key: %w", err)
+	}
+
+	wrapped, err := Seal(p.KEK, key)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = p.DB.NewInsert().
+		Model(&model.UserKey{UserID: userID, Key: wrapped}).
+		On("CONFLICT (user_id) DO NOTHING").
+		Exec(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert user key: %w", err)
+	}
+
+	return p.Find(ctx, userID)
+}
+
+// Find returns the user's key, or ErrShredded when there is none.
+func (p *PostgresRepo) Find(ctx context.Context, userID uuid.UUID) ([]byte, error) {
+	var userKey model.UserKey
+	err := p.DB.NewSelect().
+		Model(&userKey).
+		Where("user_id = ?", userID).
+		Scan(ctx)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrShredded
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to find user key: %w", err)
+	}
+
+	key, err := Open(p.KEK, userKey.Key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap user key: %w", err)
+	}
+	return key, nil
+}
+
+// Seal encrypts plaintext with AES-256-GCM, prefixing the random nonce.
+func Seal(key, plaintext []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// Open decrypts ciphertext produced by Seal.
+func Open(key, ciphertext []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errCiphertext
+	}
+	nonce, sealed := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+
+	plaintext, err := aead.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt: %w", err)
+	}
+	return plaintext, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
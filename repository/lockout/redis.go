@@ -0,0 +1,191 @@
+package lockout
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+type Policy struct {
+	Window          time.Duration // Failures older than this are forgotten
+	MaxFailures     int           // Per-account failures in Window before lockout
+	MaxIPFailures   int           // Per-IP failures in Window before lockout
+	LockoutDuration time.Duration
+	BaseDelay       time.Duration // Delay after the first failure, doubled for each further one
+	MaxDelay        time.Duration
+}
+
+func DefaultPolicy() Policy {
+	return Policy{
+		Window:          15 * time.Minute,
+		MaxFailures:     5,
+		MaxIPFailures:   20,
+		LockoutDuration: 15 * time.Minute,
+		BaseDelay:       time.Second,
+		MaxDelay:        30 * time.Second,
+	}
+}
+
+// RedisRepo tracks failed login attempts per account identifier and per
+// client IP in sliding windows. Each failure imposes an exponentially growing
+// delay before the next attempt, and reaching the threshold locks the subject
+// out for LockoutDuration. Now can be replaced to run against a fixed clock.
+type RedisRepo struct {
+	Client *redis.Client
+	Policy Policy
+	Now    func() time.Time
+}
+
+func NewRedisRepo(client *redis.Client, policy Policy) *RedisRepo {
+	return &RedisRepo{
+		Client: client,
+		Policy: policy,
+		Now:    time.Now,
+	}
+}
+
+func failuresKey(kind, subject string) string {
+	return fmt.Sprintf("login_failures:%s:%s", kind, subject)
+}
+
+func delayKey(kind, subject string) string {
+	return fmt.Sprintf("login_delay:%s:%s", kind, subject)
+}
+
+func lockKey(kind, subject string) string {
+	return fmt.Sprintf("login_lock:%s:%s", kind, subject)
+}
+
+const (
+	kindAccount = "account"
+	kindIP      = "ip"
+)
+
+type subject struct {
+	kind  string
+	value string
+	max   int
+}
+
+func (r *RedisRepo) subjects(account, ip string) []subject {
+	var subjects []subject
+	if account != "" {
+		subjects = append(subjects, subject{kind: kindAccount, value: account, max: r.Policy.MaxFailures})
+	}
+	if ip != "" {
+		subjects = append(subjects, subject{kind: kindIP, value: ip, max: r.Policy.MaxIPFailures})
+	}
+	return subjects
+}
+
+// Check returns how long the caller must wait before another attempt for
+// the account or IP is allowed. Zero means the attempt may proceed.
+func (r *RedisRepo) Check(ctx context.Context, account, ip string) (time.Duration, error) {
+	pipe := r.Client.Pipeline()
+
+	var ttls []*redis.DurationCmd
+	for _, s := range r.subjects(account, ip) {
+		ttls = append(ttls, pipe.PTTL(ctx, lockKey(s.kind, s.value)))
+		ttls = append(ttls, pipe.PTTL(ctx, delayKey(s.kind, s.value)))
+	}
+
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return 0, fmt.Errorf("failed to check login lockout: %w", err)
+	}
+
+	var wait time.Duration
+	for _, ttl := range ttls {
+		if d := ttl.Val(); d > wait {
+			wait = d
+		}
+	}
+	return wait, nil
+}
+
+// RecordFailure registers a failed attempt and returns the resulting wait
+// before the next attempt is allowed.
+func (r *RedisRepo) RecordFailure(ctx context.Context, account, ip string) (time.Duration, error) {
+	now := r.Now()
+	windowStart := now.Add(-r.Policy.Window)
+
+	var wait time.Duration
+	for _, s := range r.subjects(account, ip) {
+		key := failuresKey(s.kind, s.value)
+
+		pipe := r.Client.TxPipeline()
+		pipe.ZAdd(ctx, key, redis.Z{Score: float64(now.UnixNano()), Member: uuid.NewString()})
+		pipe.ZRemRangeByScore(ctx, key, "-inf", strconv.FormatInt(windowStart.UnixNano(), 10))
+		count := pipe.ZCard(ctx, key)
+		pipe.Expire(ctx, key, r.Policy.Window)
+		if _, err := pipe.Exec(ctx); err != nil {
+			return 0, fmt.Errorf("failed to record login failure: %w", err)
+		}
+
+		failures := int(count.Val())
+		if s.max > 0 && failures >= s.max {
+			err := r.Client.Set(ctx, lockKey(s.kind, s.value), now.Unix(), r.Policy.LockoutDuration).Err()
+			if err != nil {
+				return 0, fmt.Errorf("failed to lock out login: %w", err)
+			}
+			wait = max(wait, r.Policy.LockoutDuration)
+			continue
+		}
+
+		delay := r.delay(failures)
+		if delay > 0 {
+			err := r.Client.Set(ctx, delayKey(s.kind, s.value), failures, delay).Err()
+			if err != nil {
+				return 0, fmt.Errorf("failed to set login delay: %w", err)
+			}
+			wait = max(wait, delay)
+		}
+	}
+
+	return wait, nil
+}
+
+func (r *RedisRepo) delay(failures int) time.Duration {
+	if failures <= 0 || r.Policy.BaseDelay <= 0 {
+		return 0
+	}
+
+	delay := r.Policy.BaseDelay
+	for i := 1; i < failures; i++ {
+		delay *= 2
+		if delay >= r.Policy.MaxDelay {
+			return r.Policy.MaxDelay
+		}
+	}
+	return min(delay, r.Policy.MaxDelay)
+}
+
+// Reset forgets the failures of an account after a successful login. IP
+// counters are left alone so one valid account cannot be used to launder an
+// IP's attempts against others.
+func (r *RedisRepo) Reset(ctx context.Context, account string) error {
+	err := r.Client.Del(ctx, failuresKey(kindAccount, account), delayKey(kindAccount, account)).Err()
+	if err != nil {
+		return fmt.Errorf("failed to reset login failures: %w", err)
+	}
+	return nil
+}
+
+func (r *RedisRepo) UnlockAccount(ctx context.Context, account string) error {
+	return r.unlock(ctx, kindAccount, account)
+}
+
+func (r *RedisRepo) UnlockIP(ctx context.Context, ip string) error {
+	return r.unlock(ctx, kindIP, ip)
+}
+
+func (r *RedisRepo) unlock(ctx context.Context, kind, value string) error {
+	err := r.Client.Del(ctx, failuresKey(kind, value), delayKey(kind, value), lockKey(kind, value)).Err()
+	if err != nil {
+		return fmt.Errorf("failed to unlock login: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,221 @@
+package mfa
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+
+	"github.com/CatalinPlesu/user-service/model"
+)
+
+var ErrNotExist = errors.New("mfa enrollment does not exist")
+
+type PostgresRepo struct {
+	DB bun.IDB
+}
+
+func NewPostgresRepo(db bun.IDB) *PostgresRepo {
+	return &PostgresRepo{DB: db}
+}
+
+// WithTx returns a repository bound to tx, so enrollments can change in the
+// same transaction as other writes.
+func (p *PostgresRepo) WithTx(tx bun.Tx) *PostgresRepo {
+	return &PostgresRepo{DB: tx}
+}
+
+func (p *PostgresRepo) Migrate(ctx context.Context) error {
+	_, err := p.DB.NewCreateTable().
+		Model((*model.UserMFA)(nil)).
+		IfNotExists().
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create user_mfa table: %w", err)
+	}
+
+	_, err = p.DB.NewCreateTable().
+		Model((*model.RecoveryCode)(nil)).
+		IfNotExists().
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create user_recovery_codes table: %w", err)
+	}
+
+	_, err = p.DB.NewCreateIndex().
+		Model((*model.RecoveryCode)(nil)).
+		Index("user_recovery_codes_user_id_idx").
+		Column("user_id").
+		IfNotExists().
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create user_recovery_codes index: %w", err)
+	}
+	return nil
+}
+
+// Enroll stores a new, unconfirmed secret for the user, replacing any
+// pending enrollment. A confirmed enrollment must be reset first.
+func (p *PostgresRepo) Enroll(ctx context.Context, userID uuid.UUID, secret string) error {
+	now := time.Now().UTC()
+	m := model.UserMFA{
+		UserID:    userID,
+		Secret:    secret,
+		CreatedAt: &now,
+	}
+
+	_, err := p.DB.NewInsert().
+		Model(&m).
+		On("CONFLICT (user_id) DO UPDATE").
+		Set("secret = EXCLUDED.secret, created_at = EXCLUDED.created_at, last_used_step = 0").
+		Where("user_mfa.confirmed = false").
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to enroll mfa: %w", err)
+	}
+	return nil
+}
+
+func (p *PostgresRepo) FindByUserID(ctx context.Context, userID uuid.UUID) (*model.UserMFA, error) {
+	var m model.UserMFA
+	err := p.DB.NewSelect().Model(&m).Where("user_id = ?", userID).Scan(ctx)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotExist
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to find mfa enrollment: %w", err)
+	}
+	return &m, nil
+}
+
+// Confirm marks the enrollment active and replaces the user's recovery codes
+// in a single transaction.
+func (p *PostgresRepo) Confirm(ctx context.Context, userID uuid.UUID, step int64, codeHashes []string) error {
+	return p.DB.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		now := time.Now().UTC()
+		_, err := tx.NewUpdate().
+			Model((*model.UserMFA)(nil)).
+			Set("confirmed = true").
+			Set("confirmed_at = ?", now).
+			Set("last_used_step = ?", step).
+			Where("user_id = ?", userID).
+			Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to confirm mfa: %w", err)
+		}
+
+		return replaceRecoveryCodes(ctx, tx, userID, codeHashes)
+	})
+}
+
+func (p *PostgresRepo) ReplaceRecoveryCodes(ctx context.Context, userID uuid.UUID, codeHashes []string) error {
+	return p.DB.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		return replaceRecoveryCodes(ctx, tx, userID, codeHashes)
+	})
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx bun.Tx, userID uuid.UUID, codeHashes []string) error {
+	_, err := tx.NewDelete().
+		Model((*model.RecoveryCode)(nil)).
+		Where("user_id = ?", userID).
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+
+	if len(codeHashes) == 0 {
+		return nil
+	}
+
+	now := time.Now().UTC()
+	codes := make([]model.RecoveryCode, len(codeHashes))
+	for i, hash := range codeHashes {
+		codes[i] = model.RecoveryCode{
+			ID:        uuid.New(),
+			UserID:    userID,
+			CodeHash:  hash,
+			CreatedAt: &now,
+		}
+	}
+
+	_, err = tx.NewInsert().Model(&codes).Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to insert recovery codes: %w", err)
+	}
+	return nil
+}
+
+// MarkStepUsed advances the last accepted step, failing if another request
+// already consumed the same or a later step.
+func (p *PostgresRepo) MarkStepUsed(ctx context.Context, userID uuid.UUID, step int64) (bool, error) {
+	res, err := p.DB.NewUpdate().
+		Model((*model.UserMFA)(nil)).
+		Set("last_used_step = ?", step).
+		Where("user_id = ?", userID).
+		Where("last_used_step < ?", step).
+		Exec(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to update mfa step: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to update mfa step: %w", err)
+	}
+	return n == 1, nil
+}
+
+// UseRecoveryCode consumes an unused recovery code. It reports false if the
+// code is unknown or was already used.
+func (p *PostgresRepo) UseRecoveryCode(ctx context.Context, userID uuid.UUID, codeHash string) (bool, error) {
+	res, err := p.DB.NewUpdate().
+		Model((*model.RecoveryCode)(nil)).
+		Set("used_at = ?", time.Now().UTC()).
+		Where("user_id = ?", userID).
+		Where("code_hash = ?", codeHash).
+		Where("used_at IS NULL").
+		Exec(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to use recovery code: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to use recovery code: %w", err)
+	}
+	return n == 1, nil
+}
+
+func (p *PostgresRepo) CountUnusedRecoveryCodes(ctx context.Context, userID uuid.UUID) (int, error) {
+	n, err := p.DB.NewSelect().
+		Model((*model.RecoveryCode)(nil)).
+		Where("user_id = ?", userID).
+		Where("used_at IS NULL").
+		Count(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count recovery codes: %w", err)
+	}
+	return n, nil
+}
+
+// Reset removes the enrollment and all recovery codes for the user.
+func (p *PostgresRepo) Reset(ctx context.Context, userID uuid.UUID) error {
+	return p.DB.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		res, err := tx.NewDelete().
+			Model((*model.UserMFA)(nil)).
+			Where("user_id = ?", userID).
+			Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to delete mfa enrollment: %w", err)
+		}
+
+		if n, _ := res.RowsAffected(); n == 0 {
+			return ErrNotExist
+		}
+
+		return replaceRecoveryCodes(ctx, tx, userID, nil)
+	})
+}
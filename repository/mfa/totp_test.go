@@ -0,0 +1,102 @@
+package mfa
+
+import (
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 seed of RFC 6238 Appendix B, "12345678901234567890",
+// in base32.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func fixedTOTP(at time.Time) *TOTP {
+	totp := NewTOTP("test")
+	totp.Now = func() time.Time { return at }
+	return totp
+}
+
+func TestTOTPRFC6238Vectors(t *testing.T) {
+	vectors := []struct {
+		unix int64
+		code string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+
+	for _, v := range vectors {
+		totp := fixedTOTP(time.Unix(v.unix, 0))
+		totp.Digits = 8
+		totp.Skew = 0
+
+		code, err := totp.CodeAt(rfcSecret, totp.step(totp.Now()))
+		if err != nil {
+			t.Fatalf("CodeAt(%d): %v", v.unix, err)
+		}
+		if code != v.code {
+			t.Errorf("code at %d = %s, want %s", v.unix, code, v.code)
+		}
+
+		if _, ok := totp.Validate(rfcSecret, v.code, 0); !ok {
+			t.Errorf("Validate rejected the code at %d", v.unix)
+		}
+	}
+}
+
+func TestTOTPWindow(t *testing.T) {
+	totp := fixedTOTP(time.Unix(1111111111, 0))
+	current := totp.step(totp.Now())
+
+	for offset := int64(-2); offset <= 2; offset++ {
+		code, err := totp.CodeAt(rfcSecret, current+offset)
+		if err != nil {
+			t.Fatalf("CodeAt: %v", err)
+		}
+
+		step, ok := totp.Validate(rfcSecret, code, 0)
+		want := offset >= -1 && offset <= 1
+		if ok != want {
+			t.Errorf("code %d steps away: accepted = %v, want %v", offset, ok, want)
+		}
+		if ok && step != current+offset {
+			t.Errorf("code %d steps away matched step %d, want %d", offset, step, current+offset)
+		}
+	}
+}
+
+func TestTOTPReplay(t *testing.T) {
+	totp := fixedTOTP(time.Unix(1111111111, 0))
+	current := totp.step(totp.Now())
+
+	code, err := totp.CodeAt(rfcSecret, current)
+	if err != nil {
+		t.Fatalf("CodeAt: %v", err)
+	}
+
+	step, ok := totp.Validate(rfcSecret, code, 0)
+	if !ok {
+		t.Fatal("fresh code was rejected")
+	}
+	if _, ok := totp.Validate(rfcSecret, code, step); ok {
+		t.Error("code was accepted again after its step was used")
+	}
+
+	// Codes from earlier steps stay rejected once a later one was used.
+	previous, err := totp.CodeAt(rfcSecret, current-1)
+	if err != nil {
+		t.Fatalf("CodeAt: %v", err)
+	}
+	if _, ok := totp.Validate(rfcSecret, previous, step); ok {
+		t.Error("code from before the last used step was accepted")
+	}
+
+	// Moving the clock on does not make the used code valid again.
+	totp.Now = func() time.Time { return time.Unix(1111111111, 0).Add(totp.Period) }
+	if _, ok := totp.Validate(rfcSecret, code, step); ok {
+		t.Error("used code was accepted one step later")
+	}
+}
@@ -0,0 +1,250 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+
+	"github.com/CatalinPlesu/user-service/model"
+)
+
+// relayLockID is the advisory lock key that keeps relays from claiming
+// messages at the same time.
+const relayLockID = 0x6f7574626f78
+
+type PostgresRepo struct {
+	DB bun.IDB
+}
+
+func NewPostgresRepo(db bun.IDB) *PostgresRepo {
+	return &PostgresRepo{DB: db}
+}
+
+// WithTx returns a repository bound to tx, so messages can be enqueued in
+// the same transaction as the change they describe.
+func (p *PostgresRepo) WithTx(tx bun.Tx) *PostgresRepo {
+	return &PostgresRepo{DB: tx}
+}
+
+func (p *PostgresRepo) Migrate(ctx context.Context) error {
+	_, err := p.DB.NewCreateTable().
+		Model((*model.OutboxMessage)(nil)).
+		IfNotExists().
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create outbox table: %w", err)
+	}
+
+	// Columns added after the table was first created.
+	columns := []string{
+		"dead_at TIMESTAMPTZ",
+	}
+	for _, column := range columns {
+		_, err := p.DB.ExecContext(ctx, "ALTER TABLE outbox ADD COLUMN IF NOT EXISTS "+column)
+		if err != nil {
+			return fmt.Errorf("failed to add outbox column: %w", err)
+		}
+	}
+
+	// Login messages used to carry the bearer token; strip it from the
+	// ones still kept.
+	_, err = p.DB.NewUpdate().
+		Model((*model.OutboxMessage)(nil)).
+		Set("payload = convert_to((convert_from(payload, 'UTF8')::jsonb - 'jwt')::text, 'UTF8')").
+		Where("content_type = ?", "application/json").
+		Where("convert_from(payload, 'UTF8')::jsonb -> 'jwt' IS NOT NULL").
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to remove tokens from outbox: %w", err)
+	}
+
+	_, err = p.DB.NewCreateIndex().
+		Model((*model.OutboxMessage)(nil)).
+		Index("outbox_pending_idx").
+		Column("aggregate_id", "id").
+		Where("sent_at IS NULL").
+		IfNotExists().
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create outbox index: %w", err)
+	}
+	return nil
+}
+
+// Insert adds messages to the outbox. Each of their aggregates is locked
+// until the surrounding transaction ends, so transactions enqueueing for the
+// same aggregate commit one after the other and IDs follow commit order
+// within an aggregate, which is the order the relay publishes them in.
+func (p *PostgresRepo) Insert(ctx context.Context, messages ...model.OutboxMessage) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	now := time.Now().UTC()
+	var aggregates []string
+	for i := range messages {
+		messages[i].CreatedAt = now
+		messages[i].NextAttemptAt = now
+		aggregates = append(aggregates, messages[i].AggregateID.String())
+	}
+	// Locking in a fixed order keeps two inserts from deadlocking on each
+	// other.
+	slices.Sort(aggregates)
+	aggregates = slices.Compact(aggregates)
+
+	insert := func(ctx context.Context, tx bun.Tx) error {
+		for _, aggregate := range aggregates {
+			_, err := tx.NewRaw("SELECT pg_advisory_xact_lock(hashtext(?))", "outbox:"+aggregate).Exec(ctx)
+			if err != nil {
+				return fmt.Errorf("failed to lock outbox aggregate: %w", err)
+			}
+		}
+
+		_, err := tx.NewInsert().Model(&messages).Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to insert outbox messages: %w", err)
+		}
+		return nil
+	}
+
+	if tx, ok := p.DB.(bun.Tx); ok {
+		return insert(ctx, tx)
+	}
+	return p.DB.RunInTx(ctx, nil, insert)
+}
+
+// ClaimDue returns, for each aggregate, its oldest pending message if that
+// message is due, and pushes its next attempt back by lease so that other
+// relays skip it while it is being published. Later messages of an
+// aggregate are held back until the earlier ones are sent or dead, even
+// while the head is claimed or waiting for a retry. Nothing is claimed
+// while another relay is claiming.
+func (p *PostgresRepo) ClaimDue(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]model.OutboxMessage, error) {
+	var messages []model.OutboxMessage
+	err := p.DB.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		var acquired bool
+		err := tx.NewRaw("SELECT pg_try_advisory_xact_lock(?)", relayLockID).Scan(ctx, &acquired)
+		if err != nil {
+			return fmt.Errorf("failed to acquire outbox relay lock: %w", err)
+		}
+		if !acquired {
+			return nil
+		}
+
+		heads := tx.NewSelect().
+			Model((*model.OutboxMessage)(nil)).
+			DistinctOn("aggregate_id").
+			Where("sent_at IS NULL").
+			Where("dead_at IS NULL").
+			Order("aggregate_id", "id")
+
+		err = tx.NewSelect().
+			With("heads", heads).
+			Model(&messages).
+			ModelTableExpr("heads AS outbox_message").
+			Where("next_attempt_at <= ?", now).
+			Order("id").
+			Limit(limit).
+			Scan(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to find pending outbox messages: %w", err)
+		}
+		if len(messages) == 0 {
+			return nil
+		}
+
+		ids := make([]int64, len(messages))
+		for i, msg := range messages {
+			ids[i] = msg.ID
+		}
+
+		_, err = tx.NewUpdate().
+			Model((*model.OutboxMessage)(nil)).
+			Set("next_attempt_at = ?", now.Add(lease)).
+			Where("id IN (?)", bun.In(ids)).
+			Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to claim outbox messages: %w", err)
+		}
+		return nil
+	})
+	return messages, err
+}
+
+func (p *PostgresRepo) MarkSent(ctx context.Context, id int64, at time.Time) error {
+	_, err := p.DB.NewUpdate().
+		Model((*model.OutboxMessage)(nil)).
+		Set("sent_at = ?", at).
+		Set("attempts = attempts + 1").
+		Set("last_error = ''").
+		Where("id = ?", id).
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to mark outbox message sent: %w", err)
+	}
+	return nil
+}
+
+func (p *PostgresRepo) MarkFailed(ctx context.Context, id int64, cause error, nextAttemptAt time.Time) error {
+	_, err := p.DB.NewUpdate().
+		Model((*model.OutboxMessage)(nil)).
+		Set("attempts = attempts + 1").
+		Set("last_error = ?", cause.Error()).
+		Set("next_attempt_at = ?", nextAttemptAt).
+		Where("id = ?", id).
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to mark outbox message failed: %w", err)
+	}
+	return nil
+}
+
+// MarkDead gives up on the message after its last failed attempt. It is kept
+// for inspection, and no longer holds back the aggregate's later messages.
+func (p *PostgresRepo) MarkDead(ctx context.Context, id int64, cause error, at time.Time) error {
+	_, err := p.DB.NewUpdate().
+		Model((*model.OutboxMessage)(nil)).
+		Set("dead_at = ?", at).
+		Set("attempts = attempts + 1").
+		Set("last_error = ?", cause.Error()).
+		Where("id = ?", id).
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to mark outbox message dead: %w", err)
+	}
+	return nil
+}
+
+// DeleteSentBefore removes delivered messages older than cutoff.
+func (p *PostgresRepo) DeleteSentBefore(ctx context.Context, cutoff time.Time) (int64, error) {
+	res, err := p.DB.NewDelete().
+		Model((*model.OutboxMessage)(nil)).
+		Where("sent_at IS NOT NULL").
+		Where("sent_at < ?", cutoff).
+		Exec(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete sent outbox messages: %w", err)
+	}
+
+	n, _ := res.RowsAffected()
+	return n, nil
+}
+
+// DeleteByAggregate removes every message about the aggregate, sent or not.
+// It is used to erase a user's history.
+func (p *PostgresRepo) DeleteByAggregate(ctx context.Context, aggregateID uuid.UUID) (int64, error) {
+	res, err := p.DB.NewDelete().
+		Model((*model.OutboxMessage)(nil)).
+		Where("aggregate_id = ?", aggregateID).
+		Exec(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete outbox messages: %w", err)
+	}
+
+	n, _ := res.RowsAffected()
+	return n, nil
+}
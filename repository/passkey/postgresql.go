@@ -0,0 +1,101 @@
+package passkey
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+
+	"github.com/CatalinPlesu/user-service/model"
+)
+
+var ErrNotExist = errors.New("passkey does not exist")
+
+type PostgresRepo struct {
+	DB *bun.DB
+}
+
+func NewPostgresRepo(db *bun.DB) *PostgresRepo {
+	return &PostgresRepo{DB: db}
+}
+
+func (p *PostgresRepo) Migrate(ctx context.Context) error {
+	_, err := p.DB.NewCreateTable().
+		Model((*model.PasskeyCredential)(nil)).
+		IfNotExists().
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create user_passkeys table: %w", err)
+	}
+
+	_, err = p.DB.NewCreateIndex().
+		Model((*model.PasskeyCredential)(nil)).
+		Index("user_passkeys_user_id_idx").
+		Column("user_id").
+		IfNotExists().
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create user_passkeys index: %w", err)
+	}
+	return nil
+}
+
+func (p *PostgresRepo) Insert(ctx context.Context, credential model.PasskeyCredential) error {
+	_, err := p.DB.NewInsert().Model(&credential).Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to insert passkey: %w", err)
+	}
+	return nil
+}
+
+func (p *PostgresRepo) FindByUserID(ctx context.Context, userID uuid.UUID) ([]model.PasskeyCredential, error) {
+	var credentials []model.PasskeyCredential
+	err := p.DB.NewSelect().
+		Model(&credentials).
+		Where("user_id = ?", userID).
+		Order("created_at ASC").
+		Scan(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find passkeys: %w", err)
+	}
+	return credentials, nil
+}
+
+// UpdateSignCount records a successful assertion. The caller is expected to
+// have rejected counters that did not increase.
+func (p *PostgresRepo) UpdateSignCount(ctx context.Context, credentialID []byte, signCount uint32, backupState bool) error {
+	res, err := p.DB.NewUpdate().
+		Model((*model.PasskeyCredential)(nil)).
+		Set("sign_count = ?", int64(signCount)).
+		Set("backup_state = ?", backupState).
+		Set("last_used_at = ?", time.Now().UTC()).
+		Where("credential_id = ?", credentialID).
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to update passkey: %w", err)
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotExist
+	}
+	return nil
+}
+
+func (p *PostgresRepo) Delete(ctx context.Context, userID uuid.UUID, credentialID []byte) error {
+	res, err := p.DB.NewDelete().
+		Model((*model.PasskeyCredential)(nil)).
+		Where("user_id = ?", userID).
+		Where("credential_id = ?", credentialID).
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to delete passkey: %w", err)
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotExist
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package passkey
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/redis/go-redis/v9"
+)
+
+var ErrSessionNotExist = errors.New("webauthn session does not exist")
+
+const sessionTTL = 5 * time.Minute
+
+// SessionRepo keeps the challenge state of an in-flight registration or
+// login ceremony. Sessions are single use and expire after sessionTTL.
+type SessionRepo struct {
+	Client *redis.Client
+}
+
+func sessionKey(id string) string {
+	return fmt.Sprintf("webauthn_session:%s", id)
+}
+
+func (r *SessionRepo) Insert(ctx context.Context, session *webauthn.SessionData) (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate session id: %w", err)
+	}
+	id := base64.RawURLEncoding.EncodeToString(buf)
+
+	data, err := json.Marshal(session)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode webauthn session: %w", err)
+	}
+
+	err = r.Client.Set(ctx, sessionKey(id), string(data), sessionTTL).Err()
+	if err != nil {
+		return "", fmt.Errorf("failed to set webauthn session: %w", err)
+	}
+
+	return id, nil
+}
+
+func (r *SessionRepo) Take(ctx context.Context, id string) (*webauthn.SessionData, error) {
+	value, err := r.Client.GetDel(ctx, sessionKey(id)).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrSessionNotExist
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get webauthn session: %w", err)
+	}
+
+	var session webauthn.SessionData
+	if err := json.Unmarshal([]byte(value), &session); err != nil {
+		return nil, fmt.Errorf("failed to decode webauthn session json: %w", err)
+	}
+
+	return &session, nil
+}
@@ -0,0 +1,51 @@
+package user
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const passwordCost = bcrypt.DefaultCost
+
+// unknownUserHash is verified against when a login names a user that does
+// not exist, so that path costs the same as checking a real password.
+var unknownUserHash, _ = bcrypt.GenerateFromPassword([]byte("unknown-user-placeholder-password"), passwordCost)
+
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), passwordCost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %w", err)
+	}
+	return string(hash), nil
+}
+
+func isHashed(stored string) bool {
+	return strings.HasPrefix(stored, "$2")
+}
+
+// VerifyPassword reports whether password matches the stored value. Rows
+// created before passwords were hashed hold plaintext; those still verify
+// and report needsRehash so the caller can upgrade them.
+func VerifyPassword(stored, password string) (ok bool, needsRehash bool) {
+	if !isHashed(stored) {
+		ok = subtle.ConstantTimeCompare([]byte(stored), []byte(password)) == 1
+		return ok, ok
+	}
+
+	err := bcrypt.CompareHashAndPassword([]byte(stored), []byte(password))
+	if err != nil {
+		return false, false
+	}
+
+	cost, err := bcrypt.Cost([]byte(stored))
+	return true, err == nil && cost < passwordCost
+}
+
+// VerifyUnknownUser burns the same work as VerifyPassword and always fails.
+func VerifyUnknownUser(password string) bool {
+	bcrypt.CompareHashAndPassword(unknownUserHash, []byte(password))
+	return false
+}
@@ -2,7 +2,11 @@ package user
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/CatalinPlesu/user-service/model"
 	"github.com/google/uuid"
@@ -10,13 +14,19 @@ import (
 )
 
 type PostgresRepo struct {
-	DB *bun.DB
+	DB bun.IDB
 }
 
-func NewPostgresRepo(db *bun.DB) *PostgresRepo {
+func NewPostgresRepo(db bun.IDB) *PostgresRepo {
 	return &PostgresRepo{DB: db}
 }
 
+// WithTx returns a repository bound to tx, so a user change can be committed
+// together with its outbox messages.
+func (p *PostgresRepo) WithTx(tx bun.Tx) *PostgresRepo {
+	return &PostgresRepo{DB: tx}
+}
+
 func (p *PostgresRepo) Migrate(ctx context.Context) error {
 	_, err := p.DB.NewCreateTable().
 		Model((*model.User)(nil)).
@@ -25,13 +35,35 @@ func (p *PostgresRepo) Migrate(ctx context.Context) error {
 	if err != nil {
 		return fmt.Errorf("failed to create users table: %w", err)
 	}
+
+	// Columns added after the table was first created.
+	columns := []string{
+		"deactivated_at TIMESTAMPTZ",
+		"deleted_at TIMESTAMPTZ",
+		"deleted_username VARCHAR",
+		"version BIGINT NOT NULL DEFAULT 1",
+		"bio TEXT",
+		"avatar VARCHAR",
+		"locale VARCHAR",
+		"timezone VARCHAR",
+		"pronouns VARCHAR",
+		"status_message VARCHAR",
+		"profile_visibility VARCHAR NOT NULL DEFAULT 'public'",
+		"searchable BOOLEAN NOT NULL DEFAULT TRUE",
+		"email_visibility VARCHAR NOT NULL DEFAULT 'private'",
+	}
+	for _, column := range columns {
+		_, err := p.DB.ExecContext(ctx, "ALTER TABLE users ADD COLUMN IF NOT EXISTS "+column)
+		if err != nil {
+			return fmt.Errorf("failed to add users column: %w", err)
+		}
+	}
 	return nil
 }
 
 func (p *PostgresRepo) Insert(ctx context.Context, user model.User) error {
 	_, err := p.DB.NewInsert().Model(&user).Exec(ctx)
 	if err != nil {
-		p.Migrate(ctx)
 		return fmt.Errorf("failed to insert user: %w", err)
 	}
 	return nil
@@ -40,7 +72,21 @@ func (p *PostgresRepo) Insert(ctx context.Context, user model.User) error {
 func (p *PostgresRepo) FindByID(ctx context.Context, id uuid.UUID) (*model.User, error) {
 	var user model.User
 	err := p.DB.NewSelect().Model(&user).Where("user_id = ?", id).Scan(ctx)
-	if err != nil {
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotExist
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to find user by ID: %w", err)
+	}
+	return &user, nil
+}
+
+// FindByIDWithDeleted is FindByID that also finds soft-deleted users.
+func (p *PostgresRepo) FindByIDWithDeleted(ctx context.Context, id uuid.UUID) (*model.User, error) {
+	var user model.User
+	err := p.DB.NewSelect().Model(&user).WhereAllWithDeleted().Where("user_id = ?", id).Scan(ctx)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotExist
+	} else if err != nil {
 		return nil, fmt.Errorf("failed to find user by ID: %w", err)
 	}
 	return &user, nil
@@ -49,40 +95,304 @@ func (p *PostgresRepo) FindByID(ctx context.Context, id uuid.UUID) (*model.User,
 func (p *PostgresRepo) FindByUsername(ctx context.Context, username string) (*model.User, error) {
 	var user model.User
 	err := p.DB.NewSelect().Model(&user).Where("username = ?", username).Scan(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("failed to find user by ID: %w", err)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotExist
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to find user by username: %w", err)
+	}
+	return &user, nil
+}
+
+// FindByLogin resolves a login identifier that may be either a username or
+// an email address. Usernames cannot contain '@', so identifiers with one
+// are only matched against emails.
+func (p *PostgresRepo) FindByLogin(ctx context.Context, identifier string) (*model.User, error) {
+	var user model.User
+	query := p.DB.NewSelect().Model(&user)
+	if strings.Contains(identifier, "@") {
+		query = query.Where("lower(email) = lower(?)", identifier)
+	} else {
+		query = query.Where("username = ?", identifier)
+	}
+	err := query.Scan(ctx)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotExist
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to find user by login: %w", err)
 	}
 	return &user, nil
 }
 
-func (p *PostgresRepo) DeleteByID(ctx context.Context, id uuid.UUID) error {
-	_, err := p.DB.NewDelete().Model((*model.User)(nil)).Where("user_id = ?", id).Exec(ctx)
+// DeleteByID soft-deletes the user. With releaseUsername the username
+// becomes available to others; the original is kept for a restore. A
+// non-zero version makes the deletion conditional on the user still being
+// at that version.
+func (p *PostgresRepo) DeleteByID(ctx context.Context, id uuid.UUID, releaseUsername bool, version int64) error {
+	query := p.DB.NewUpdate().
+		Model((*model.User)(nil)).
+		Set("deleted_at = ?", time.Now().UTC()).
+		Set("version = version + 1").
+		Where("user_id = ?", id)
+	if releaseUsername {
+		query.Set("deleted_username = username").
+			Set("username = ?", "deleted:"+id.String())
+	}
+	if version != 0 {
+		query.Where("version = ?", version)
+	}
+
+	res, err := query.Exec(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to delete user: %w", err)
 	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return p.conflict(ctx, id, version)
+	}
+	return nil
+}
+
+// Restore undoes the soft deletion of a user deleted after cutoff.
+func (p *PostgresRepo) Restore(ctx context.Context, id uuid.UUID, cutoff time.Time) (*model.User, error) {
+	var user model.User
+	err := p.DB.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		err := tx.NewSelect().
+			Model(&user).
+			WhereDeleted().
+			Where("user_id = ?", id).
+			For("UPDATE").
+			Scan(ctx)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotExist
+		} else if err != nil {
+			return fmt.Errorf("failed to find deleted user: %w", err)
+		}
+
+		if user.DeletedAt.Before(cutoff) {
+			return ErrRestoreExpired
+		}
+
+		if user.DeletedUsername != "" {
+			taken, err := tx.NewSelect().
+				Model((*model.User)(nil)).
+				Where("username = ?", user.DeletedUsername).
+				Exists(ctx)
+			if err != nil {
+				return fmt.Errorf("failed to check username: %w", err)
+			}
+			if taken {
+				return ErrUsernameTaken
+			}
+
+			user.Username = user.DeletedUsername
+			user.DeletedUsername = ""
+		}
+		user.DeletedAt = nil
+		user.Version++
+
+		_, err = tx.NewUpdate().
+			Model(&user).
+			Column("username", "deleted_username", "deleted_at", "version").
+			WhereAllWithDeleted().
+			Where("user_id = ?", id).
+			Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to restore user: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// PurgeDeletedBefore permanently removes up to limit users deleted before
+// cutoff, along with their MFA and passkey data, and returns their IDs.
+func (p *PostgresRepo) PurgeDeletedBefore(ctx context.Context, cutoff time.Time, limit int) ([]uuid.UUID, error) {
+	var ids []uuid.UUID
+	err := p.DB.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		err := tx.NewSelect().
+			Model((*model.User)(nil)).
+			Column("user_id").
+			WhereDeleted().
+			Where("deleted_at < ?", cutoff).
+			Order("deleted_at").
+			Limit(limit).
+			For("UPDATE SKIP LOCKED").
+			Scan(ctx, &ids)
+		if err != nil {
+			return fmt.Errorf("failed to find expired users: %w", err)
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+
+		return purge(ctx, tx, ids)
+	})
+	return ids, err
+}
+
+// Erase permanently removes the user right away, whether or not they were
+// soft-deleted, and returns the removed row.
+func (p *PostgresRepo) Erase(ctx context.Context, id uuid.UUID) (*model.User, error) {
+	var user model.User
+	err := p.DB.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		err := tx.NewSelect().
+			Model(&user).
+			WhereAllWithDeleted().
+			Where("user_id = ?", id).
+			For("UPDATE").
+			Scan(ctx)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotExist
+		} else if err != nil {
+			return fmt.Errorf("failed to find user: %w", err)
+		}
+
+		return purge(ctx, tx, []uuid.UUID{id})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// purge hard-deletes the users and every row that belongs to them. Their
+// data keys go too, which shreds anything encrypted with them.
+func purge(ctx context.Context, tx bun.Tx, ids []uuid.UUID) error {
+	owned := []interface{}{
+		(*model.RecoveryCode)(nil),
+		(*model.UserMFA)(nil),
+		(*model.PasskeyCredential)(nil),
+		(*model.DataExport)(nil),
+		(*model.UserKey)(nil),
+		(*model.Contact)(nil),
+	}
+	for _, m := range owned {
+		_, err := tx.NewDelete().
+			Model(m).
+			Where("user_id IN (?)", bun.In(ids)).
+			Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to purge user data: %w", err)
+		}
+	}
+
+	// Other users' contacts, friend requests and blocks involving them go
+	// too.
+	_, err := tx.NewDelete().
+		Model((*model.Contact)(nil)).
+		Where("contact_id IN (?)", bun.In(ids)).
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to purge contacts: %w", err)
+	}
+
+	_, err = tx.NewDelete().
+		Model((*model.FriendRequest)(nil)).
+		Where("from_user_id IN (?)", bun.In(ids)).
+		WhereOr("to_user_id IN (?)", bun.In(ids)).
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to purge friend requests: %w", err)
+	}
+
+	_, err = tx.NewDelete().
+		Model((*model.Block)(nil)).
+		Where("blocker_id IN (?)", bun.In(ids)).
+		WhereOr("blocked_id IN (?)", bun.In(ids)).
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to purge blocks: %w", err)
+	}
+
+	_, err = tx.NewDelete().
+		Model((*model.User)(nil)).
+		WhereAllWithDeleted().
+		Where("user_id IN (?)", bun.In(ids)).
+		ForceDelete().
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to purge users: %w", err)
+	}
 	return nil
 }
 
+// Update writes user back if it is still at the version it was read at,
+// and increments its version. Otherwise it returns a *ConflictError.
 func (p *PostgresRepo) Update(ctx context.Context, user *model.User) error {
-	_, err := p.DB.NewUpdate().Model(user).Where("user_id = ?", user.UserID).Exec(ctx)
+	expected := user.Version
+	user.Version = expected + 1
+
+	res, err := p.DB.NewUpdate().
+		Model(user).
+		Where("user_id = ?", user.UserID).
+		Where("version = ?", expected).
+		Exec(ctx)
 	if err != nil {
+		user.Version = expected
 		return fmt.Errorf("failed to update user: %w", err)
 	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		user.Version = expected
+		return p.conflict(ctx, user.UserID, expected)
+	}
 	return nil
 }
 
+// UpgradePasswordHash replaces the user's password hash with an equivalent
+// one, without a new version. Nothing happens if the password was changed
+// since oldHash was read.
+func (p *PostgresRepo) UpgradePasswordHash(ctx context.Context, id uuid.UUID, oldHash, newHash string) error {
+	_, err := p.DB.NewUpdate().
+		Model((*model.User)(nil)).
+		Set("password = ?", newHash).
+		Where("user_id = ?", id).
+		Where("password = ?", oldHash).
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to upgrade password hash: %w", err)
+	}
+	return nil
+}
+
+// conflict explains why a conditional write matched no row: either the
+// user is gone or it is at another version than expected.
+func (p *PostgresRepo) conflict(ctx context.Context, id uuid.UUID, expected int64) error {
+	var current int64
+	err := p.DB.NewSelect().
+		Model((*model.User)(nil)).
+		Column("version").
+		Where("user_id = ?", id).
+		Scan(ctx, &current)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotExist
+	} else if err != nil {
+		return fmt.Errorf("failed to find user version: %w", err)
+	}
+	return &ConflictError{UserID: id, Expected: expected, Current: current}
+}
+
 type UserPage struct {
 	Users  []model.User
 	Cursor uint64
 }
 
-func (r *PostgresRepo) FindByDisplayName(ctx context.Context, displayName string) ([]model.User, error) {
+// FindByDisplayName searches the users that allow it, along with the
+// searching user themselves; viewerID is uuid.Nil for anonymous searches.
+func (r *PostgresRepo) FindByDisplayName(ctx context.Context, displayName string, viewerID uuid.UUID) ([]model.User, error) {
 	var users []model.User
 
 	// Query the database for users
 	query := r.DB.NewSelect().
 		Model(&users).
 		Where("display_name ILIKE ?", "%"+displayName+"%").
+		WhereGroup(" AND ", func(q *bun.SelectQuery) *bun.SelectQuery {
+			return q.Where("searchable AND profile_visibility <> ?", model.VisibilityPrivate).
+				WhereOr("user_id = ?", viewerID)
+		}).
 		Order("user_id ASC").
 		Limit(10)
 
@@ -49,6 +49,20 @@ func (r *RedisRepo) Insert(ctx context.Context, user model.User) error {
 }
 
 var ErrNotExist = errors.New("user does not exist")
+var ErrRestoreExpired = errors.New("user can no longer be restored")
+var ErrUsernameTaken = errors.New("username is taken")
+
+// ConflictError is returned by conditional writes when the user was changed
+// by someone else since it was read.
+type ConflictError struct {
+	UserID   uuid.UUID
+	Expected int64
+	Current  int64
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("user %s is at version %d, not %d", e.UserID, e.Current, e.Expected)
+}
 
 func (r *RedisRepo) FindByID(ctx context.Context, id uuid.UUID) (model.User, error) {
 	key := userIDKey(id)
@@ -0,0 +1,405 @@
+package webhook
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+
+	"github.com/CatalinPlesu/user-service/model"
+)
+
+var ErrNotExist = errors.New("webhook does not exist")
+
+// AllEvents subscribes an endpoint to every event type.
+const AllEvents = "*"
+
+type PostgresRepo struct {
+	DB bun.IDB
+}
+
+func NewPostgresRepo(db bun.IDB) *PostgresRepo {
+	return &PostgresRepo{DB: db}
+}
+
+// WithTx returns a repository bound to tx, so deliveries can be enqueued in
+// the same transaction as the event they carry.
+func (p *PostgresRepo) WithTx(tx bun.Tx) *PostgresRepo {
+	return &PostgresRepo{DB: tx}
+}
+
+func (p *PostgresRepo) Migrate(ctx context.Context) error {
+	models := []interface{}{
+		(*model.WebhookEndpoint)(nil),
+		(*model.WebhookDelivery)(nil),
+		(*model.WebhookAttempt)(nil),
+	}
+	for _, m := range models {
+		_, err := p.DB.NewCreateTable().
+			Model(m).
+			IfNotExists().
+			Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to create webhook tables: %w", err)
+		}
+	}
+
+	_, err := p.DB.NewCreateIndex().
+		Model((*model.WebhookDelivery)(nil)).
+		Index("webhook_deliveries_pending_idx").
+		Column("next_attempt_at").
+		Where("status = ?", model.WebhookDeliveryPending).
+		IfNotExists().
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create webhook deliveries index: %w", err)
+	}
+
+	_, err = p.DB.NewCreateIndex().
+		Model((*model.WebhookDelivery)(nil)).
+		Index("webhook_deliveries_endpoint_idx").
+		Column("endpoint_id", "created_at").
+		IfNotExists().
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create webhook deliveries index: %w", err)
+	}
+
+	_, err = p.DB.NewCreateIndex().
+		Model((*model.WebhookAttempt)(nil)).
+		Index("webhook_attempts_delivery_idx").
+		Column("delivery_id").
+		IfNotExists().
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create webhook attempts index: %w", err)
+	}
+	return nil
+}
+
+func (p *PostgresRepo) InsertEndpoint(ctx context.Context, endpoint *model.WebhookEndpoint) error {
+	_, err := p.DB.NewInsert().Model(endpoint).Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to insert webhook endpoint: %w", err)
+	}
+	return nil
+}
+
+func (p *PostgresRepo) FindEndpoint(ctx context.Context, id uuid.UUID) (*model.WebhookEndpoint, error) {
+	var endpoint model.WebhookEndpoint
+	err := p.DB.NewSelect().Model(&endpoint).Where("id = ?", id).Scan(ctx)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotExist
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to find webhook endpoint: %w", err)
+	}
+	return &endpoint, nil
+}
+
+func (p *PostgresRepo) ListEndpoints(ctx context.Context) ([]model.WebhookEndpoint, error) {
+	endpoints := []model.WebhookEndpoint{}
+	err := p.DB.NewSelect().Model(&endpoints).Order("created_at").Scan(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list webhook endpoints: %w", err)
+	}
+	return endpoints, nil
+}
+
+func (p *PostgresRepo) UpdateEndpoint(ctx context.Context, endpoint *model.WebhookEndpoint) error {
+	res, err := p.DB.NewUpdate().Model(endpoint).WherePK().Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to update webhook endpoint: %w", err)
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotExist
+	}
+	return nil
+}
+
+// DeleteEndpoint removes the endpoint together with its delivery log.
+func (p *PostgresRepo) DeleteEndpoint(ctx context.Context, id uuid.UUID) error {
+	return p.DB.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		deliveries := tx.NewSelect().
+			Model((*model.WebhookDelivery)(nil)).
+			Column("id").
+			Where("endpoint_id = ?", id)
+
+		_, err := tx.NewDelete().
+			Model((*model.WebhookAttempt)(nil)).
+			Where("delivery_id IN (?)", deliveries).
+			Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to delete webhook attempts: %w", err)
+		}
+
+		_, err = tx.NewDelete().
+			Model((*model.WebhookDelivery)(nil)).
+			Where("endpoint_id = ?", id).
+			Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to delete webhook deliveries: %w", err)
+		}
+
+		res, err := tx.NewDelete().
+			Model((*model.WebhookEndpoint)(nil)).
+			Where("id = ?", id).
+			Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to delete webhook endpoint: %w", err)
+		}
+
+		if n, _ := res.RowsAffected(); n == 0 {
+			return ErrNotExist
+		}
+		return nil
+	})
+}
+
+// EnqueueEvent creates a pending delivery of the event for every enabled
+// endpoint subscribed to its type.
+func (p *PostgresRepo) EnqueueEvent(ctx context.Context, eventID, eventType string, payload []byte) error {
+	_, err := p.DB.NewRaw(`
+		INSERT INTO webhook_deliveries (endpoint_id, event_id, event_type, payload, status)
+		SELECT id, ?, ?, ?, ?
+		FROM webhook_endpoints
+		WHERE enabled AND (? = ANY(event_types) OR ? = ANY(event_types))`,
+		eventID, eventType, payload, model.WebhookDeliveryPending, eventType, AllEvents,
+	).Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to enqueue webhook deliveries: %w", err)
+	}
+	return nil
+}
+
+// ClaimDue returns up to limit due deliveries of enabled endpoints, with
+// their endpoint loaded, and pushes their next attempt back by lease so
+// that other dispatchers skip them while they are being sent.
+func (p *PostgresRepo) ClaimDue(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]model.WebhookDelivery, error) {
+	var deliveries []model.WebhookDelivery
+	err := p.DB.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		var ids []uuid.UUID
+		err := tx.NewSelect().
+			Model((*model.WebhookDelivery)(nil)).
+			Column("webhook_delivery.id").
+			Join("JOIN webhook_endpoints AS e ON e.id = webhook_delivery.endpoint_id").
+			Where("e.enabled").
+			Where("webhook_delivery.status = ?", model.WebhookDeliveryPending).
+			Where("webhook_delivery.next_attempt_at <= ?", now).
+			Order("webhook_delivery.next_attempt_at").
+			Limit(limit).
+			For("UPDATE OF webhook_delivery SKIP LOCKED").
+			Scan(ctx, &ids)
+		if err != nil {
+			return fmt.Errorf("failed to find due webhook deliveries: %w", err)
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+
+		_, err = tx.NewUpdate().
+			Model((*model.WebhookDelivery)(nil)).
+			Set("next_attempt_at = ?", now.Add(lease)).
+			Where("id IN (?)", bun.In(ids)).
+			Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to claim webhook deliveries: %w", err)
+		}
+
+		err = tx.NewSelect().
+			Model(&deliveries).
+			Relation("Endpoint").
+			Where("webhook_delivery.id IN (?)", bun.In(ids)).
+			Scan(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to load webhook deliveries: %w", err)
+		}
+		return nil
+	})
+	return deliveries, err
+}
+
+// RecordAttempt stores the outcome of an attempt: delivery carries the new
+// status, and the endpoint's consecutive failure count is reset or
+// incremented accordingly. It returns the endpoint's failure count.
+func (p *PostgresRepo) RecordAttempt(ctx context.Context, delivery *model.WebhookDelivery, attempt model.WebhookAttempt) (int, error) {
+	var failures int
+	err := p.DB.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		_, err := tx.NewUpdate().
+			Model(delivery).
+			Column("status", "attempts", "response_code", "last_error", "next_attempt_at", "delivered_at").
+			WherePK().
+			Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to update webhook delivery: %w", err)
+		}
+
+		_, err = tx.NewInsert().Model(&attempt).Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to insert webhook attempt: %w", err)
+		}
+
+		update := tx.NewUpdate().
+			Model((*model.WebhookEndpoint)(nil)).
+			Where("id = ?", delivery.EndpointID).
+			Returning("consecutive_failures")
+		if delivery.Status == model.WebhookDeliverySucceeded {
+			update.Set("consecutive_failures = 0")
+		} else {
+			update.Set("consecutive_failures = consecutive_failures + 1")
+		}
+
+		err = update.Scan(ctx, &failures)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("failed to update webhook endpoint failures: %w", err)
+		}
+		return nil
+	})
+	return failures, err
+}
+
+func (p *PostgresRepo) DisableEndpoint(ctx context.Context, id uuid.UUID, reason string, at time.Time) error {
+	_, err := p.DB.NewUpdate().
+		Model((*model.WebhookEndpoint)(nil)).
+		Set("enabled = false").
+		Set("disabled_reason = ?", reason).
+		Set("updated_at = ?", at).
+		Where("id = ?", id).
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to disable webhook endpoint: %w", err)
+	}
+	return nil
+}
+
+// ListDeliveries returns the most recent deliveries to an endpoint.
+func (p *PostgresRepo) ListDeliveries(ctx context.Context, endpointID uuid.UUID, limit int) ([]model.WebhookDelivery, error) {
+	deliveries := []model.WebhookDelivery{}
+	err := p.DB.NewSelect().
+		Model(&deliveries).
+		Where("endpoint_id = ?", endpointID).
+		Order("created_at DESC").
+		Limit(limit).
+		Scan(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list webhook deliveries: %w", err)
+	}
+	return deliveries, nil
+}
+
+func (p *PostgresRepo) ListAttempts(ctx context.Context, endpointID, deliveryID uuid.UUID) ([]model.WebhookAttempt, error) {
+	exists, err := p.DB.NewSelect().
+		Model((*model.WebhookDelivery)(nil)).
+		Where("id = ?", deliveryID).
+		Where("endpoint_id = ?", endpointID).
+		Exists(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find webhook delivery: %w", err)
+	}
+	if !exists {
+		return nil, ErrNotExist
+	}
+
+	attempts := []model.WebhookAttempt{}
+	err = p.DB.NewSelect().
+		Model(&attempts).
+		Where("delivery_id = ?", deliveryID).
+		Order("id").
+		Scan(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list webhook attempts: %w", err)
+	}
+	return attempts, nil
+}
+
+// Redeliver schedules a delivery to be sent again right away with a fresh
+// set of attempts, whatever its current status.
+func (p *PostgresRepo) Redeliver(ctx context.Context, endpointID, deliveryID uuid.UUID, now time.Time) error {
+	res, err := p.DB.NewUpdate().
+		Model((*model.WebhookDelivery)(nil)).
+		Set("status = ?", model.WebhookDeliveryPending).
+		Set("attempts = 0").
+		Set("next_attempt_at = ?", now).
+		Where("id = ?", deliveryID).
+		Where("endpoint_id = ?", endpointID).
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to redeliver webhook: %w", err)
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotExist
+	}
+	return nil
+}
+
+// DeleteFinishedBefore removes deliveries that succeeded or gave up before
+// cutoff, along with their attempts.
+func (p *PostgresRepo) DeleteFinishedBefore(ctx context.Context, cutoff time.Time) (int64, error) {
+	var n int64
+	err := p.DB.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		finished := tx.NewSelect().
+			Model((*model.WebhookDelivery)(nil)).
+			Column("id").
+			Where("status <> ?", model.WebhookDeliveryPending).
+			Where("created_at < ?", cutoff)
+
+		_, err := tx.NewDelete().
+			Model((*model.WebhookAttempt)(nil)).
+			Where("delivery_id IN (?)", finished).
+			Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to delete webhook attempts: %w", err)
+		}
+
+		res, err := tx.NewDelete().
+			Model((*model.WebhookDelivery)(nil)).
+			Where("status <> ?", model.WebhookDeliveryPending).
+			Where("created_at < ?", cutoff).
+			Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to delete webhook deliveries: %w", err)
+		}
+
+		n, _ = res.RowsAffected()
+		return nil
+	})
+	return n, err
+}
+
+// DeleteBySubject removes every delivery of events about subject, the user
+// ID in the CloudEvent's subject, along with their attempts. It is used to
+// erase a user's history.
+func (p *PostgresRepo) DeleteBySubject(ctx context.Context, subject string) (int64, error) {
+	var n int64
+	err := p.DB.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		about := tx.NewSelect().
+			Model((*model.WebhookDelivery)(nil)).
+			Column("id").
+			Where("convert_from(payload, 'UTF8')::jsonb ->> 'subject' = ?", subject)
+
+		_, err := tx.NewDelete().
+			Model((*model.WebhookAttempt)(nil)).
+			Where("delivery_id IN (?)", about).
+			Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to delete webhook attempts: %w", err)
+		}
+
+		res, err := tx.NewDelete().
+			Model((*model.WebhookDelivery)(nil)).
+			Where("convert_from(payload, 'UTF8')::jsonb ->> 'subject' = ?", subject).
+			Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to delete webhook deliveries: %w", err)
+		}
+
+		n, _ = res.RowsAffected()
+		return nil
+	})
+	return n, err
+}